@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"image/color"
+	"math"
+)
+
+// Lowest foreground/background contrast ratio accepted for codes. Below this
+// scanners struggle to separate dark modules from light ones, especially in
+// poor lighting or on glossy print.
+const minContrastRatio = 3.0
+
+// hexColor formats c as a #rrggbb string.
+func hexColor(c color.RGBA) string {
+	return fmt.Sprintf("#%02x%02x%02x", c.R, c.G, c.B)
+}
+
+// colorName formats c as a #rrggbb string, or "transparent" if it is fully
+// transparent.
+func colorName(c color.RGBA) string {
+	if c.A == 0 {
+		return "transparent"
+	}
+	return hexColor(c)
+}
+
+// relativeLuminance returns the WCAG 2 relative luminance of c, from 0 for
+// black to 1 for white.
+func relativeLuminance(c color.RGBA) float64 {
+	channel := func(v uint8) float64 {
+		s := float64(v) / 255
+		if s <= 0.03928 {
+			return s / 12.92
+		}
+		return math.Pow((s+0.055)/1.055, 2.4)
+	}
+	return 0.2126*channel(c.R) + 0.7152*channel(c.G) + 0.0722*channel(c.B)
+}
+
+// contrastRatio returns the WCAG 2 contrast ratio between two colors, from 1
+// for identical colors to 21 for black on white.
+func contrastRatio(a, b color.RGBA) float64 {
+	la, lb := relativeLuminance(a), relativeLuminance(b)
+	if la < lb {
+		la, lb = lb, la
+	}
+	return (la + 0.05) / (lb + 0.05)
+}
+
+// checkContrast explains why dark modules in fg on a bg background would not
+// scan reliably, or returns nil if the combination is fine. A transparent
+// background is assumed to end up on a light surface.
+func checkContrast(fg, bg color.RGBA) error {
+	bgName := colorName(bg)
+	if bg.A == 0 {
+		bg = color.RGBA{R: 255, G: 255, B: 255, A: 255}
+	}
+	if relativeLuminance(fg) > relativeLuminance(bg) {
+		return fmt.Errorf("Foreground color %s is lighter than background color %s; many scanners cannot read inverted codes",
+			hexColor(fg), bgName)
+	}
+	if ratio := contrastRatio(fg, bg); ratio < minContrastRatio {
+		return fmt.Errorf("Contrast ratio between foreground %s and background %s is %.2f:1, but scanners need at least %.0f:1",
+			hexColor(fg), bgName, ratio, minContrastRatio)
+	}
+	return nil
+}
@@ -0,0 +1,339 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestContrastRatio(t *testing.T) {
+	black := color.RGBA{A: 255}
+	white := color.RGBA{R: 255, G: 255, B: 255, A: 255}
+
+	if r := contrastRatio(black, white); math.Abs(r-21) > 0.01 {
+		t.Fatalf("expected black on white to be 21:1, got %.2f:1", r)
+	}
+	if r := contrastRatio(white, black); math.Abs(r-21) > 0.01 {
+		t.Fatal("contrast ratio should not depend on argument order")
+	}
+	if r := contrastRatio(white, white); r != 1 {
+		t.Fatalf("expected identical colors to be 1:1, got %.2f:1", r)
+	}
+}
+
+func TestCheckContrast(t *testing.T) {
+	cases := []struct {
+		fg, bg string
+		ok     bool
+	}{
+		{"000000", "ffffff", true},
+		{"1a237e", "fff8e1", true},
+		{"777777", "ffffff", true},
+		{"bbbbbb", "ffffff", false}, // too faint
+		{"ffffff", "000000", false}, // inverted
+		{"ff0000", "00ff00", false}, // light fg on a similar background
+	}
+	for _, c := range cases {
+		fg, _ := parseHexColor(c.fg)
+		bg, _ := parseHexColor(c.bg)
+		if err := checkContrast(fg, bg); (err == nil) != c.ok {
+			t.Fatalf("checkContrast(%s, %s) = %v, expected ok=%v", c.fg, c.bg, err, c.ok)
+		}
+	}
+}
+
+func TestQRHandler_Colors_PNG(t *testing.T) {
+	isolateRateLimiter(t)
+
+	req := httptest.NewRequest("GET", "/qr?text=hello&fg=1a237e&bg=%23fff8e1", nil)
+	rr := httptest.NewRecorder()
+	qrHandler(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	img, err := png.Decode(bytes.NewReader(rr.Body.Bytes()))
+	if err != nil {
+		t.Fatalf("failed to decode PNG: %v", err)
+	}
+
+	// The quiet zone is painted in bg, and the finder pattern in fg
+	want := map[string]color.RGBA{
+		"bg": {R: 0xff, G: 0xf8, B: 0xe1, A: 0xff},
+		"fg": {R: 0x1a, G: 0x23, B: 0x7e, A: 0xff},
+	}
+	if got := color.RGBAModel.Convert(img.At(0, 0)).(color.RGBA); got != want["bg"] {
+		t.Fatalf("expected background %v, got %v", want["bg"], got)
+	}
+	found := false
+	for i := 0; i < img.Bounds().Dx() && !found; i++ {
+		found = color.RGBAModel.Convert(img.At(i, i)).(color.RGBA) == want["fg"]
+	}
+	if !found {
+		t.Fatal("expected dark modules in the foreground color")
+	}
+}
+
+func TestQRHandler_Colors_Vector(t *testing.T) {
+	isolateRateLimiter(t)
+
+	req := httptest.NewRequest("GET", "/qr?text=hello&format=svg&fg=1a237e&bg=fff8e1", nil)
+	rr := httptest.NewRecorder()
+	qrHandler(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", rr.Code)
+	}
+	body := rr.Body.String()
+	if !strings.Contains(body, `<rect x="0" y="0" width="29" height="29" fill="#fff8e1"/>`) {
+		t.Fatalf("expected background rect in bg color, got %s", body)
+	}
+	if !strings.Contains(body, `<path fill="#1a237e"`) {
+		t.Fatalf("expected modules in fg color, got %s", body)
+	}
+
+	req = httptest.NewRequest("GET", "/qr?text=hello&format=pdf&fg=1a237e&bg=fff8e1", nil)
+	rr = httptest.NewRecorder()
+	qrHandler(rr, req)
+
+	_, content := parsePDF(t, rr.Body.Bytes()).page(t)
+	if !strings.HasPrefix(content, "1 0.973 0.882 rg\n") {
+		t.Fatalf("expected page filled in bg color, got %q", content)
+	}
+	if !strings.Contains(content, "cm\n0.102 0.137 0.494 rg\n") {
+		t.Fatalf("expected modules in fg color, got %q", content)
+	}
+}
+
+func TestQRHandler_Colors_Invalid(t *testing.T) {
+	isolateRateLimiter(t)
+
+	cases := map[string]string{
+		"fg=zzzzzz":           "fg must be a hex color",
+		"bg=12345":            "bg must be a hex color",
+		"fg=bbbbbb":           "but scanners need at least 3:1",
+		"fg=ffffff&bg=000000": "many scanners cannot read inverted codes",
+	}
+	for query, msg := range cases {
+		resetRateLimiter() // Reset rate limiter before each request
+
+		req := httptest.NewRequest("GET", "/qr?text=hello&"+query, nil)
+		rr := httptest.NewRecorder()
+		qrHandler(rr, req)
+
+		if rr.Code != http.StatusBadRequest {
+			t.Fatalf("expected status 400 for %s, got %d", query, rr.Code)
+		}
+		if !strings.Contains(rr.Body.String(), msg) {
+			t.Fatalf("expected error %q for %s, got %s", msg, query, rr.Body.String())
+		}
+	}
+}
+
+func TestQRHandler_Cache_DifferentColors(t *testing.T) {
+	isolateRateLimiter(t)
+
+	// Test that different colors create different cache entries
+	req1 := httptest.NewRequest("GET", "/qr?text=testcache&fg=000000", nil)
+	rr1 := httptest.NewRecorder()
+	qrHandler(rr1, req1)
+
+	req2 := httptest.NewRequest("GET", "/qr?text=testcache&fg=1a237e", nil)
+	rr2 := httptest.NewRecorder()
+	qrHandler(rr2, req2)
+
+	if bytes.Equal(rr1.Body.Bytes(), rr2.Body.Bytes()) {
+		t.Fatal("different colors should not have same cache entry")
+	}
+}
+
+func TestBarcodeHandler_Colors(t *testing.T) {
+	isolateRateLimiter(t)
+
+	req := httptest.NewRequest("GET", "/barcode?text=1234567890&fg=004d40&bg=e0f2f1", nil)
+	rr := httptest.NewRecorder()
+	barcodeHandler(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	img, err := png.Decode(bytes.NewReader(rr.Body.Bytes()))
+	if err != nil {
+		t.Fatalf("failed to decode PNG: %v", err)
+	}
+
+	// Every pixel is either the fg or the bg color
+	fg := color.RGBA{R: 0x00, G: 0x4d, B: 0x40, A: 0xff}
+	bg := color.RGBA{R: 0xe0, G: 0xf2, B: 0xf1, A: 0xff}
+	seen := map[color.RGBA]bool{}
+	bounds := img.Bounds()
+	for x := bounds.Min.X; x < bounds.Max.X; x++ {
+		seen[color.RGBAModel.Convert(img.At(x, 0)).(color.RGBA)] = true
+	}
+	if len(seen) != 2 || !seen[fg] || !seen[bg] {
+		t.Fatalf("expected only fg and bg pixels, got %v", seen)
+	}
+
+	// Low contrast combinations are rejected on /barcode too
+	req = httptest.NewRequest("GET", "/barcode?text=1234567890&fg=cccccc", nil)
+	rr = httptest.NewRecorder()
+	barcodeHandler(rr, req)
+
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected status 400, got %d", rr.Code)
+	}
+}
+
+// Tests for Transparent Background
+
+func TestQRHandler_Transparent_PNG(t *testing.T) {
+	isolateRateLimiter(t)
+
+	for _, shape := range []string{"square", "rectangle"} {
+		req := httptest.NewRequest("GET", "/qr?text=hello&bg=transparent&shape="+shape, nil)
+		rr := httptest.NewRecorder()
+		qrHandler(rr, req)
+
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected status 200 for %s, got %d: %s", shape, rr.Code, rr.Body.String())
+		}
+		img, err := png.Decode(bytes.NewReader(rr.Body.Bytes()))
+		if err != nil {
+			t.Fatalf("failed to decode PNG: %v", err)
+		}
+		if _, ok := img.(*image.NRGBA); !ok {
+			t.Fatalf("expected an NRGBA PNG for %s, got %T", shape, img)
+		}
+
+		// The quiet zone around the symbol, including the padding of the
+		// rectangle shape, is fully transparent
+		bounds := img.Bounds()
+		corners := []image.Point{{0, 0}, {bounds.Max.X - 1, 0}, {0, bounds.Max.Y - 1}, {bounds.Max.X - 1, bounds.Max.Y - 1}}
+		if shape == "rectangle" {
+			corners = append(corners, image.Point{bounds.Dx()/2 - bounds.Dy()/2 - 1, bounds.Dy() / 2})
+		}
+		for _, p := range corners {
+			if _, _, _, a := img.At(p.X, p.Y).RGBA(); a != 0 {
+				t.Fatalf("expected transparent quiet zone at %v for %s, got alpha %d", p, shape, a)
+			}
+		}
+
+		// Dark modules stay opaque
+		opaque := false
+		for y := bounds.Min.Y; y < bounds.Max.Y && !opaque; y++ {
+			_, _, _, a := img.At(bounds.Dx()/2, y).RGBA()
+			opaque = a == 0xffff
+		}
+		if !opaque {
+			t.Fatalf("expected opaque dark modules for %s", shape)
+		}
+	}
+}
+
+func TestBarcodeHandler_Transparent_PNG(t *testing.T) {
+	isolateRateLimiter(t)
+
+	req := httptest.NewRequest("GET", "/barcode?text=1234567890&bg=transparent&fg=004d40", nil)
+	rr := httptest.NewRecorder()
+	barcodeHandler(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	img, err := png.Decode(bytes.NewReader(rr.Body.Bytes()))
+	if err != nil {
+		t.Fatalf("failed to decode PNG: %v", err)
+	}
+
+	// Outside the bars every pixel is fully transparent, including the
+	// padding left of the first bar; bars are opaque fg
+	bounds := img.Bounds()
+	first := -1
+	for x := bounds.Min.X; x < bounds.Max.X; x++ {
+		c := color.NRGBAModel.Convert(img.At(x, bounds.Dy()/2)).(color.NRGBA)
+		switch c.A {
+		case 0:
+		case 0xff:
+			if c != (color.NRGBA{R: 0x00, G: 0x4d, B: 0x40, A: 0xff}) {
+				t.Fatalf("expected bars in fg color, got %v", c)
+			}
+			if first < 0 {
+				first = x
+			}
+		default:
+			t.Fatalf("unexpected partial alpha %d at x=%d", c.A, x)
+		}
+	}
+	if first <= 0 {
+		t.Fatal("expected transparent padding before the first bar")
+	}
+}
+
+func TestQRHandler_Transparent_Vector(t *testing.T) {
+	isolateRateLimiter(t)
+
+	req := httptest.NewRequest("GET", "/qr?text=hello&format=svg&bg=transparent", nil)
+	rr := httptest.NewRecorder()
+	qrHandler(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", rr.Code)
+	}
+	if doc := parseSVG(t, rr.Body.Bytes()); len(doc.Rects) != 0 {
+		t.Fatal("expected no background rect for a transparent background")
+	}
+
+	req = httptest.NewRequest("GET", "/qr?text=hello&format=pdf&bg=transparent", nil)
+	rr = httptest.NewRecorder()
+	qrHandler(rr, req)
+
+	_, content := parsePDF(t, rr.Body.Bytes()).page(t)
+	if strings.Contains(content[:strings.Index(content, " cm\n")], " re f") {
+		t.Fatalf("expected no background fill for a transparent background, got %q", content)
+	}
+}
+
+func TestQRHandler_Transparent_Contrast(t *testing.T) {
+	isolateRateLimiter(t)
+
+	// A transparent background is checked as if placed on white
+	req := httptest.NewRequest("GET", "/qr?text=hello&bg=transparent&fg=eeeeee", nil)
+	rr := httptest.NewRecorder()
+	qrHandler(rr, req)
+
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected status 400, got %d", rr.Code)
+	}
+
+	// fg cannot be transparent
+	req = httptest.NewRequest("GET", "/qr?text=hello&fg=transparent", nil)
+	rr = httptest.NewRecorder()
+	qrHandler(rr, req)
+
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected status 400, got %d", rr.Code)
+	}
+}
+
+func TestQRHandler_Cache_TransparentBackground(t *testing.T) {
+	isolateRateLimiter(t)
+
+	// Test that a transparent background gets its own cache entry
+	req1 := httptest.NewRequest("GET", "/qr?text=testcache", nil)
+	rr1 := httptest.NewRecorder()
+	qrHandler(rr1, req1)
+
+	req2 := httptest.NewRequest("GET", "/qr?text=testcache&bg=transparent", nil)
+	rr2 := httptest.NewRecorder()
+	qrHandler(rr2, req2)
+
+	if bytes.Equal(rr1.Body.Bytes(), rr2.Body.Bytes()) {
+		t.Fatal("transparent and white backgrounds should not have same cache entry")
+	}
+}
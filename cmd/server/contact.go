@@ -0,0 +1,286 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+
+	qrcode "github.com/skip2/go-qrcode"
+)
+
+// Largest contact JSON document accepted
+const maxContactBody = 64 << 10
+
+// contact is the JSON document /qr/contact turns into a business card.
+type contact struct {
+	// Card format: vcard3 (default), vcard4 or mecard
+	Card string `json:"card"`
+
+	Name struct {
+		First string `json:"first"`
+		Last  string `json:"last"`
+	} `json:"name"`
+	Org    string `json:"org"`
+	Title  string `json:"title"`
+	Phones []struct {
+		Number string `json:"number"`
+		Type   string `json:"type"` // cell, work, home or fax
+	} `json:"phones"`
+	Emails  []string `json:"emails"`
+	URL     string   `json:"url"`
+	Address *struct {
+		Street     string `json:"street"`
+		City       string `json:"city"`
+		Region     string `json:"region"`
+		PostalCode string `json:"postal_code"`
+		Country    string `json:"country"`
+		Type       string `json:"type"` // work or home
+	} `json:"address"`
+	Note string `json:"note"`
+}
+
+// Phone types a contact may use, with the vCard TEL types each stands for
+var contactTelTypes = map[string][]string{
+	"cell": {"cell"},
+	"work": {"work", "voice"},
+	"home": {"home", "voice"},
+	"fax":  {"fax"},
+}
+
+// Address types a contact may use
+var contactAddressTypes = map[string]bool{"work": true, "home": true}
+
+// validate checks the fields every card format needs.
+func (c *contact) validate() error {
+	if c.Name.First == "" && c.Name.Last == "" {
+		return fmt.Errorf("The contact needs a first or last name")
+	}
+	for _, p := range c.Phones {
+		if strings.TrimSpace(p.Number) == "" {
+			return fmt.Errorf("Phone numbers can not be empty")
+		}
+		if _, ok := contactTelTypes[p.Type]; p.Type != "" && !ok {
+			return fmt.Errorf("Phone type must be 'cell', 'work', 'home' or 'fax', got %q", p.Type)
+		}
+	}
+	for _, e := range c.Emails {
+		if !strings.Contains(e, "@") {
+			return fmt.Errorf("Invalid email address %q", e)
+		}
+	}
+	if c.Address != nil && c.Address.Type != "" && !contactAddressTypes[c.Address.Type] {
+		return fmt.Errorf("Address type must be 'work' or 'home', got %q", c.Address.Type)
+	}
+	return nil
+}
+
+// fullName joins the first and last name.
+func (c *contact) fullName() string {
+	return strings.TrimSpace(c.Name.First + " " + c.Name.Last)
+}
+
+// escapeVCard escapes a vCard text value: backslashes, commas, semicolons
+// and line breaks.
+func escapeVCard(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `,`, `\,`, `;`, `\;`, "\r\n", `\n`, "\n", `\n`, "\r", `\n`).Replace(s)
+}
+
+// foldVCard splits a content line longer than 75 octets into lines that
+// continue with a space, without splitting a UTF-8 character.
+func foldVCard(line string) string {
+	var folded strings.Builder
+	limit := 75
+	for len(line) > limit {
+		cut := limit
+		for cut > 0 && !utf8.RuneStart(line[cut]) {
+			cut--
+		}
+		folded.WriteString(line[:cut] + "\r\n ")
+		line = line[cut:]
+		limit = 74 // The leading space counts
+	}
+	folded.WriteString(line)
+	return folded.String()
+}
+
+// vCard writes the contact as a vCard 3.0 (RFC 2426) or 4.0 (RFC 6350).
+func (c *contact) vCard(version string) string {
+	v4 := version == "4.0"
+	var lines []string
+	add := func(line string) { lines = append(lines, foldVCard(line)) }
+	types := func(t ...string) string {
+		// vCard 3.0 spells types in upper case
+		var names []string
+		for _, name := range t {
+			if name == "" {
+				continue
+			}
+			if !v4 {
+				name = strings.ToUpper(name)
+			}
+			names = append(names, name)
+		}
+		if len(names) == 0 {
+			return ""
+		}
+		return ";TYPE=" + strings.Join(names, ",")
+	}
+
+	add("BEGIN:VCARD")
+	add("VERSION:" + version)
+	add("N:" + escapeVCard(c.Name.Last) + ";" + escapeVCard(c.Name.First) + ";;;")
+	add("FN:" + escapeVCard(c.fullName()))
+	if c.Org != "" {
+		add("ORG:" + escapeVCard(c.Org))
+	}
+	if c.Title != "" {
+		add("TITLE:" + escapeVCard(c.Title))
+	}
+	for _, p := range c.Phones {
+		if v4 {
+			// vCard 4.0 prefers phone numbers as tel: URIs
+			number := strings.NewReplacer(" ", "", "(", "", ")", "", ".", "-").Replace(p.Number)
+			add("TEL;VALUE=uri" + types(contactTelTypes[p.Type]...) + ":tel:" + number)
+		} else {
+			add("TEL" + types(contactTelTypes[p.Type]...) + ":" + escapeVCard(p.Number))
+		}
+	}
+	for _, e := range c.Emails {
+		if v4 {
+			add("EMAIL:" + escapeVCard(e))
+		} else {
+			add("EMAIL;TYPE=INTERNET:" + escapeVCard(e))
+		}
+	}
+	if c.URL != "" {
+		add("URL:" + c.URL)
+	}
+	if a := c.Address; a != nil {
+		add("ADR" + types(a.Type) + ":;;" + strings.Join([]string{escapeVCard(a.Street), escapeVCard(a.City),
+			escapeVCard(a.Region), escapeVCard(a.PostalCode), escapeVCard(a.Country)}, ";"))
+	}
+	if c.Note != "" {
+		add("NOTE:" + escapeVCard(c.Note))
+	}
+	add("END:VCARD")
+	return strings.Join(lines, "\r\n") + "\r\n"
+}
+
+// meCard writes the contact as a DoCoMo MeCard, which has no field for a
+// job title.
+func (c *contact) meCard() (string, error) {
+	if c.Title != "" {
+		return "", fmt.Errorf("MeCard has no field for a job title; use a vCard")
+	}
+	fields := []string{"N:" + escapeMeCard(c.Name.Last) + "," + escapeMeCard(c.Name.First)}
+	if c.Org != "" {
+		fields = append(fields, "ORG:"+escapeMeCard(c.Org))
+	}
+	for _, p := range c.Phones {
+		fields = append(fields, "TEL:"+escapeMeCard(p.Number))
+	}
+	for _, e := range c.Emails {
+		fields = append(fields, "EMAIL:"+escapeMeCard(e))
+	}
+	if c.URL != "" {
+		fields = append(fields, "URL:"+escapeMeCard(c.URL))
+	}
+	if a := c.Address; a != nil {
+		// PO box, extended address, street, city, region, postal code and
+		// country, as in a vCard
+		parts := []string{"", "", a.Street, a.City, a.Region, a.PostalCode, a.Country}
+		for i, part := range parts {
+			parts[i] = escapeMeCard(part)
+		}
+		fields = append(fields, "ADR:"+strings.Join(parts, ","))
+	}
+	if c.Note != "" {
+		fields = append(fields, "NOTE:"+escapeMeCard(strings.ReplaceAll(c.Note, "\r\n", "\n")))
+	}
+	return "MECARD:" + strings.Join(fields, ";") + ";;", nil
+}
+
+// payload writes the contact in its card format.
+func (c *contact) payload() (string, error) {
+	if err := c.validate(); err != nil {
+		return "", err
+	}
+	switch c.Card {
+	case "", "vcard3":
+		return c.vCard("3.0"), nil
+	case "vcard4":
+		return c.vCard("4.0"), nil
+	case "mecard":
+		return c.meCard()
+	}
+	return "", fmt.Errorf("Card must be 'vcard3', 'vcard4' or 'mecard'")
+}
+
+// fittingECC picks the highest error-correction level at which text fits in
+// a QR code no larger than level L needs, so the extra robustness costs no
+// size.
+func fittingECC(text string) (string, error) {
+	low, err := qrcode.New(text, qrcode.Low)
+	if err != nil {
+		return "", fmt.Errorf("The contact is too long for a QR code: %d bytes", len(text))
+	}
+	for _, ecc := range []string{"H", "Q", "M"} {
+		if qr, err := qrcode.New(text, eccLevels[ecc]); err == nil && qr.VersionNumber <= low.VersionNumber {
+			return ecc, nil
+		}
+	}
+	return "L", nil
+}
+
+// contactHandler draws a business card QR code from the contact JSON POSTed
+// to it. The query string takes the /qr parameters that control how the
+// code is drawn; without ecc, the highest level that fits is picked.
+func contactHandler(w http.ResponseWriter, r *http.Request) {
+	// Check rate limit per IP
+	if !ipRateLimiter.Allow(getIP(r)) {
+		http.Error(w, "Rate limit exceeded", http.StatusTooManyRequests)
+		return
+	}
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "POST the contact as JSON", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var c contact
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxContactBody))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&c); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			http.Error(w, fmt.Sprintf("The contact must be at most %d KB", maxContactBody>>10), http.StatusRequestEntityTooLarge)
+			return
+		}
+		http.Error(w, "Invalid contact JSON: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	q := r.URL.Query()
+	if err := checkPayloadParams(q); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	text, err := c.payload()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if q.Get("ecc") == "" {
+		ecc, err := fittingECC(text)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		q.Set("ecc", ecc)
+	}
+	q.Set("text", text)
+	serveQR(w, r, q, true)
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	zxqrcode "github.com/makiuchi-d/gozxing/qrcode"
+)
+
+const testContact = `{
+	"name": {"first": "Jane", "last": "Doe"},
+	"org": "Acme, Inc.; Labs",
+	"title": "CTO",
+	"phones": [{"number": "+1 555 0100", "type": "work"}, {"number": "+1 555 0199", "type": "cell"}],
+	"emails": ["jane@example.com"],
+	"url": "https://example.com",
+	"address": {"street": "1 Main St", "city": "Springfield", "region": "IL", "postal_code": "62701", "country": "USA", "type": "work"},
+	"note": "Line 1\nLine 2"
+}`
+
+func postContact(t *testing.T, query, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest("POST", "/qr/contact?"+query, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	rr := httptest.NewRecorder()
+	contactHandler(rr, req)
+	return rr
+}
+
+func parseTestContact(t *testing.T, body string) *contact {
+	t.Helper()
+	var c contact
+	if err := json.Unmarshal([]byte(body), &c); err != nil {
+		t.Fatal(err)
+	}
+	return &c
+}
+
+func TestFoldVCard(t *testing.T) {
+	for _, line := range []string{"NOTE:" + strings.Repeat("x", 200), "NOTE:" + strings.Repeat("é", 100)} {
+		folded := foldVCard(line)
+		for i, part := range strings.Split(folded, "\r\n") {
+			if len(part) > 75 {
+				t.Fatalf("line %d is %d octets long", i, len(part))
+			}
+			if !utf8.ValidString(part) {
+				t.Fatalf("line %d splits a character: %q", i, part)
+			}
+			if i > 0 && !strings.HasPrefix(part, " ") {
+				t.Fatalf("continuation line %d does not start with a space", i)
+			}
+		}
+		if unfolded := strings.ReplaceAll(folded, "\r\n ", ""); unfolded != line {
+			t.Fatalf("unfolding does not restore the line: %q", unfolded)
+		}
+	}
+}
+
+func TestContactPayload(t *testing.T) {
+	cases := map[string]string{
+		"vcard3": "BEGIN:VCARD\r\nVERSION:3.0\r\nN:Doe;Jane;;;\r\nFN:Jane Doe\r\nORG:Acme\\, Inc.\\; Labs\r\nTITLE:CTO\r\n" +
+			"TEL;TYPE=WORK,VOICE:+1 555 0100\r\nTEL;TYPE=CELL:+1 555 0199\r\nEMAIL;TYPE=INTERNET:jane@example.com\r\n" +
+			"URL:https://example.com\r\nADR;TYPE=WORK:;;1 Main St;Springfield;IL;62701;USA\r\nNOTE:Line 1\\nLine 2\r\nEND:VCARD\r\n",
+		"vcard4": "BEGIN:VCARD\r\nVERSION:4.0\r\nN:Doe;Jane;;;\r\nFN:Jane Doe\r\nORG:Acme\\, Inc.\\; Labs\r\nTITLE:CTO\r\n" +
+			"TEL;VALUE=uri;TYPE=work,voice:tel:+15550100\r\nTEL;VALUE=uri;TYPE=cell:tel:+15550199\r\nEMAIL:jane@example.com\r\n" +
+			"URL:https://example.com\r\nADR;TYPE=work:;;1 Main St;Springfield;IL;62701;USA\r\nNOTE:Line 1\\nLine 2\r\nEND:VCARD\r\n",
+	}
+	for card, want := range cases {
+		c := parseTestContact(t, `{"card": "`+card+`", `+testContact[1:])
+		got, err := c.payload()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Fatalf("unexpected %s:\n%s\nwant:\n%s", card, got, want)
+		}
+	}
+
+	// MeCard has no job title
+	c := parseTestContact(t, `{"card": "mecard", `+testContact[1:])
+	c.Title = ""
+	got, err := c.payload()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `MECARD:N:Doe,Jane;ORG:Acme\, Inc.\; Labs;TEL:+1 555 0100;TEL:+1 555 0199;EMAIL:jane@example.com;` +
+		`URL:https\://example.com;ADR:,,1 Main St,Springfield,IL,62701,USA;NOTE:Line 1` + "\n" + `Line 2;;`
+	if got != want {
+		t.Fatalf("unexpected MeCard:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestContactHandler_RoundTrip(t *testing.T) {
+	isolateRateLimiter(t)
+
+	for _, card := range []string{"vcard3", "vcard4"} {
+		resetRateLimiter() // Reset rate limiter before each request
+
+		body := `{"card": "` + card + `", ` + testContact[1:]
+		rr := postContact(t, "", body)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected status 200 for %s, got %d: %s", card, rr.Code, rr.Body.String())
+		}
+		want, _ := parseTestContact(t, body).payload()
+		if got := decodeMatrix(t, zxqrcode.NewQRCodeReader(), rr.Body.Bytes()); got != want {
+			t.Fatalf("expected %q for %s, got %q", want, card, got)
+		}
+
+		// The error-correction level is the highest that fits without
+		// growing the code
+		wantECC, _ := fittingECC(want)
+		if ecc, _ := qrFormatInfo(t, rr.Body.Bytes()); ecc != wantECC {
+			t.Fatalf("expected ECC %s for %s, got %s", wantECC, card, ecc)
+		}
+
+		// The code is cached like any other
+		qrCacheMutex.RLock()
+		cached := false
+		for key := range qrCache {
+			cached = cached || strings.HasPrefix(key, want+":")
+		}
+		qrCacheMutex.RUnlock()
+		if !cached {
+			t.Fatalf("expected the %s code to be cached", card)
+		}
+	}
+
+	// An explicit level is kept
+	resetRateLimiter()
+	rr := postContact(t, "ecc=L", testContact)
+	if ecc, _ := qrFormatInfo(t, rr.Body.Bytes()); ecc != "L" {
+		t.Fatalf("expected ECC L, got %s", ecc)
+	}
+}
+
+func TestFittingECC(t *testing.T) {
+	// A short text fits version 1 at every level, a text just filling
+	// version 1 at level L only at L
+	if ecc, _ := fittingECC("hi"); ecc != "H" {
+		t.Fatalf("expected H for a short text, got %s", ecc)
+	}
+	if ecc, _ := fittingECC(strings.Repeat("x", 17)); ecc != "L" {
+		t.Fatalf("expected L for a full version 1 code, got %s", ecc)
+	}
+	if _, err := fittingECC(strings.Repeat("x", 3000)); err == nil {
+		t.Fatal("expected an error for a text too long for any QR code")
+	}
+}
+
+func TestContactHandler_Invalid(t *testing.T) {
+	isolateRateLimiter(t)
+
+	cases := map[string]string{
+		`{"org": "Acme"}`: "The contact needs a first or last name",
+		`{"name": {"first": "Jane"}, "phones": [{"number": "1", "type": "pager"}]}`: "Phone type must be 'cell', 'work', 'home' or 'fax'",
+		`{"name": {"first": "Jane"}, "phones": [{"number": " "}]}`:                  "Phone numbers can not be empty",
+		`{"name": {"first": "Jane"}, "emails": ["jane"]}`:                           `Invalid email address "jane"`,
+		`{"name": {"first": "Jane"}, "address": {"type": "office"}}`:                "Address type must be 'work' or 'home'",
+		`{"name": {"first": "Jane"}, "card": "vcard2"}`:                             "Card must be 'vcard3', 'vcard4' or 'mecard'",
+		`{"name": {"first": "Jane"}, "title": "CTO", "card": "mecard"}`:             "MeCard has no field for a job title",
+		`{"name": {"first": "Jane"}, "note": "` + strings.Repeat("x", 3000) + `"}`:  "The contact is too long for a QR code",
+		`{"name": {"first": "Jane"}, "nickname": "JD"}`:                             `Invalid contact JSON: json: unknown field "nickname"`,
+		`{"name": `: "Invalid contact JSON",
+	}
+	for body, msg := range cases {
+		resetRateLimiter() // Reset rate limiter before each request
+
+		rr := postContact(t, "", body)
+		if rr.Code != http.StatusBadRequest {
+			t.Fatalf("expected status 400 for %s, got %d", body, rr.Code)
+		}
+		if !strings.Contains(rr.Body.String(), msg) {
+			t.Fatalf("expected error %q for %s, got %s", msg, body, rr.Body.String())
+		}
+	}
+
+	// The code itself is still described by the query string
+	resetRateLimiter()
+	if rr := postContact(t, "size=10", testContact); rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected status 400 for an invalid size, got %d", rr.Code)
+	}
+
+	resetRateLimiter()
+	req := httptest.NewRequest("GET", "/qr/contact", nil)
+	rr := httptest.NewRecorder()
+	contactHandler(rr, req)
+	if rr.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("expected status 405 for GET, got %d", rr.Code)
+	}
+}
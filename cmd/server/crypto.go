@@ -0,0 +1,322 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/crypto/sha3"
+)
+
+const (
+	base58Alphabet = "123456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz"
+	bech32Charset  = "qpzry9x8gf2tvdw0s3jn54khce6mua7l"
+)
+
+// Checksum constants of bech32 (BIP 173), used for segwit version 0
+// addresses, and bech32m (BIP 350), used for version 1 and up
+const (
+	bech32Const  = 1
+	bech32mConst = 0x2bc830a3
+)
+
+// Largest Bitcoin amount, in satoshis: the 21 million coins there will ever be
+const maxSatoshis = 21_000_000 * 100_000_000
+
+var (
+	ethAddressPattern = regexp.MustCompile(`^0x[0-9a-fA-F]{40}$`)
+	btcAmountPattern  = regexp.MustCompile(`^([0-9]{1,8})(\.([0-9]{1,8}))?$`)
+	ethAmountPattern  = regexp.MustCompile(`^([0-9]{1,18})(\.([0-9]{1,18}))?$`)
+)
+
+// decodeBase58Check decodes a base58check string into its version byte and
+// payload, verifying the double SHA-256 checksum.
+func decodeBase58Check(s string) (version byte, payload []byte, err error) {
+	n := new(big.Int)
+	for _, r := range s {
+		i := strings.IndexRune(base58Alphabet, r)
+		if i < 0 {
+			return 0, nil, fmt.Errorf("invalid base58 character %q", r)
+		}
+		n.Mul(n, big.NewInt(58))
+		n.Add(n, big.NewInt(int64(i)))
+	}
+
+	// Leading ones stand for leading zero bytes
+	zeros := len(s) - len(strings.TrimLeft(s, "1"))
+	data := append(make([]byte, zeros), n.Bytes()...)
+	if len(data) < 5 {
+		return 0, nil, fmt.Errorf("too short")
+	}
+	sum := sha256.Sum256(data[:len(data)-4])
+	sum = sha256.Sum256(sum[:])
+	if !bytes.Equal(sum[:4], data[len(data)-4:]) {
+		return 0, nil, fmt.Errorf("invalid checksum")
+	}
+	return data[0], data[1 : len(data)-4], nil
+}
+
+// bech32Polymod computes the BCH checksum of bech32 strings.
+func bech32Polymod(values []byte) uint32 {
+	gen := [5]uint32{0x3b6a57b2, 0x26508e6d, 0x1ea119fa, 0x3d4233dd, 0x2a1462b3}
+	chk := uint32(1)
+	for _, v := range values {
+		b := chk >> 25
+		chk = (chk&0x1ffffff)<<5 ^ uint32(v)
+		for i := 0; i < 5; i++ {
+			if (b>>i)&1 == 1 {
+				chk ^= gen[i]
+			}
+		}
+	}
+	return chk
+}
+
+// decodeBech32 splits a bech32 or bech32m string into its human-readable
+// part and 5-bit data, without the checksum, and returns which checksum
+// constant it verified with.
+func decodeBech32(s string) (hrp string, data []byte, constant uint32, err error) {
+	if len(s) > 90 {
+		return "", nil, 0, fmt.Errorf("too long")
+	}
+	if strings.ToLower(s) != s && strings.ToUpper(s) != s {
+		return "", nil, 0, fmt.Errorf("mixed case")
+	}
+	s = strings.ToLower(s)
+	pos := strings.LastIndexByte(s, '1')
+	if pos < 1 || len(s)-pos-1 < 6 {
+		return "", nil, 0, fmt.Errorf("missing separator or checksum")
+	}
+	hrp = s[:pos]
+
+	// The checksum covers the expanded human-readable part and the data
+	values := make([]byte, 0, 2*len(hrp)+1+len(s)-pos-1)
+	for i := 0; i < len(hrp); i++ {
+		values = append(values, hrp[i]>>5)
+	}
+	values = append(values, 0)
+	for i := 0; i < len(hrp); i++ {
+		values = append(values, hrp[i]&31)
+	}
+	for _, r := range s[pos+1:] {
+		i := strings.IndexRune(bech32Charset, r)
+		if i < 0 {
+			return "", nil, 0, fmt.Errorf("invalid bech32 character %q", r)
+		}
+		data = append(data, byte(i))
+		values = append(values, byte(i))
+	}
+	constant = bech32Polymod(values)
+	if constant != bech32Const && constant != bech32mConst {
+		return "", nil, 0, fmt.Errorf("invalid checksum")
+	}
+	return hrp, data[:len(data)-6], constant, nil
+}
+
+// validateSegwitAddress checks a bech32 native segwit address (BIP 173 and
+// BIP 350) with the given human-readable part.
+func validateSegwitAddress(addr, hrp string) error {
+	got, data, constant, err := decodeBech32(addr)
+	if err != nil {
+		return err
+	}
+	if got != hrp || len(data) < 1 {
+		return fmt.Errorf("not a mainnet address")
+	}
+	version := data[0]
+	if version > 16 {
+		return fmt.Errorf("invalid witness version %d", version)
+	}
+	if (version == 0) != (constant == bech32Const) {
+		return fmt.Errorf("witness version %d uses the wrong checksum", version)
+	}
+
+	// Regroup the 5-bit data into the 8-bit witness program; at most 4
+	// zero bits of padding may be left over
+	var program []byte
+	acc, n := 0, 0
+	for _, v := range data[1:] {
+		acc, n = (acc<<5|int(v))&0xfff, n+5
+		if n >= 8 {
+			n -= 8
+			program = append(program, byte(acc>>n))
+		}
+	}
+	if n > 4 || acc&(1<<n-1) != 0 {
+		return fmt.Errorf("invalid padding")
+	}
+	if len(program) < 2 || len(program) > 40 || version == 0 && len(program) != 20 && len(program) != 32 {
+		return fmt.Errorf("invalid witness program length %d", len(program))
+	}
+	return nil
+}
+
+// validateBitcoinAddress checks a mainnet Bitcoin address: a base58check
+// P2PKH ("1...") or P2SH ("3...") address, or a bech32 segwit ("bc1...")
+// address. Segwit addresses are returned in lower case.
+func validateBitcoinAddress(addr string) (string, error) {
+	var err error
+	// Testnet segwit addresses are decoded too, to say why they fail
+	if lower := strings.ToLower(addr); strings.HasPrefix(lower, "bc1") || strings.HasPrefix(lower, "tb1") {
+		if err = validateSegwitAddress(addr, "bc"); err == nil {
+			return strings.ToLower(addr), nil
+		}
+	} else {
+		var version byte
+		var payload []byte
+		version, payload, err = decodeBase58Check(addr)
+		if err == nil && len(payload) != 20 {
+			err = fmt.Errorf("invalid length")
+		}
+		if err == nil && version != 0x00 && version != 0x05 {
+			err = fmt.Errorf("not a mainnet address")
+		}
+		if err == nil {
+			return addr, nil
+		}
+	}
+	return "", fmt.Errorf("Invalid Bitcoin address %q: %v", addr, err)
+}
+
+// eip55Address writes an Ethereum address with the EIP-55 mixed-case
+// checksum: a letter is upper case when the matching nibble of the
+// Keccak-256 hash of the lower-case hex address is 8 or more.
+func eip55Address(addr string) string {
+	lower := strings.ToLower(addr[2:])
+	h := sha3.NewLegacyKeccak256()
+	h.Write([]byte(lower))
+	hash := hex.EncodeToString(h.Sum(nil))
+	b := []byte(lower)
+	for i, c := range b {
+		if c >= 'a' && hash[i] >= '8' {
+			b[i] = c - 'a' + 'A'
+		}
+	}
+	return "0x" + string(b)
+}
+
+// validateEthereumAddress checks a hex Ethereum address. Mixed-case
+// addresses must carry a valid EIP-55 checksum; all-lower or all-upper case
+// addresses carry none and are returned with one.
+func validateEthereumAddress(addr string) (string, error) {
+	if !ethAddressPattern.MatchString(addr) {
+		return "", fmt.Errorf("Ethereum address must be 0x followed by 40 hex digits, got %q", addr)
+	}
+	checksummed := eip55Address(addr)
+	hexPart := addr[2:]
+	if hexPart != strings.ToLower(hexPart) && hexPart != strings.ToUpper(hexPart) && addr != checksummed {
+		return "", fmt.Errorf("Invalid EIP-55 checksum in Ethereum address %q", addr)
+	}
+	return checksummed, nil
+}
+
+// parseDecimalAmount reads an amount in whole coins with at most decimals
+// places and returns it in the smallest unit, as a decimal string without
+// leading zeros.
+func parseDecimalAmount(s string, pattern *regexp.Regexp, decimals int) (string, error) {
+	m := pattern.FindStringSubmatch(s)
+	if m == nil {
+		return "", fmt.Errorf("Amount must be a number with at most %d decimals, such as 0.05", decimals)
+	}
+	units := strings.TrimLeft(m[1]+m[3]+strings.Repeat("0", decimals-len(m[3])), "0")
+	if units == "" {
+		return "", fmt.Errorf("Amount must be greater than 0")
+	}
+	return units, nil
+}
+
+// checkPaymentText checks the label or message of a payment URI.
+func checkPaymentText(q url.Values, param string) (string, error) {
+	s := q.Get(param)
+	if n := utf8.RuneCountInString(s); n > 255 {
+		return "", fmt.Errorf("The %s can be at most 255 characters, got %d", param, n)
+	}
+	return s, nil
+}
+
+// bitcoinPayload builds a BIP 21 "bitcoin:" payment URI from the address,
+// amount (in BTC), label and message parameters.
+func bitcoinPayload(q url.Values) (string, error) {
+	if q.Get("address") == "" {
+		return "", fmt.Errorf("Please provide an 'address' parameter")
+	}
+	addr, err := validateBitcoinAddress(q.Get("address"))
+	if err != nil {
+		return "", err
+	}
+
+	var params []string
+	if s := q.Get("amount"); s != "" {
+		units, err := parseDecimalAmount(s, btcAmountPattern, 8)
+		if err != nil {
+			return "", err
+		}
+		sats, _ := strconv.ParseInt(units, 10, 64)
+		if sats > maxSatoshis {
+			return "", fmt.Errorf("Amount can be at most 21000000 BTC")
+		}
+		// Written in BTC without trailing zeros
+		amount := strconv.FormatInt(sats/100_000_000, 10)
+		if frac := strings.TrimRight(fmt.Sprintf("%08d", sats%100_000_000), "0"); frac != "" {
+			amount += "." + frac
+		}
+		params = append(params, "amount="+amount)
+	}
+	for _, param := range []string{"label", "message"} {
+		s, err := checkPaymentText(q, param)
+		if err != nil {
+			return "", err
+		}
+		if s != "" {
+			params = append(params, param+"="+percentEncode(s, ""))
+		}
+	}
+
+	uri := "bitcoin:" + addr
+	if len(params) > 0 {
+		uri += "?" + strings.Join(params, "&")
+	}
+	return uri, nil
+}
+
+// ethereumPayload builds an EIP-681 "ethereum:" payment URI from the
+// address, chain_id and amount (in ether) parameters. The amount is written
+// in wei as the value parameter.
+func ethereumPayload(q url.Values) (string, error) {
+	if q.Get("address") == "" {
+		return "", fmt.Errorf("Please provide an 'address' parameter")
+	}
+	addr, err := validateEthereumAddress(q.Get("address"))
+	if err != nil {
+		return "", err
+	}
+	for _, param := range []string{"label", "message"} {
+		if q.Get(param) != "" {
+			return "", fmt.Errorf("%s is only used with bitcoin: URIs", param)
+		}
+	}
+
+	uri := "ethereum:" + addr
+	if s := q.Get("chain_id"); s != "" {
+		id, err := strconv.ParseUint(s, 10, 64)
+		if err != nil || id == 0 {
+			return "", fmt.Errorf("Chain ID must be a whole number of at least 1, such as 1 for Ethereum mainnet")
+		}
+		uri += "@" + strconv.FormatUint(id, 10)
+	}
+	if s := q.Get("amount"); s != "" {
+		wei, err := parseDecimalAmount(s, ethAmountPattern, 18)
+		if err != nil {
+			return "", err
+		}
+		uri += "?value=" + wei
+	}
+	return uri, nil
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	zxqrcode "github.com/makiuchi-d/gozxing/qrcode"
+)
+
+func TestEIP55Address(t *testing.T) {
+	// Test vectors from EIP-55
+	for _, want := range []string{
+		"0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAed",
+		"0xfB6916095ca1df60bB79Ce92cE3Ea74c37c5d359",
+		"0xdbF03B407c01E7cD3CBea99509d93f8DDDC8C6FB",
+		"0xD1220A0cf47c7B9Be7A2E6BA89F429762e7b9aDb",
+	} {
+		for _, addr := range []string{want, strings.ToLower(want), "0x" + strings.ToUpper(want[2:])} {
+			got, err := validateEthereumAddress(addr)
+			if err != nil {
+				t.Fatalf("unexpected error for %s: %v", addr, err)
+			}
+			if got != want {
+				t.Fatalf("expected %s for %s, got %s", want, addr, got)
+			}
+		}
+	}
+}
+
+func TestValidateBitcoinAddress(t *testing.T) {
+	valid := map[string]string{
+		"1A1zP1eP5QGefi2DMPTfTL5SLmv7DivfNa":                             "1A1zP1eP5QGefi2DMPTfTL5SLmv7DivfNa",
+		"3J98t1WpEZ73CNmQviecrnyiWrnqRhWNLy":                             "3J98t1WpEZ73CNmQviecrnyiWrnqRhWNLy",
+		"BC1QW508D6QEJXTDG4Y5R3ZARVARY0C5XW7KV8F3T4":                     "bc1qw508d6qejxtdg4y5r3zarvary0c5xw7kv8f3t4",
+		"bc1qrp33g0q5c5txsp9arysrx4k6zdkfs4nce4xj0gdcccefvpysxf3qccfmv3": "bc1qrp33g0q5c5txsp9arysrx4k6zdkfs4nce4xj0gdcccefvpysxf3qccfmv3",
+		"bc1p0xlxvlhemja6c4dqv22uapctqupfhlxm9h8z3k2e72q4k9hcz7vqzk5jj0": "bc1p0xlxvlhemja6c4dqv22uapctqupfhlxm9h8z3k2e72q4k9hcz7vqzk5jj0",
+	}
+	for addr, want := range valid {
+		got, err := validateBitcoinAddress(addr)
+		if err != nil {
+			t.Fatalf("unexpected error for %s: %v", addr, err)
+		}
+		if got != want {
+			t.Fatalf("expected %s for %s, got %s", want, addr, got)
+		}
+	}
+
+	invalid := map[string]string{
+		"1A1zP1eP5QGefi2DMPTfTL5SLmv7DivfNb":                             "invalid checksum",
+		"1A1zP1eP5QGefi2DMPTfTL5SLmv7DivfN0":                             "invalid base58 character '0'",
+		"mipcBbFg9gMiCh81Kj8tqqdgoZub1ZJRfn":                             "not a mainnet address",
+		"bc1qw508d6qejxtdg4y5r3zarvary0c5xw7kv8f3t5":                     "invalid checksum",
+		"bc1qw508d6qejxtdg4y5r3zarvaRY0c5xw7kv8f3t4":                     "mixed case",
+		"tb1qrp33g0q5c5txsp9arysrx4k6zdkfs4nce4xj0gdcccefvpysxf3q0sl5k7": "not a mainnet address",
+		"bc1zw508d6qejxtdg4y5r3zarvaryvqyzf3du":                          "uses the wrong checksum",
+		"bc1qr508d6qejxtdg4y5r3zarvary0c5xw7kv8f3t4":                     "invalid checksum",
+		"bc1p0xlxvlhemja6c4dqv22uapctqupfhlxm9h8z3k2e72q4k9hcz7vqh2y7hd": "witness version 1 uses the wrong checksum",
+	}
+	for addr, msg := range invalid {
+		_, err := validateBitcoinAddress(addr)
+		if err == nil || !strings.Contains(err.Error(), msg) {
+			t.Fatalf("expected error %q for %s, got %v", msg, addr, err)
+		}
+	}
+}
+
+func TestCryptoPayloads(t *testing.T) {
+	cases := []struct {
+		build func(q url.Values) (string, error)
+		query url.Values
+		want  string
+	}{
+		{bitcoinPayload, url.Values{"address": {"1A1zP1eP5QGefi2DMPTfTL5SLmv7DivfNa"}}, "bitcoin:1A1zP1eP5QGefi2DMPTfTL5SLmv7DivfNa"},
+		{bitcoinPayload, url.Values{"address": {"bc1qw508d6qejxtdg4y5r3zarvary0c5xw7kv8f3t4"}, "amount": {"0.00150000"},
+			"label": {"Luke-Jr"}, "message": {"Donation for project xyz & co"}},
+			"bitcoin:bc1qw508d6qejxtdg4y5r3zarvary0c5xw7kv8f3t4?amount=0.0015&label=Luke-Jr&message=Donation%20for%20project%20xyz%20%26%20co"},
+		{bitcoinPayload, url.Values{"address": {"3J98t1WpEZ73CNmQviecrnyiWrnqRhWNLy"}, "amount": {"20.3"}}, "bitcoin:3J98t1WpEZ73CNmQviecrnyiWrnqRhWNLy?amount=20.3"},
+		{ethereumPayload, url.Values{"address": {"0x5aaeb6053f3e94c9b9a09f33669435e7ef1beaed"}}, "ethereum:0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAed"},
+		{ethereumPayload, url.Values{"address": {"0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAed"}, "chain_id": {"1"}, "amount": {"2.014"}},
+			"ethereum:0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAed@1?value=2014000000000000000"},
+		{ethereumPayload, url.Values{"address": {"0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAed"}, "amount": {"0.000000000000000001"}},
+			"ethereum:0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAed?value=1"},
+	}
+	for _, tc := range cases {
+		got, err := tc.build(tc.query)
+		if err != nil {
+			t.Fatalf("unexpected error for %v: %v", tc.query, err)
+		}
+		if got != tc.want {
+			t.Fatalf("expected %q for %v, got %q", tc.want, tc.query, got)
+		}
+	}
+}
+
+func TestCryptoHandlers_RoundTrip(t *testing.T) {
+	isolateRateLimiter(t)
+
+	q := url.Values{"address": {"bc1qw508d6qejxtdg4y5r3zarvary0c5xw7kv8f3t4"}, "amount": {"0.01"}, "label": {"Open Source Fund"}}
+	want, err := bitcoinPayload(q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest("GET", "/qr/bitcoin?"+q.Encode(), nil)
+	rr := httptest.NewRecorder()
+	payloadHandler(bitcoinPayload, "")(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if got := decodeMatrix(t, zxqrcode.NewQRCodeReader(), rr.Body.Bytes()); got != want {
+		t.Fatalf("expected %q, got %q", want, got)
+	}
+}
+
+func TestCryptoHandlers_Invalid(t *testing.T) {
+	isolateRateLimiter(t)
+
+	const btc = "address=1A1zP1eP5QGefi2DMPTfTL5SLmv7DivfNa"
+	const eth = "address=0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAed"
+	cases := []struct {
+		build func(q url.Values) (string, error)
+		query string
+		msg   string
+	}{
+		{bitcoinPayload, "amount=1", "Please provide an 'address' parameter"},
+		{bitcoinPayload, "address=1A1zP1eP5QGefi2DMPTfTL5SLmv7DivfNb", "Invalid Bitcoin address \"1A1zP1eP5QGefi2DMPTfTL5SLmv7DivfNb\": invalid checksum"},
+		{bitcoinPayload, btc + "&amount=0.000000001", "Amount must be a number with at most 8 decimals"},
+		{bitcoinPayload, btc + "&amount=1e3", "Amount must be a number with at most 8 decimals"},
+		{bitcoinPayload, btc + "&amount=0.00", "Amount must be greater than 0"},
+		{bitcoinPayload, btc + "&amount=21000000.00000001", "Amount can be at most 21000000 BTC"},
+		{bitcoinPayload, btc + "&label=" + strings.Repeat("x", 256), "The label can be at most 255 characters, got 256"},
+		{bitcoinPayload, btc + "&text=bitcoin:", "remove the 'text' parameter"},
+		{ethereumPayload, "address=0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAe", "Ethereum address must be 0x followed by 40 hex digits"},
+		{ethereumPayload, "address=0x5AAeb6053F3E94C9b9A09f33669435E7Ef1BeAed", "Invalid EIP-55 checksum"},
+		{ethereumPayload, eth + "&chain_id=0", "Chain ID must be a whole number of at least 1"},
+		{ethereumPayload, eth + "&amount=1.0000000000000000001", "Amount must be a number with at most 18 decimals"},
+		{ethereumPayload, eth + "&message=Thanks", "message is only used with bitcoin: URIs"},
+	}
+	for _, tc := range cases {
+		resetRateLimiter() // Reset rate limiter before each request
+
+		req := httptest.NewRequest("GET", "/qr/crypto?"+tc.query, nil)
+		rr := httptest.NewRecorder()
+		payloadHandler(tc.build, "")(rr, req)
+
+		if rr.Code != http.StatusBadRequest {
+			t.Fatalf("expected status 400 for %s, got %d", tc.query, rr.Code)
+		}
+		if !strings.Contains(rr.Body.String(), tc.msg) {
+			t.Fatalf("expected error %q for %s, got %s", tc.msg, tc.query, rr.Body.String())
+		}
+	}
+}
@@ -0,0 +1,372 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif" // Images to decode may be uploaded as GIF
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/makiuchi-d/gozxing"
+	"github.com/makiuchi-d/gozxing/aztec"
+	"github.com/makiuchi-d/gozxing/datamatrix"
+	qrmulti "github.com/makiuchi-d/gozxing/multi/qrcode/detector"
+	"github.com/makiuchi-d/gozxing/oned"
+	qrdecoder "github.com/makiuchi-d/gozxing/qrcode/decoder"
+)
+
+// Largest accepted upload to /decode, including any multipart framing
+const maxDecodeUpload = 10 << 20
+
+// Largest accepted dimensions of an image to decode, checked before decoding
+// the pixels
+const maxDecodePixels = 4096
+
+// Most images accepted in one upload, enough for the symbols of a message
+// split with Structured Append
+const maxDecodeImages = maxAppendSymbols
+
+// Names of the symbologies the decoder reads, matching the type names of
+// /qr and /barcode. PDF417 and standard 2 of 5 can not be read.
+var decodeSymbologies = map[gozxing.BarcodeFormat]string{
+	gozxing.BarcodeFormat_QR_CODE:     "qr",
+	gozxing.BarcodeFormat_DATA_MATRIX: "datamatrix",
+	gozxing.BarcodeFormat_AZTEC:       "aztec",
+	gozxing.BarcodeFormat_CODE_128:    "code128",
+	gozxing.BarcodeFormat_CODE_39:     "code39",
+	gozxing.BarcodeFormat_CODE_93:     "code93",
+	gozxing.BarcodeFormat_CODABAR:     "codabar",
+	gozxing.BarcodeFormat_EAN_13:      "ean13",
+	gozxing.BarcodeFormat_EAN_8:       "ean8",
+	gozxing.BarcodeFormat_UPC_A:       "upca",
+	gozxing.BarcodeFormat_UPC_E:       "upce",
+	gozxing.BarcodeFormat_ITF:         "2of5",
+}
+
+// decodePoint is a position in the decoded image, in pixels.
+type decodePoint struct {
+	X float64 `json:"x"`
+	Y float64 `json:"y"`
+}
+
+// decodedCode is a code found in an image.
+type decodedCode struct {
+	Text      string `json:"text"`
+	Symbology string `json:"symbology"`
+
+	// Error-correction level and version, for QR codes
+	ECC     string `json:"ecc,omitempty"`
+	Version int    `json:"version,omitempty"`
+
+	// The corners of a QR code clockwise from its top left, or the points
+	// other readers report: the corners of a Data Matrix or Aztec symbol,
+	// or the ends of the row a 1D barcode was read along
+	Corners []decodePoint `json:"corners"`
+
+	// Where a QR code split with Structured Append belongs in its message
+	Append *decodedAppend `json:"structured_append,omitempty"`
+
+	// The uploaded image the code was found in, counting from 1, when
+	// several are uploaded
+	Image int `json:"image,omitempty"`
+}
+
+// decodedAppend is the Structured Append header of a QR code: its position
+// among the symbols of the message, counting from 1, the number of symbols
+// and the parity of the whole message.
+type decodedAppend struct {
+	Position int `json:"position"`
+	Total    int `json:"total"`
+	Parity   int `json:"parity"`
+}
+
+// appendedMessage is a message reassembled from QR codes split with
+// Structured Append, or the reason it could not be.
+type appendedMessage struct {
+	Text    string `json:"text,omitempty"`
+	Symbols int    `json:"symbols"`
+	Parity  int    `json:"parity"`
+	Error   string `json:"error,omitempty"`
+}
+
+// qrCorners extrapolates the outer corners of a QR code of the given
+// version from the centers of its finder patterns, which lie 3.5 modules
+// in from the edges. They are returned clockwise from the top left.
+func qrCorners(bottomLeft, topLeft, topRight gozxing.ResultPoint, version int) []decodePoint {
+	span := float64(qrSymbolSize(version) - 7)
+	ux, uy := (topRight.GetX()-topLeft.GetX())/span, (topRight.GetY()-topLeft.GetY())/span
+	vx, vy := (bottomLeft.GetX()-topLeft.GetX())/span, (bottomLeft.GetY()-topLeft.GetY())/span
+	corner := func(p gozxing.ResultPoint, u, v float64) decodePoint {
+		return decodePoint{p.GetX() + u*ux + v*vx, p.GetY() + u*uy + v*vy}
+	}
+	bottomRight := gozxing.NewResultPoint(topRight.GetX()+bottomLeft.GetX()-topLeft.GetX(), topRight.GetY()+bottomLeft.GetY()-topLeft.GetY())
+	return []decodePoint{
+		corner(topLeft, -3.5, -3.5),
+		corner(topRight, 3.5, -3.5),
+		corner(bottomRight, 3.5, 3.5),
+		corner(bottomLeft, -3.5, 3.5),
+	}
+}
+
+// decodeQRCodes finds and decodes every QR code in the image.
+func decodeQRCodes(bmp *gozxing.BinaryBitmap, hints map[gozxing.DecodeHintType]interface{}) []decodedCode {
+	matrix, err := bmp.GetBlackMatrix()
+	if err != nil {
+		return nil
+	}
+	detected, err := qrmulti.NewMultiDetector(matrix).DetectMulti(hints)
+	if err != nil {
+		return nil
+	}
+
+	var codes []decodedCode
+	decoder := qrdecoder.NewDecoder()
+	for _, d := range detected {
+		result, err := decoder.Decode(d.GetBits(), hints)
+		if err != nil {
+			continue
+		}
+		points := d.GetPoints()
+		if metadata, ok := result.GetOther().(*qrdecoder.QRCodeDecoderMetaData); ok {
+			metadata.ApplyMirroredCorrection(points)
+		}
+		version := (d.GetBits().GetWidth() - 17) / 4
+		code := decodedCode{
+			Text:      result.GetText(),
+			Symbology: "qr",
+			ECC:       result.GetECLevel(),
+			Version:   version,
+			Corners:   qrCorners(points[0], points[1], points[2], version),
+		}
+		if result.HasStructuredAppend() {
+			sequence := result.GetStructuredAppendSequenceNumber()
+			code.Append = &decodedAppend{
+				Position: sequence>>4 + 1,
+				Total:    sequence&0xf + 1,
+				Parity:   result.GetStructuredAppendParity(),
+			}
+		}
+		codes = append(codes, code)
+	}
+	return codes
+}
+
+// reassembleMessages joins the QR codes split with Structured Append back
+// into their messages, in the order the messages were first found. The
+// symbols of a message are told apart from those of others by their number
+// and parity, and symbols found twice are read once. Symbols whose position
+// lies outside their number of symbols are left out.
+func reassembleMessages(codes []decodedCode) []appendedMessage {
+	type key struct{ total, parity int }
+	var order []key
+	parts := map[key][]*string{}
+	for _, code := range codes {
+		a := code.Append
+		if a == nil || a.Position < 1 || a.Position > a.Total {
+			continue
+		}
+		k := key{a.Total, a.Parity}
+		if parts[k] == nil {
+			order = append(order, k)
+			parts[k] = make([]*string, a.Total)
+		}
+		text := code.Text
+		parts[k][a.Position-1] = &text
+	}
+
+	messages := make([]appendedMessage, 0, len(order))
+	for _, k := range order {
+		m := appendedMessage{Symbols: k.total, Parity: k.parity}
+		var text strings.Builder
+		var missing []string
+		for i, part := range parts[k] {
+			if part == nil {
+				missing = append(missing, fmt.Sprint(i+1))
+				continue
+			}
+			text.WriteString(*part)
+		}
+		switch {
+		case missing != nil:
+			m.Error = fmt.Sprintf("Symbols %s of %d are missing", strings.Join(missing, ", "), k.total)
+		case int(appendParity(text.String())) != k.parity:
+			m.Error = "The reassembled text does not match the parity of its symbols"
+		default:
+			m.Text = text.String()
+		}
+		messages = append(messages, m)
+	}
+	return messages
+}
+
+// decodeImage finds the codes in an image: every QR code, and at most one
+// code of each other symbology. Transparent pixels are read as white.
+func decodeImage(img image.Image) []decodedCode {
+	bmp, err := gozxing.NewBinaryBitmapFromImage(img)
+	if err != nil {
+		return nil
+	}
+	// Codabar start and stop characters are kept, as /barcode takes them
+	// as part of the text. Listing the formats lets a UPC-A code, which
+	// reads as an EAN-13 code starting with 0, be reported as UPC-A.
+	formats := make([]gozxing.BarcodeFormat, 0, len(decodeSymbologies))
+	for format := range decodeSymbologies {
+		formats = append(formats, format)
+	}
+	hints := map[gozxing.DecodeHintType]interface{}{
+		gozxing.DecodeHintType_TRY_HARDER:               true,
+		gozxing.DecodeHintType_RETURN_CODABAR_START_END: true,
+		gozxing.DecodeHintType_POSSIBLE_FORMATS:         formats,
+	}
+	codes := decodeQRCodes(bmp, hints)
+
+	readers := []gozxing.Reader{
+		datamatrix.NewDataMatrixReader(),
+		aztec.NewAztecReader(),
+		oned.NewCode128Reader(),
+		oned.NewCode39Reader(),
+		oned.NewCode93Reader(),
+		oned.NewCodaBarReader(),
+		oned.NewMultiFormatUPCEANReader(hints),
+		oned.NewITFReader(),
+	}
+	for _, reader := range readers {
+		result, err := reader.Decode(bmp, hints)
+		if err != nil {
+			continue
+		}
+		code := decodedCode{
+			Text:      result.GetText(),
+			Symbology: decodeSymbologies[result.GetBarcodeFormat()],
+			Corners:   []decodePoint{},
+		}
+		if ecc, ok := result.GetResultMetadata()[gozxing.ResultMetadataType_ERROR_CORRECTION_LEVEL].(string); ok {
+			code.ECC = ecc
+		}
+		for _, p := range result.GetResultPoints() {
+			code.Corners = append(code.Corners, decodePoint{p.GetX(), p.GetY()})
+		}
+		codes = append(codes, code)
+	}
+	return codes
+}
+
+// readDecodeUpload reads the images POSTed to /decode, either as multipart
+// 'image' files or as the raw request body.
+func readDecodeUpload(w http.ResponseWriter, r *http.Request) ([][]byte, error) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxDecodeUpload)
+	if !strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		data, err := io.ReadAll(r.Body)
+		if err != nil {
+			return nil, err
+		}
+		return [][]byte{data}, nil
+	}
+
+	if err := r.ParseMultipartForm(maxDecodeUpload); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("Please upload the image as a multipart 'image' file, or as the request body")
+	}
+	files := r.MultipartForm.File["image"]
+	if len(files) == 0 {
+		return nil, fmt.Errorf("Please upload the image as a multipart 'image' file")
+	}
+	if len(files) > maxDecodeImages {
+		return nil, fmt.Errorf("Please upload at most %d images at a time", maxDecodeImages)
+	}
+	images := make([][]byte, len(files))
+	for i, header := range files {
+		file, err := header.Open()
+		if err != nil {
+			return nil, err
+		}
+		images[i], err = io.ReadAll(file)
+		file.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return images, nil
+}
+
+// decodeUpload decodes one uploaded image, checking its dimensions before
+// decoding the pixels, so that a small file can not expand into a huge
+// image.
+func decodeUpload(data []byte) (image.Image, error) {
+	config, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil || (format != "png" && format != "jpeg" && format != "gif") {
+		return nil, fmt.Errorf("The image must be a PNG, JPEG or GIF")
+	}
+	if config.Width > maxDecodePixels || config.Height > maxDecodePixels {
+		return nil, fmt.Errorf("The image must be at most %dx%d pixels", maxDecodePixels, maxDecodePixels)
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("The image must be a PNG, JPEG or GIF")
+	}
+	return img, nil
+}
+
+// decodeHandler reads the QR codes and barcodes in the PNG, JPEG or GIF
+// images POSTed to it and returns them as JSON, along with the messages of
+// QR codes split with Structured Append.
+func decodeHandler(w http.ResponseWriter, r *http.Request) {
+	// Check rate limit per IP
+	if !ipRateLimiter.Allow(getIP(r)) {
+		http.Error(w, "Rate limit exceeded", http.StatusTooManyRequests)
+		return
+	}
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "POST the image to decode", http.StatusMethodNotAllowed)
+		return
+	}
+
+	uploads, err := readDecodeUpload(w, r)
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			http.Error(w, fmt.Sprintf("The image must be at most %d MB", maxDecodeUpload>>20), http.StatusRequestEntityTooLarge)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var codes []decodedCode
+	for i, data := range uploads {
+		img, err := decodeUpload(data)
+		if err != nil {
+			if len(uploads) > 1 {
+				err = fmt.Errorf("Image %d: %v", i+1, err)
+			}
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		found := decodeImage(img)
+		if len(uploads) > 1 {
+			for j := range found {
+				found[j].Image = i + 1
+			}
+		}
+		codes = append(codes, found...)
+	}
+	if len(codes) == 0 {
+		http.Error(w, "No QR code or barcode was found in the image", http.StatusUnprocessableEntity)
+		return
+	}
+
+	// Codes split with Structured Append are also joined back together
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		Codes    []decodedCode     `json:"codes"`
+		Messages []appendedMessage `json:"messages,omitempty"`
+	}{codes, reassembleMessages(codes)})
+}
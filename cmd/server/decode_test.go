@@ -0,0 +1,225 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"image"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"math"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// decodeOutput reads the single code in a PNG drawn by one of the handlers.
+func decodeOutput(t *testing.T, data []byte) decodedCode {
+	t.Helper()
+	img, err := png.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("failed to decode PNG: %v", err)
+	}
+	codes := decodeImage(img)
+	if len(codes) != 1 {
+		t.Fatalf("expected one code, found %+v", codes)
+	}
+	return codes[0]
+}
+
+// decodeRequest builds a POST to /decode uploading each of images as a
+// multipart 'image' file.
+func decodeRequest(t *testing.T, images ...[]byte) *http.Request {
+	t.Helper()
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	for _, data := range images {
+		part, err := mw.CreateFormFile("image", "code.png")
+		if err != nil {
+			t.Fatal(err)
+		}
+		part.Write(data)
+	}
+	if err := mw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest("POST", "/decode", &body)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	return req
+}
+
+func TestDecodeImage_QRHandler(t *testing.T) {
+	isolateRateLimiter(t)
+
+	cases := []struct {
+		query     string
+		text      string
+		symbology string
+		ecc       string
+		version   int
+	}{
+		{"text=HelloWorld", "HelloWorld", "qr", "M", 1},
+		{"text=HelloWorld&ecc=H&fg=1a237e&bg=fff8e1", "HelloWorld", "qr", "H", 2},
+		{"text=HelloWorld&bg=transparent", "HelloWorld", "qr", "M", 1},
+		{"text=HelloWorld&gradient=1a237e,00838f", "HelloWorld", "qr", "M", 1},
+		{"text=" + strings.Repeat("0123456789", 20) + "&ecc=L&size=600", strings.Repeat("0123456789", 20), "qr", "L", 5},
+		{"text=SHIP123&type=datamatrix", "SHIP123", "datamatrix", "", 0},
+		{"text=SHIP123&type=aztec", "SHIP123", "aztec", "", 0},
+		{"text=SHIP123&type=barcode", "SHIP123", "code128", "", 0},
+		{"text=5901234123457&type=ean13", "5901234123457", "ean13", "", 0},
+	}
+	for _, tc := range cases {
+		resetRateLimiter() // Reset rate limiter before each request
+
+		req := httptest.NewRequest("GET", "/qr?"+tc.query, nil)
+		rr := httptest.NewRecorder()
+		qrHandler(rr, req)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected status 200 for %s, got %d: %s", tc.query, rr.Code, rr.Body.String())
+		}
+		code := decodeOutput(t, rr.Body.Bytes())
+		if code.Text != tc.text || code.Symbology != tc.symbology {
+			t.Fatalf("expected %s %q for %s, got %s %q", tc.symbology, tc.text, tc.query, code.Symbology, code.Text)
+		}
+		if tc.symbology == "qr" && (code.ECC != tc.ecc || code.Version != tc.version || len(code.Corners) != 4) {
+			t.Fatalf("expected level %s version %d with 4 corners for %s, got %+v", tc.ecc, tc.version, tc.query, code)
+		}
+	}
+}
+
+func TestDecodeImage_QRCorners(t *testing.T) {
+	isolateRateLimiter(t)
+
+	// A version 1 code with a 4-module quiet zone is 29 modules of 10 pixels
+	req := httptest.NewRequest("GET", "/qr?text=HelloWorld&size=290", nil)
+	rr := httptest.NewRecorder()
+	qrHandler(rr, req)
+	code := decodeOutput(t, rr.Body.Bytes())
+
+	want := []decodePoint{{40, 40}, {250, 40}, {250, 250}, {40, 250}}
+	for i, p := range code.Corners {
+		if math.Abs(p.X-want[i].X) > 2 || math.Abs(p.Y-want[i].Y) > 2 {
+			t.Fatalf("expected corners %v, got %v", want, code.Corners)
+		}
+	}
+}
+
+func TestDecodeImage_BarcodeHandler(t *testing.T) {
+	isolateRateLimiter(t)
+
+	cases := []struct {
+		query     string
+		text      string
+		symbology string
+	}{
+		{"text=1234567890", "1234567890", "code128"},
+		{"text=ABC-123&symbology=code39", "ABC-123", "code39"},
+		{"text=ABC-123&symbology=code93", "ABC-123", "code93"},
+		{"text=A40156B&symbology=codabar", "A40156B", "codabar"},
+		{"text=5901234123457&symbology=ean13", "5901234123457", "ean13"},
+		{"text=96385074&symbology=ean8", "96385074", "ean8"},
+		{"text=036000291452&symbology=upca", "036000291452", "upca"},
+		{"text=01234565&symbology=upce", "01234565", "upce"},
+		{"text=12345670&symbology=2of5", "12345670", "2of5"},
+		{"text=1234567890&hrt=true&fg=1a237e", "1234567890", "code128"},
+	}
+	for _, tc := range cases {
+		resetRateLimiter() // Reset rate limiter before each request
+
+		req := httptest.NewRequest("GET", "/barcode?"+tc.query, nil)
+		rr := httptest.NewRecorder()
+		barcodeHandler(rr, req)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected status 200 for %s, got %d: %s", tc.query, rr.Code, rr.Body.String())
+		}
+		code := decodeOutput(t, rr.Body.Bytes())
+		if code.Text != tc.text || code.Symbology != tc.symbology {
+			t.Fatalf("expected %s %q for %s, got %s %q", tc.symbology, tc.text, tc.query, code.Symbology, code.Text)
+		}
+	}
+}
+
+func TestDecodeHandler(t *testing.T) {
+	isolateRateLimiter(t)
+
+	req := httptest.NewRequest("GET", "/qr?text=HelloWorld", nil)
+	rr := httptest.NewRecorder()
+	qrHandler(rr, req)
+	img, err := png.Decode(rr.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The same code as PNG, JPEG and GIF, uploaded as a file or as the body
+	var jpg, gifData bytes.Buffer
+	jpeg.Encode(&jpg, img, &jpeg.Options{Quality: 80})
+	gif.Encode(&gifData, img, nil)
+	pngReq := decodeRequest(t, encodePNG(t, img))
+	jpegReq := httptest.NewRequest("POST", "/decode", bytes.NewReader(jpg.Bytes()))
+	jpegReq.Header.Set("Content-Type", "image/jpeg")
+	gifReq := httptest.NewRequest("POST", "/decode", bytes.NewReader(gifData.Bytes()))
+
+	for _, req := range []*http.Request{pngReq, jpegReq, gifReq} {
+		resetRateLimiter() // Reset rate limiter before each request
+
+		rr := httptest.NewRecorder()
+		decodeHandler(rr, req)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d: %s", rr.Code, rr.Body.String())
+		}
+		if ct := rr.Header().Get("Content-Type"); ct != "application/json" {
+			t.Fatalf("expected Content-Type application/json, got %s", ct)
+		}
+		var body struct {
+			Codes []decodedCode `json:"codes"`
+		}
+		if err := json.Unmarshal(rr.Body.Bytes(), &body); err != nil {
+			t.Fatalf("invalid JSON %s: %v", rr.Body.String(), err)
+		}
+		if len(body.Codes) != 1 || body.Codes[0].Text != "HelloWorld" || body.Codes[0].ECC != "M" || body.Codes[0].Version != 1 {
+			t.Fatalf("expected a version 1-M code reading HelloWorld, got %s", rr.Body.String())
+		}
+	}
+}
+
+func TestDecodeHandler_Invalid(t *testing.T) {
+	isolateRateLimiter(t)
+
+	blank := image.NewGray(image.Rect(0, 0, 100, 100))
+	for i := range blank.Pix {
+		blank.Pix[i] = 0xff
+	}
+	cases := []struct {
+		req    *http.Request
+		status int
+		msg    string
+	}{
+		{httptest.NewRequest("GET", "/decode", nil), http.StatusMethodNotAllowed, "POST the image to decode"},
+		{httptest.NewRequest("POST", "/decode", strings.NewReader("not an image")), http.StatusBadRequest, "The image must be a PNG, JPEG or GIF"},
+		{decodeRequest(t, encodePNG(t, image.NewGray(image.Rect(0, 0, 4097, 1)))), http.StatusBadRequest, "The image must be at most 4096x4096 pixels"},
+		{decodeRequest(t, encodePNG(t, blank)), http.StatusUnprocessableEntity, "No QR code or barcode was found in the image"},
+		{httptest.NewRequest("POST", "/decode", bytes.NewReader(make([]byte, maxDecodeUpload+1))), http.StatusRequestEntityTooLarge, "The image must be at most 10 MB"},
+	}
+	for _, tc := range cases {
+		resetRateLimiter() // Reset rate limiter before each request
+
+		rr := httptest.NewRecorder()
+		decodeHandler(rr, tc.req)
+		if rr.Code != tc.status {
+			t.Fatalf("expected status %d for %q, got %d", tc.status, tc.msg, rr.Code)
+		}
+		if !strings.Contains(rr.Body.String(), tc.msg) {
+			t.Fatalf("expected error %q, got %s", tc.msg, rr.Body.String())
+		}
+	}
+
+	// A multipart upload needs an 'image' file
+	resetRateLimiter()
+	rr := httptest.NewRecorder()
+	decodeHandler(rr, logoRequest(t, "", []byte("x"), nil))
+	if rr.Code != http.StatusBadRequest || !strings.Contains(rr.Body.String(), "multipart 'image' file") {
+		t.Fatalf("expected status 400 for a missing image file, got %d: %s", rr.Code, rr.Body.String())
+	}
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// IBAN lengths of the SEPA countries, which EPC payments are limited to
+var sepaIBANLengths = map[string]int{
+	"AD": 24, "AT": 20, "BE": 16, "BG": 22, "CH": 21, "CY": 28, "CZ": 24, "DE": 22, "DK": 18, "EE": 20,
+	"ES": 24, "FI": 18, "FR": 27, "GB": 22, "GI": 23, "GR": 27, "HR": 21, "HU": 28, "IE": 22, "IS": 26,
+	"IT": 27, "LI": 21, "LT": 20, "LU": 20, "LV": 21, "MC": 27, "MT": 31, "NL": 18, "NO": 15, "PL": 28,
+	"PT": 25, "RO": 24, "SE": 24, "SI": 19, "SK": 24, "SM": 27, "VA": 22,
+}
+
+// SEPA countries outside the EEA, whose banks must be identified by their BIC
+var sepaBICRequired = map[string]bool{
+	"AD": true, "CH": true, "GB": true, "GI": true, "MC": true, "SM": true, "VA": true,
+}
+
+var (
+	ibanPattern        = regexp.MustCompile(`^[A-Z]{2}[0-9]{2}[A-Z0-9]+$`)
+	bicPattern         = regexp.MustCompile(`^[A-Z]{6}[A-Z0-9]{2}([A-Z0-9]{3})?$`)
+	creditorRefPattern = regexp.MustCompile(`^RF[0-9]{2}[A-Z0-9]{1,21}$`)
+	purposePattern     = regexp.MustCompile(`^[A-Z]{4}$`)
+	amountPattern      = regexp.MustCompile(`^([0-9]+)(\.([0-9]{1,2}))?$`)
+)
+
+// mod97 computes the ISO 7064 MOD 97-10 remainder that IBANs and ISO 11649
+// creditor references check: the first four characters are moved to the
+// end, and letters count as 10 to 35.
+func mod97(s string) int {
+	s = s[4:] + s[:4]
+	rem := 0
+	for _, r := range s {
+		if r >= 'A' && r <= 'Z' {
+			rem = (rem*100 + int(r-'A') + 10) % 97
+		} else {
+			rem = (rem*10 + int(r-'0')) % 97
+		}
+	}
+	return rem
+}
+
+// normalizeIBAN removes the spaces IBANs are often written with and upper
+// cases the letters.
+func normalizeIBAN(s string) string {
+	return strings.ToUpper(strings.ReplaceAll(s, " ", ""))
+}
+
+// validateIBAN checks the length and checksum of an IBAN from one of the
+// given countries.
+func validateIBAN(iban string, lengths map[string]int) error {
+	if !ibanPattern.MatchString(iban) {
+		return fmt.Errorf("IBAN must be a country code and two check digits, followed by letters and digits")
+	}
+	length, ok := lengths[iban[:2]]
+	if !ok {
+		return fmt.Errorf("IBANs from %s are not accepted", iban[:2])
+	}
+	if len(iban) != length {
+		return fmt.Errorf("IBANs from %s must have %d characters, got %d", iban[:2], length, len(iban))
+	}
+	if mod97(iban) != 1 {
+		return fmt.Errorf("Invalid IBAN checksum")
+	}
+	return nil
+}
+
+// parseAmount reads an amount of money with at most two decimals and
+// returns it in cents.
+func parseAmount(s string) (int64, error) {
+	m := amountPattern.FindStringSubmatch(s)
+	if m == nil || len(m[1]) > 9 {
+		return 0, fmt.Errorf("Amount must be a number with at most two decimals, such as 12.50")
+	}
+	cents := int64(atoi(m[1])) * 100
+	if m[3] != "" {
+		frac := atoi(m[3])
+		if len(m[3]) == 1 {
+			frac *= 10
+		}
+		cents += int64(frac)
+	}
+	if cents < 1 {
+		return 0, fmt.Errorf("Amount must be between 0.01 and 999999999.99")
+	}
+	return cents, nil
+}
+
+// Most bytes an EPC payload may take
+const maxEPCPayload = 331
+
+// epcPayload builds an EPC069-12 SEPA credit transfer (GiroCode) from the
+// iban, bic, name, amount, currency, purpose, reference, remittance and info
+// parameters. It writes version 002, in which the BIC is optional, in UTF-8.
+func epcPayload(q url.Values) (string, error) {
+	iban := normalizeIBAN(q.Get("iban"))
+	if iban == "" {
+		return "", fmt.Errorf("Please provide an 'iban' parameter")
+	}
+	if err := validateIBAN(iban, sepaIBANLengths); err != nil {
+		return "", err
+	}
+	bic := strings.ToUpper(q.Get("bic"))
+	if bic != "" && !bicPattern.MatchString(bic) {
+		return "", fmt.Errorf("BIC must be 8 or 11 letters and digits, such as COBADEFF or COBADEFFXXX")
+	}
+	if bic == "" && sepaBICRequired[iban[:2]] {
+		return "", fmt.Errorf("Please provide a 'bic' parameter; payments to IBANs from %s, outside the EEA, need the BIC", iban[:2])
+	}
+
+	// Text fields are limited in characters and take a line each. The
+	// creditor reference is counted without the spaces it is often written
+	// with
+	reference := strings.ToUpper(strings.ReplaceAll(q.Get("reference"), " ", ""))
+	name := q.Get("name")
+	if name == "" {
+		return "", fmt.Errorf("Please provide a 'name' parameter")
+	}
+	for _, field := range []struct {
+		param, value string
+		max          int
+	}{{"name", name, 70}, {"reference", reference, 35}, {"remittance", q.Get("remittance"), 140}, {"info", q.Get("info"), 70}} {
+		if n := utf8.RuneCountInString(field.value); n > field.max {
+			return "", fmt.Errorf("The %s can be at most %d characters, got %d", field.param, field.max, n)
+		}
+		if strings.ContainsAny(field.value, "\r\n") {
+			return "", fmt.Errorf("The %s can not contain line breaks", field.param)
+		}
+	}
+
+	amount := ""
+	if s := q.Get("amount"); s != "" {
+		cents, err := parseAmount(s)
+		if err != nil {
+			return "", err
+		}
+		amount = fmt.Sprintf("EUR%d.%02d", cents/100, cents%100)
+	}
+	if c := q.Get("currency"); c != "" && c != "EUR" {
+		return "", fmt.Errorf("Currency must be 'EUR'; EPC QR codes only carry euro amounts")
+	}
+	purpose := q.Get("purpose")
+	if purpose != "" && !purposePattern.MatchString(purpose) {
+		return "", fmt.Errorf("Purpose must be a 4-letter ISO 20022 purpose code, such as GDDS")
+	}
+
+	// The remittance information is either a structured creditor reference
+	// or free text
+	text := q.Get("remittance")
+	if reference != "" && text != "" {
+		return "", fmt.Errorf("Use either 'reference' or 'remittance', not both")
+	}
+	if reference != "" && (!creditorRefPattern.MatchString(reference) || mod97(reference) != 1) {
+		return "", fmt.Errorf("Reference must be an ISO 11649 creditor reference, such as RF18539007547034")
+	}
+
+	lines := []string{"BCD", "002", "1", "SCT", bic, name, iban, amount, purpose, reference, text, q.Get("info")}
+	for lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	payload := strings.Join(lines, "\n")
+	if len(payload) > maxEPCPayload {
+		return "", fmt.Errorf("EPC payloads can be at most %d bytes, got %d", maxEPCPayload, len(payload))
+	}
+	return payload, nil
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bytes"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	zxqrcode "github.com/makiuchi-d/gozxing/qrcode"
+)
+
+func TestMod97(t *testing.T) {
+	for _, s := range []string{"DE89370400440532013000", "GB82WEST12345698765432", "RF18539007547034", "RF712348231"} {
+		if got := mod97(s); got != 1 {
+			t.Fatalf("expected %s to check, got remainder %d", s, got)
+		}
+	}
+	if got := mod97("DE88370400440532013000"); got == 1 {
+		t.Fatal("expected a wrong check digit to fail")
+	}
+}
+
+func TestEPCPayload(t *testing.T) {
+	cases := []struct {
+		query url.Values
+		want  string
+	}{
+		{
+			url.Values{"iban": {"DE89 3704 0044 0532 0130 00"}, "bic": {"cobadeffxxx"}, "name": {"Acme GmbH"},
+				"amount": {"12.5"}, "currency": {"EUR"}, "purpose": {"GDDS"}, "reference": {"RF18 5390 0754 7034"}},
+			"BCD\n002\n1\nSCT\nCOBADEFFXXX\nAcme GmbH\nDE89370400440532013000\nEUR12.50\nGDDS\nRF18539007547034",
+		},
+		{
+			url.Values{"iban": {"DE89370400440532013000"}, "name": {"Acme GmbH"}, "amount": {"1000"},
+				"remittance": {"Invoice 2024-17"}, "info": {"Thanks!"}},
+			"BCD\n002\n1\nSCT\n\nAcme GmbH\nDE89370400440532013000\nEUR1000.00\n\n\nInvoice 2024-17\nThanks!",
+		},
+		{
+			url.Values{"iban": {"DE89370400440532013000"}, "name": {"Acme GmbH"}},
+			"BCD\n002\n1\nSCT\n\nAcme GmbH\nDE89370400440532013000",
+		},
+		{
+			// Banks outside the EEA need the BIC, and the reference is
+			// counted without its spaces
+			url.Values{"iban": {"GB82WEST12345698765432"}, "bic": {"NWBKGB2L"}, "name": {"Acme Ltd"},
+				"reference": {strings.Join(strings.Split("RF18539007547034", ""), "  ")}},
+			"BCD\n002\n1\nSCT\nNWBKGB2L\nAcme Ltd\nGB82WEST12345698765432\n\n\nRF18539007547034",
+		},
+	}
+	for _, tc := range cases {
+		got, err := epcPayload(tc.query)
+		if err != nil {
+			t.Fatalf("unexpected error for %v: %v", tc.query, err)
+		}
+		if got != tc.want {
+			t.Fatalf("expected %q for %v, got %q", tc.want, tc.query, got)
+		}
+	}
+}
+
+func TestEPCHandler_RoundTrip(t *testing.T) {
+	isolateRateLimiter(t)
+
+	q := url.Values{"iban": {"DE89370400440532013000"}, "bic": {"COBADEFFXXX"}, "name": {"Bäckerei Müller"},
+		"amount": {"99.99"}, "remittance": {"Rechnung 17"}}
+	want, err := epcPayload(q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest("GET", "/qr/epc?"+q.Encode(), nil)
+	rr := httptest.NewRecorder()
+	payloadHandler(epcPayload, "M")(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if got := decodeMatrix(t, zxqrcode.NewQRCodeReader(), rr.Body.Bytes()); got != want {
+		t.Fatalf("expected %q, got %q", want, got)
+	}
+	if ecc, _ := qrFormatInfo(t, rr.Body.Bytes()); ecc != "M" {
+		t.Fatalf("expected ECC M, got %s", ecc)
+	}
+
+	// Other formats work as for /qr, and ecc=M may be given
+	for _, format := range []string{"svg", "pdf"} {
+		resetRateLimiter()
+		req := httptest.NewRequest("GET", "/qr/epc?"+q.Encode()+"&ecc=M&format="+format, nil)
+		rr := httptest.NewRecorder()
+		payloadHandler(epcPayload, "M")(rr, req)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected status 200 for %s, got %d: %s", format, rr.Code, rr.Body.String())
+		}
+	}
+}
+
+func TestEPCHandler_Invalid(t *testing.T) {
+	isolateRateLimiter(t)
+
+	const base = "iban=DE89370400440532013000&name=Acme"
+	cases := map[string]string{
+		"name=Acme":                                                   "Please provide an 'iban' parameter",
+		"iban=DE88370400440532013000&name=Acme":                       "Invalid IBAN checksum",
+		"iban=DE8937040044053201300&name=Acme":                        "IBANs from DE must have 22 characters, got 21",
+		"iban=US89370400440532013000&name=Acme":                       "IBANs from US are not accepted",
+		"iban=DE-89&name=Acme":                                        "IBAN must be a country code and two check digits",
+		"iban=DE89370400440532013000":                                 "Please provide a 'name' parameter",
+		base + "&bic=COBA":                                            "BIC must be 8 or 11 letters and digits",
+		"iban=GB82WEST12345698765432&name=Acme":                       "Please provide a 'bic' parameter; payments to IBANs from GB",
+		"iban=DE89370400440532013000&name=" + strings.Repeat("x", 71): "The name can be at most 70 characters, got 71",
+		base + "&remittance=" + strings.Repeat("x", 141):              "The remittance can be at most 140 characters, got 141",
+		base + "&info=a%0Ab":                                          "The info can not contain line breaks",
+		base + "&amount=12.345":                                       "Amount must be a number with at most two decimals",
+		base + "&amount=1000000000":                                   "Amount must be a number with at most two decimals",
+		base + "&amount=0.00":                                         "Amount must be between 0.01 and 999999999.99",
+		base + "&amount=5&currency=USD":                               "Currency must be 'EUR'",
+		base + "&purpose=gd":                                          "Purpose must be a 4-letter ISO 20022 purpose code",
+		base + "&reference=RF19539007547034":                          "Reference must be an ISO 11649 creditor reference",
+		base + "&reference=RF18539007547034&remittance=x":             "Use either 'reference' or 'remittance', not both",
+		base + "&ecc=H":                                               "These QR codes must use error-correction level M",
+		base + "&text=BCD":                                            "remove the 'text' parameter",
+	}
+	for query, msg := range cases {
+		resetRateLimiter() // Reset rate limiter before each request
+
+		req := httptest.NewRequest("GET", "/qr/epc?"+query, nil)
+		rr := httptest.NewRecorder()
+		payloadHandler(epcPayload, "M")(rr, req)
+
+		if rr.Code != http.StatusBadRequest {
+			t.Fatalf("expected status 400 for %s, got %d", query, rr.Code)
+		}
+		if !strings.Contains(rr.Body.String(), msg) {
+			t.Fatalf("expected error %q for %s, got %s", msg, query, rr.Body.String())
+		}
+	}
+
+	// A logo would need level H
+	resetRateLimiter()
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	mw.WriteField("iban", "DE89370400440532013000")
+	mw.WriteField("name", "Acme")
+	mw.Close()
+	req := httptest.NewRequest("POST", "/qr/epc", &body)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	rr := httptest.NewRecorder()
+	payloadHandler(epcPayload, "M")(rr, req)
+	if rr.Code != http.StatusBadRequest || !strings.Contains(rr.Body.String(), "no room for a logo") {
+		t.Fatalf("expected status 400 for a logo, got %d: %s", rr.Code, rr.Body.String())
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+	_ "time/tzdata" // Time zones resolve on hosts without a zoneinfo database
+	"unicode/utf8"
+)
+
+// Layouts accepted for event start and end times: a date for all-day events,
+// a local date-time, or a date-time with a UTC offset
+const (
+	eventDateLayout  = "2006-01-02"
+	eventLocalLayout = "2006-01-02T15:04"
+)
+
+// eventTime is a parsed start or end of an event.
+type eventTime struct {
+	t time.Time
+
+	// Whether the time is a date of an all-day event, or a local date-time
+	// without a UTC offset
+	date, local bool
+}
+
+// parseEventTime reads a date ("2024-06-01"), a local date-time
+// ("2024-06-01T09:00", seconds optional) or an RFC 3339 date-time with a UTC
+// offset ("2024-06-01T09:00:00+02:00"). Local date-times are read in loc.
+func parseEventTime(param, s string, loc *time.Location) (eventTime, error) {
+	if t, err := time.ParseInLocation(eventDateLayout, s, loc); err == nil {
+		return eventTime{t: t, date: true}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return eventTime{t: t}, nil
+	}
+	for _, layout := range []string{eventLocalLayout, eventLocalLayout + ":05"} {
+		if t, err := time.ParseInLocation(layout, s, loc); err == nil {
+			return eventTime{t: t, local: true}, nil
+		}
+	}
+	return eventTime{}, fmt.Errorf("%s must be a date such as 2024-06-01, or a date-time such as 2024-06-01T09:00 or 2024-06-01T09:00:00+02:00", param)
+}
+
+// eventPayload builds an iCalendar (RFC 5545) VEVENT from the summary, start,
+// end, timezone, location and description parameters. Times are written in
+// UTC, or in the local time of timezone when one is given; local times
+// without a timezone float, and dates make an all-day event that ends after
+// the end date.
+func eventPayload(q url.Values) (string, error) {
+	summary := q.Get("summary")
+	if summary == "" {
+		return "", fmt.Errorf("Please provide a 'summary' parameter")
+	}
+	for _, field := range []struct {
+		param string
+		max   int
+	}{{"summary", 255}, {"location", 255}, {"description", 1000}} {
+		if n := utf8.RuneCountInString(q.Get(field.param)); n > field.max {
+			return "", fmt.Errorf("The %s can be at most %d characters, got %d", field.param, field.max, n)
+		}
+	}
+
+	tz := q.Get("timezone")
+	loc := time.UTC
+	if tz != "" {
+		var err error
+		if loc, err = time.LoadLocation(tz); err != nil || tz == "Local" {
+			return "", fmt.Errorf("Timezone must be an IANA time zone such as Europe/Zurich, got %q", tz)
+		}
+	}
+
+	if q.Get("start") == "" {
+		return "", fmt.Errorf("Please provide a 'start' parameter")
+	}
+	start, err := parseEventTime("start", q.Get("start"), loc)
+	if err != nil {
+		return "", err
+	}
+	var end *eventTime
+	if s := q.Get("end"); s != "" {
+		e, err := parseEventTime("end", s, loc)
+		if err != nil {
+			return "", err
+		}
+		if e.date != start.date {
+			return "", fmt.Errorf("Start and end must both be dates or both be date-times")
+		}
+		if e.t.Before(start.t) {
+			return "", fmt.Errorf("The end (%s) must not be before the start (%s)", s, q.Get("start"))
+		}
+		if e.date {
+			// The end date is inclusive, but DTEND is not
+			e.t = e.t.AddDate(0, 0, 1)
+		}
+		end = &e
+	}
+
+	// format writes a DTSTART or DTEND property
+	format := func(name string, et eventTime) string {
+		switch {
+		case et.date:
+			return name + ";VALUE=DATE:" + et.t.Format("20060102")
+		case tz != "":
+			return name + ";TZID=" + tz + ":" + et.t.In(loc).Format("20060102T150405")
+		case et.local:
+			return name + ":" + et.t.Format("20060102T150405")
+		}
+		return name + ":" + et.t.UTC().Format("20060102T150405Z")
+	}
+
+	lines := []string{"BEGIN:VEVENT", "SUMMARY:" + escapeVCard(summary), format("DTSTART", start)}
+	if end != nil {
+		lines = append(lines, format("DTEND", *end))
+	}
+	if s := q.Get("location"); s != "" {
+		lines = append(lines, "LOCATION:"+escapeVCard(s))
+	}
+	if s := q.Get("description"); s != "" {
+		lines = append(lines, "DESCRIPTION:"+escapeVCard(s))
+	}
+	lines = append(lines, "END:VEVENT")
+
+	// iCalendar escapes text and folds lines as vCard does
+	for i, line := range lines {
+		lines[i] = foldVCard(line)
+	}
+	return strings.Join(lines, "\r\n") + "\r\n", nil
+}
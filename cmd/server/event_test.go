@@ -0,0 +1,119 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	zxqrcode "github.com/makiuchi-d/gozxing/qrcode"
+)
+
+func TestEventPayload(t *testing.T) {
+	cases := []struct {
+		query url.Values
+		want  string
+	}{
+		{
+			url.Values{"summary": {"Keynote"}, "start": {"2024-06-01T09:00:00+02:00"}, "end": {"2024-06-01T10:30:00+02:00"}},
+			"BEGIN:VEVENT\r\nSUMMARY:Keynote\r\nDTSTART:20240601T070000Z\r\nDTEND:20240601T083000Z\r\nEND:VEVENT\r\n",
+		},
+		{
+			url.Values{"summary": {"Keynote"}, "start": {"2024-06-01T09:00"}, "end": {"2024-06-01T10:30"}, "timezone": {"Europe/Zurich"}},
+			"BEGIN:VEVENT\r\nSUMMARY:Keynote\r\nDTSTART;TZID=Europe/Zurich:20240601T090000\r\nDTEND;TZID=Europe/Zurich:20240601T103000\r\nEND:VEVENT\r\n",
+		},
+		{
+			// Times with an offset are converted to the time zone
+			url.Values{"summary": {"Call"}, "start": {"2024-01-15T14:00:00Z"}, "timezone": {"America/New_York"}},
+			"BEGIN:VEVENT\r\nSUMMARY:Call\r\nDTSTART;TZID=America/New_York:20240115T090000\r\nEND:VEVENT\r\n",
+		},
+		{
+			url.Values{"summary": {"Lunch"}, "start": {"2024-06-01T12:00"}},
+			"BEGIN:VEVENT\r\nSUMMARY:Lunch\r\nDTSTART:20240601T120000\r\nEND:VEVENT\r\n",
+		},
+		{
+			// The end date is the last day of the event
+			url.Values{"summary": {"GopherCon"}, "start": {"2024-06-01"}, "end": {"2024-06-03"}},
+			"BEGIN:VEVENT\r\nSUMMARY:GopherCon\r\nDTSTART;VALUE=DATE:20240601\r\nDTEND;VALUE=DATE:20240604\r\nEND:VEVENT\r\n",
+		},
+		{
+			url.Values{"summary": {"Q&A; panel, day 2"}, "start": {"2024-06-01T09:00:00Z"}, "location": {"Hall 1, Room 2"},
+				"description": {"Bring questions;\nand answers\\"}},
+			"BEGIN:VEVENT\r\nSUMMARY:Q&A\\; panel\\, day 2\r\nDTSTART:20240601T090000Z\r\nLOCATION:Hall 1\\, Room 2\r\n" +
+				"DESCRIPTION:Bring questions\\;\\nand answers\\\\\r\nEND:VEVENT\r\n",
+		},
+	}
+	for _, tc := range cases {
+		got, err := eventPayload(tc.query)
+		if err != nil {
+			t.Fatalf("unexpected error for %v: %v", tc.query, err)
+		}
+		if got != tc.want {
+			t.Fatalf("expected %q for %v, got %q", tc.want, tc.query, got)
+		}
+	}
+
+	// Long descriptions are folded
+	got, err := eventPayload(url.Values{"summary": {"Talk"}, "start": {"2024-06-01"}, "description": {strings.Repeat("x", 200)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range strings.Split(got, "\r\n") {
+		if len(line) > 75 {
+			t.Fatalf("line %q is longer than 75 octets", line)
+		}
+	}
+}
+
+func TestEventHandler_RoundTrip(t *testing.T) {
+	isolateRateLimiter(t)
+
+	q := url.Values{"summary": {"Badge pickup"}, "start": {"2024-06-01T08:00"}, "end": {"2024-06-01T09:00"},
+		"timezone": {"Europe/Berlin"}, "location": {"Foyer, Level 0"}, "description": {"Bring your ticket"}}
+	want, err := eventPayload(q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest("GET", "/qr/event?"+q.Encode(), nil)
+	rr := httptest.NewRecorder()
+	payloadHandler(eventPayload, "")(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if got := decodeMatrix(t, zxqrcode.NewQRCodeReader(), rr.Body.Bytes()); got != want {
+		t.Fatalf("expected %q, got %q", want, got)
+	}
+}
+
+func TestEventHandler_Invalid(t *testing.T) {
+	isolateRateLimiter(t)
+
+	cases := map[string]string{
+		"start=2024-06-01":                                                        "Please provide a 'summary' parameter",
+		"summary=Talk":                                                            "Please provide a 'start' parameter",
+		"summary=Talk&start=June+1":                                               "start must be a date such as 2024-06-01",
+		"summary=Talk&start=2024-06-01&end=2024-13-01":                            "end must be a date such as 2024-06-01",
+		"summary=Talk&start=2024-06-01T10:00&end=2024-06-01T09:00":                "The end (2024-06-01T09:00) must not be before the start (2024-06-01T10:00)",
+		"summary=Talk&start=2024-06-02&end=2024-06-01":                            "must not be before the start",
+		"summary=Talk&start=2024-06-01T10:00:00Z&end=2024-06-01T11:00:00%2B02:00": "must not be before the start",
+		"summary=Talk&start=2024-06-01&end=2024-06-01T09:00":                      "Start and end must both be dates or both be date-times",
+		"summary=Talk&start=2024-06-01&timezone=Mars/Olympus":                     "Timezone must be an IANA time zone",
+		"summary=" + strings.Repeat("x", 256) + "&start=2024-06-01":               "The summary can be at most 255 characters, got 256",
+		"summary=Talk&start=2024-06-01&text=BEGIN":                                "remove the 'text' parameter",
+	}
+	for query, msg := range cases {
+		resetRateLimiter() // Reset rate limiter before each request
+
+		req := httptest.NewRequest("GET", "/qr/event?"+query, nil)
+		rr := httptest.NewRecorder()
+		payloadHandler(eventPayload, "")(rr, req)
+
+		if rr.Code != http.StatusBadRequest {
+			t.Fatalf("expected status 400 for %s, got %d", query, rr.Code)
+		}
+		if !strings.Contains(rr.Body.String(), msg) {
+			t.Fatalf("expected error %q for %s, got %s", msg, query, rr.Body.String())
+		}
+	}
+}
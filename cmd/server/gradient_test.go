@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bytes"
+	"image/color"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestQRHandler_Gradient_PNG(t *testing.T) {
+	isolateRateLimiter(t)
+
+	// 29 modules at 10 pixels each, so the symbol spans pixels 40 to 249
+	req := httptest.NewRequest("GET", "/qr?text=hello&size=290&gradient=1a237e,004d40&bg=fff8e1", nil)
+	rr := httptest.NewRecorder()
+	qrHandler(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	img, err := png.Decode(bytes.NewReader(rr.Body.Bytes()))
+	if err != nil {
+		t.Fatalf("failed to decode PNG: %v", err)
+	}
+
+	at := func(x, y int) color.RGBA {
+		return color.RGBAModel.Convert(img.At(x, y)).(color.RGBA)
+	}
+	bg := color.RGBA{R: 0xff, G: 0xf8, B: 0xe1, A: 0xff}
+	if got := at(0, 0); got != bg {
+		t.Fatalf("expected quiet zone in bg color, got %v", got)
+	}
+	// The top-left and top-right finder patterns sit at the gradient ends
+	if got, want := at(40, 40), (color.RGBA{R: 0x1a, G: 0x23, B: 0x7e, A: 0xff}); got != want {
+		t.Fatalf("expected left edge %v, got %v", want, got)
+	}
+	if got, want := at(249, 40), (color.RGBA{R: 0x00, G: 0x4d, B: 0x40, A: 0xff}); got != want {
+		t.Fatalf("expected right edge %v, got %v", want, got)
+	}
+	// Light modules inside the finder keep the plain background
+	if got := at(55, 55); got != bg {
+		t.Fatalf("expected light module in bg color, got %v", got)
+	}
+
+	// The gradient must not disturb the encoded symbol
+	if ecc, _ := qrFormatInfo(t, rr.Body.Bytes()); ecc != "M" {
+		t.Fatalf("expected readable format info with ECC M, got %q", ecc)
+	}
+}
+
+func TestQRHandler_Gradient_Vector(t *testing.T) {
+	isolateRateLimiter(t)
+
+	req := httptest.NewRequest("GET", "/qr?text=hello&format=svg&gradient=1a237e,004d40", nil)
+	rr := httptest.NewRecorder()
+	qrHandler(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", rr.Code)
+	}
+	body := rr.Body.String()
+	for _, want := range []string{
+		`<linearGradient id="fg" gradientUnits="userSpaceOnUse" x1="4" y1="0" x2="25" y2="0">`,
+		`<stop offset="0" stop-color="#1a237e"/><stop offset="1" stop-color="#004d40"/>`,
+		`<path fill="url(#fg)"`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Fatalf("expected %s in SVG, got %s", want, body)
+		}
+	}
+
+	req = httptest.NewRequest("GET", "/qr?text=hello&format=pdf&gradient=1a237e,004d40", nil)
+	rr = httptest.NewRecorder()
+	qrHandler(rr, req)
+
+	pdf := parsePDF(t, rr.Body.Bytes())
+	_, content := pdf.page(t)
+	if !strings.HasSuffix(content, "W n\n/Sh0 sh\nQ\n") {
+		t.Fatalf("expected modules to clip a shading, got %q", content)
+	}
+	if got := len(pdfModules(t, content, 29, 29)); got != 29 {
+		t.Fatalf("expected 29 module rows, got %d", got)
+	}
+	shading := pdf.object(t, 5)
+	for _, want := range []string{"/ShadingType 2", "/Coords [4 0 25 0]", "/C0 [0.102 0.137 0.494]", "/C1 [0 0.302 0.251]"} {
+		if !strings.Contains(shading, want) {
+			t.Fatalf("expected %s in shading, got %q", want, shading)
+		}
+	}
+}
+
+func TestQRHandler_Gradient_Invalid(t *testing.T) {
+	isolateRateLimiter(t)
+
+	cases := map[string]string{
+		"gradient=1a237e":                     "Gradient must be two hex colors",
+		"gradient=1a237e,zzzzzz":              "Gradient must be two hex colors",
+		"gradient=1a237e,004d40,000000":       "Gradient must be two hex colors",
+		"gradient=1a237e,bbbbbb":              "Gradient color #bbbbbb does not contrast enough",
+		"gradient=000000,1a237e&bg=303030":    "Gradient color #000000 does not contrast enough",
+		"gradient=1a237e,004d40&fg=000000":    "Use either 'fg' or 'gradient'",
+		"gradient=1a237e,004d40&type=barcode": "Gradient is only supported for QR codes",
+	}
+	for query, msg := range cases {
+		resetRateLimiter() // Reset rate limiter before each request
+
+		req := httptest.NewRequest("GET", "/qr?text=hello&"+query, nil)
+		rr := httptest.NewRecorder()
+		qrHandler(rr, req)
+
+		if rr.Code != http.StatusBadRequest {
+			t.Fatalf("expected status 400 for %s, got %d", query, rr.Code)
+		}
+		if !strings.Contains(rr.Body.String(), msg) {
+			t.Fatalf("expected error %q for %s, got %s", msg, query, rr.Body.String())
+		}
+	}
+}
+
+func TestBarcodeHandler_Gradient(t *testing.T) {
+	isolateRateLimiter(t)
+
+	req := httptest.NewRequest("GET", "/barcode?text=1234567890&gradient=1a237e,004d40", nil)
+	rr := httptest.NewRecorder()
+	barcodeHandler(rr, req)
+
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected status 400, got %d", rr.Code)
+	}
+	if !strings.Contains(rr.Body.String(), "Gradient is only supported for QR codes") {
+		t.Fatalf("expected error about gradient, got %s", rr.Body.String())
+	}
+}
+
+func TestQRHandler_Cache_DifferentGradients(t *testing.T) {
+	isolateRateLimiter(t)
+
+	// Test that different gradients create different cache entries
+	req1 := httptest.NewRequest("GET", "/qr?text=testcache&gradient=1a237e,004d40", nil)
+	rr1 := httptest.NewRecorder()
+	qrHandler(rr1, req1)
+
+	req2 := httptest.NewRequest("GET", "/qr?text=testcache&gradient=004d40,1a237e", nil)
+	rr2 := httptest.NewRecorder()
+	qrHandler(rr2, req2)
+
+	if bytes.Equal(rr1.Body.Bytes(), rr2.Body.Bytes()) {
+		t.Fatal("different gradients should not have same cache entry")
+	}
+}
@@ -0,0 +1,302 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/boombuler/barcode"
+	"github.com/boombuler/barcode/code128"
+	"github.com/makiuchi-d/gozxing/datamatrix/encoder"
+)
+
+// gs1AI describes the data that follows a GS1 Application Identifier.
+type gs1AI struct {
+	numeric bool // Digits only, otherwise the GS1 character set
+	length  int  // Length of the data, or the most it may have
+	fixed   bool // The data always has the full length
+	check   bool // The last digit is a GS1 mod-10 check digit
+	date    bool // A date as YYMMDD
+}
+
+// Application Identifiers that can be encoded, by number
+var gs1AIs = map[string]gs1AI{
+	"00":   {numeric: true, length: 18, fixed: true, check: true}, // SSCC
+	"01":   {numeric: true, length: 14, fixed: true, check: true}, // GTIN
+	"02":   {numeric: true, length: 14, fixed: true, check: true}, // GTIN of contained trade items
+	"10":   {length: 20},                                          // Batch or lot number
+	"11":   {numeric: true, length: 6, fixed: true, date: true},   // Production date
+	"12":   {numeric: true, length: 6, fixed: true, date: true},   // Due date
+	"13":   {numeric: true, length: 6, fixed: true, date: true},   // Packaging date
+	"15":   {numeric: true, length: 6, fixed: true, date: true},   // Best before date
+	"16":   {numeric: true, length: 6, fixed: true, date: true},   // Sell by date
+	"17":   {numeric: true, length: 6, fixed: true, date: true},   // Expiration date
+	"20":   {numeric: true, length: 2, fixed: true},               // Internal product variant
+	"21":   {length: 20},                                          // Serial number
+	"22":   {length: 20},                                          // Consumer product variant
+	"240":  {length: 30},                                          // Additional product identification
+	"241":  {length: 30},                                          // Customer part number
+	"250":  {length: 30},                                          // Secondary serial number
+	"251":  {length: 30},                                          // Reference to source entity
+	"254":  {length: 20},                                          // GLN extension component
+	"30":   {numeric: true, length: 8},                            // Variable count of items
+	"37":   {numeric: true, length: 8},                            // Count of trade items
+	"400":  {length: 30},                                          // Customer's purchase order number
+	"401":  {length: 30},                                          // Global Identification Number for Consignment
+	"402":  {numeric: true, length: 17, fixed: true, check: true}, // Global Shipment Identification Number
+	"403":  {length: 30},                                          // Routing code
+	"420":  {length: 20},                                          // Ship to postal code
+	"422":  {numeric: true, length: 3, fixed: true},               // Country of origin
+	"8004": {length: 30},                                          // Global Individual Asset Identifier
+	"8020": {length: 25},                                          // Payment slip reference number
+	"8200": {length: 70},                                          // Extended packaging URL
+	"90":   {length: 30},                                          // Information agreed between trading partners
+}
+
+func init() {
+	// Trade measures (310n-369n, with n the number of decimals) are six
+	// digits, GLNs (410-417) thirteen with a check digit, and company
+	// internal information (91-99) up to 90 characters
+	for _, family := range []struct{ from, to int }{{310, 316}, {320, 329}, {330, 337}, {340, 349}, {350, 357}, {360, 369}} {
+		for ai := family.from; ai <= family.to; ai++ {
+			for decimals := 0; decimals <= 9; decimals++ {
+				gs1AIs[fmt.Sprintf("%d%d", ai, decimals)] = gs1AI{numeric: true, length: 6, fixed: true}
+			}
+		}
+	}
+	for ai := 410; ai <= 417; ai++ {
+		gs1AIs[fmt.Sprint(ai)] = gs1AI{numeric: true, length: 13, fixed: true, check: true}
+	}
+	for ai := 91; ai <= 99; ai++ {
+		gs1AIs[fmt.Sprint(ai)] = gs1AI{length: 90}
+	}
+}
+
+// Leading digits of the Application Identifiers whose data has a length
+// fixed by the GS1 specification, so that no FNC1 separator has to follow
+var gs1PredefinedLength = []string{"00", "01", "02", "03", "04", "11", "12", "13", "14", "15", "16", "17", "18", "19", "20", "31", "32", "33", "34", "35", "36", "41"}
+
+// GS1 character set 82, which alphanumeric data is restricted to
+const gs1Charset = "!\"%&'()*+,-./0123456789:;<=>?ABCDEFGHIJKLMNOPQRSTUVWXYZ_abcdefghijklmnopqrstuvwxyz"
+
+// Most data characters a GS1-128 barcode may hold, counting Application
+// Identifiers and separators
+const maxGS1128Text = 48
+
+// gs1Element is an Application Identifier with its data.
+type gs1Element struct {
+	ai, data string
+}
+
+// separated reports whether an FNC1 separator must follow the element when
+// more data comes after it.
+func (e gs1Element) separated() bool {
+	for _, prefix := range gs1PredefinedLength {
+		if strings.HasPrefix(e.ai, prefix) {
+			return false
+		}
+	}
+	return true
+}
+
+// parseGS1 reads human-readable GS1 data such as
+// "(01)09501101530003(17)250101" and validates every element. Errors name
+// the offending Application Identifier.
+func parseGS1(text string) ([]gs1Element, error) {
+	if !strings.HasPrefix(text, "(") {
+		return nil, fmt.Errorf("GS1 data must be Application Identifiers in parentheses followed by their data, such as (01)09501101530003(17)250101")
+	}
+	var elements []gs1Element
+	for text != "" {
+		end := strings.IndexByte(text, ')')
+		if end < 0 {
+			return nil, fmt.Errorf("GS1 data has an unclosed parenthesis: %s", text)
+		}
+		ai := text[1:end]
+		text = text[end+1:]
+		data := text
+		if next := strings.IndexByte(text, '('); next >= 0 {
+			data = text[:next]
+		}
+		text = text[len(data):]
+
+		spec, ok := gs1AIs[ai]
+		if !ok {
+			return nil, fmt.Errorf("Unknown GS1 Application Identifier (%s)", ai)
+		}
+		if err := spec.validate(ai, data); err != nil {
+			return nil, err
+		}
+		elements = append(elements, gs1Element{ai, data})
+	}
+	return elements, nil
+}
+
+// validate checks the data of the Application Identifier ai.
+func (spec gs1AI) validate(ai, data string) error {
+	if data == "" {
+		return fmt.Errorf("GS1 AI (%s) has no data", ai)
+	}
+	if spec.numeric {
+		if !onlyDigits(data) {
+			return fmt.Errorf("GS1 AI (%s) may only contain digits", ai)
+		}
+		if spec.fixed && len(data) != spec.length {
+			return fmt.Errorf("GS1 AI (%s) must have %d digits, got %d", ai, spec.length, len(data))
+		}
+		if len(data) > spec.length {
+			return fmt.Errorf("GS1 AI (%s) can hold at most %d digits, got %d", ai, spec.length, len(data))
+		}
+	} else {
+		for _, r := range data {
+			if !strings.ContainsRune(gs1Charset, r) {
+				return fmt.Errorf("GS1 AI (%s) contains %q, which is not in the GS1 character set", ai, r)
+			}
+		}
+		if len(data) > spec.length {
+			return fmt.Errorf("GS1 AI (%s) can hold at most %d characters, got %d", ai, spec.length, len(data))
+		}
+	}
+	if spec.check {
+		if want := gs1CheckDigit(data[:len(data)-1]); int(data[len(data)-1]-'0') != want {
+			return fmt.Errorf("Invalid GS1 AI (%s) check digit %c, expected %d", ai, data[len(data)-1], want)
+		}
+	}
+	if spec.date {
+		// A day of 00 stands for the end of the month
+		year, month, day := 2000+atoi(data[:2]), atoi(data[2:4]), atoi(data[4:])
+		days := time.Date(year, time.Month(month)+1, 0, 0, 0, 0, 0, time.UTC).Day()
+		if month < 1 || month > 12 || day > days {
+			return fmt.Errorf("GS1 AI (%s) must be a date as YYMMDD, got %s", ai, data)
+		}
+	}
+	return nil
+}
+
+// atoi converts a string of digits to a number.
+func atoi(digits string) int {
+	n := 0
+	for _, d := range digits {
+		n = n*10 + int(d-'0')
+	}
+	return n
+}
+
+// gs1Data joins the elements, putting fnc1 after every element whose
+// length is not predefined, except the last.
+func gs1Data(elements []gs1Element, fnc1 string) string {
+	var s strings.Builder
+	for i, e := range elements {
+		s.WriteString(e.ai + e.data)
+		if e.separated() && i < len(elements)-1 {
+			s.WriteString(fnc1)
+		}
+	}
+	return s.String()
+}
+
+// encodeGS1128 draws a GS1-128 barcode: Code 128 starting with FNC1.
+func encodeGS1128(text string) (barcode.Barcode, error) {
+	elements, err := parseGS1(text)
+	if err != nil {
+		return nil, err
+	}
+	data := gs1Data(elements, string(code128.FNC1))
+	if n := len([]rune(data)); n > maxGS1128Text {
+		return nil, fmt.Errorf("GS1-128 barcodes can hold at most %d characters, got %d", maxGS1128Text, n)
+	}
+	return code128.Encode(string(code128.FNC1) + data)
+}
+
+// Data Matrix ASCII encodation codewords
+const (
+	dataMatrixPad  = 129
+	dataMatrixFNC1 = 232
+)
+
+// encodeGS1DataMatrix draws a GS1 Data Matrix symbol, which starts with the
+// FNC1 codeword. The data is all ASCII, so it is encoded in ASCII mode, with
+// pairs of digits in one codeword.
+func encodeGS1DataMatrix(text string, opts symbologyOptions) ([][]bool, error) {
+	elements, err := parseGS1(text)
+	if err != nil {
+		return nil, err
+	}
+	data := gs1Data(elements, "\x1d")
+	codewords := []byte{dataMatrixFNC1}
+	for i := 0; i < len(data); i++ {
+		switch c := data[i]; {
+		case c == '\x1d':
+			codewords = append(codewords, dataMatrixFNC1)
+		case i+1 < len(data) && onlyDigits(data[i:i+2]):
+			codewords = append(codewords, byte(130+atoi(data[i:i+2])))
+			i++
+		default:
+			codewords = append(codewords, c+1)
+		}
+	}
+
+	shape, shapeName := encoder.SymbolShapeHint_FORCE_SQUARE, "square"
+	if opts.rectangular {
+		shape, shapeName = encoder.SymbolShapeHint_FORCE_RECTANGLE, "rectangular"
+	}
+	info, err := encoder.SymbolInfo_Lookup(len(codewords), shape, nil, nil, true)
+	if err != nil {
+		return nil, fmt.Errorf("Text is too long for a %s Data Matrix code", shapeName)
+	}
+
+	// The first pad codeword is 129; the others are scrambled by position
+	if len(codewords) < info.GetDataCapacity() {
+		codewords = append(codewords, dataMatrixPad)
+	}
+	for len(codewords) < info.GetDataCapacity() {
+		pad := dataMatrixPad + (149*(len(codewords)+1))%253 + 1
+		if pad > 254 {
+			pad -= 254
+		}
+		codewords = append(codewords, byte(pad))
+	}
+	codewords, err = encoder.ErrorCorrection_EncodeECC200(codewords, info)
+	if err != nil {
+		return nil, err
+	}
+	placement := encoder.NewDefaultPlacement(codewords, info.GetSymbolDataWidth(), info.GetSymbolDataHeight())
+	placement.Place()
+	return dataMatrixLayout(placement, info), nil
+}
+
+// dataMatrixLayout surrounds each data region of a placed symbol with its
+// finder pattern: solid on the left and bottom, alternating on the top and
+// right.
+func dataMatrixLayout(placement *encoder.DefaultPlacement, info *encoder.SymbolInfo) [][]bool {
+	regionWidth, regionHeight := info.GetMatrixWidth(), info.GetMatrixHeight()
+	modules := make([][]bool, 0, info.GetSymbolHeight())
+	for y := 0; y < info.GetSymbolDataHeight(); y++ {
+		if y%regionHeight == 0 {
+			row := make([]bool, info.GetSymbolWidth())
+			for x := range row {
+				row[x] = x%2 == 0
+			}
+			modules = append(modules, row)
+		}
+		row := make([]bool, 0, info.GetSymbolWidth())
+		for x := 0; x < info.GetSymbolDataWidth(); x++ {
+			if x%regionWidth == 0 {
+				row = append(row, true)
+			}
+			row = append(row, placement.GetBit(x, y))
+			if x%regionWidth == regionWidth-1 {
+				row = append(row, y%2 == 0)
+			}
+		}
+		modules = append(modules, row)
+		if y%regionHeight == regionHeight-1 {
+			row := make([]bool, info.GetSymbolWidth())
+			for x := range row {
+				row[x] = true
+			}
+			modules = append(modules, row)
+		}
+	}
+	return modules
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bytes"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/makiuchi-d/gozxing"
+	"github.com/makiuchi-d/gozxing/datamatrix"
+	"github.com/makiuchi-d/gozxing/oned"
+)
+
+func TestGS1Data(t *testing.T) {
+	elements, err := parseGS1("(01)09501101530003(10)ABC123(17)250100(21)XYZ")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Only the batch number, whose length varies, needs a separator; the
+	// last element never does
+	if got := gs1Data(elements, "|"); got != "0109501101530003"+"10ABC123|"+"17250100"+"21XYZ" {
+		t.Fatalf("unexpected element string %q", got)
+	}
+}
+
+func TestGS1_RoundTrip(t *testing.T) {
+	isolateRateLimiter(t)
+
+	const text = "(01)09501101530003(10)LOT42(17)250101"
+	const data = "0109501101530003" + "10LOT42\x1d" + "17250101"
+
+	// GS1-128 readers report the leading FNC1 as the ]C1 symbology
+	// identifier and the separators as GS
+	req := httptest.NewRequest("GET", "/barcode?gs1=true&text="+url.QueryEscape(text), nil)
+	rr := httptest.NewRecorder()
+	barcodeHandler(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	img, err := png.Decode(bytes.NewReader(rr.Body.Bytes()))
+	if err != nil {
+		t.Fatalf("failed to decode PNG: %v", err)
+	}
+	bmp, err := gozxing.NewBinaryBitmapFromImage(img)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hints := map[gozxing.DecodeHintType]interface{}{gozxing.DecodeHintType_ASSUME_GS1: true}
+	result, err := oned.NewCode128Reader().Decode(bmp, hints)
+	if err != nil {
+		t.Fatalf("failed to read barcode: %v", err)
+	}
+	if got := result.GetText(); got != "]C1"+data {
+		t.Fatalf("expected %q, got %q", "]C1"+data, got)
+	}
+
+	// Data Matrix readers report every FNC1 as GS
+	for _, query := range []string{"", "&rectangular=true"} {
+		resetRateLimiter()
+
+		req := httptest.NewRequest("GET", "/qr?type=datamatrix&gs1=true&text="+url.QueryEscape(text)+query, nil)
+		rr := httptest.NewRecorder()
+		qrHandler(rr, req)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected status 200 for %q, got %d: %s", query, rr.Code, rr.Body.String())
+		}
+		if got := decodeMatrix(t, datamatrix.NewDataMatrixReader(), rr.Body.Bytes()); got != "\x1d"+data {
+			t.Fatalf("expected %q for %q, got %q", "\x1d"+data, query, got)
+		}
+	}
+}
+
+func TestGS1_Invalid(t *testing.T) {
+	isolateRateLimiter(t)
+
+	cases := map[string]string{
+		"01095011015300031725010":        "GS1 data must be Application Identifiers in parentheses",
+		"(01)09501101530003(17":          "GS1 data has an unclosed parenthesis",
+		"(55)1234":                       "Unknown GS1 Application Identifier (55)",
+		"(01)0950110153000":              "GS1 AI (01) must have 14 digits, got 13",
+		"(01)09501101530004":             "Invalid GS1 AI (01) check digit 4, expected 3",
+		"(00)3541234500000000A":          "GS1 AI (00) may only contain digits",
+		"(01)09501101530003(17)251301":   "GS1 AI (17) must be a date as YYMMDD, got 251301",
+		"(17)250230":                     "GS1 AI (17) must be a date as YYMMDD, got 250230",
+		"(10)" + strings.Repeat("A", 21): "GS1 AI (10) can hold at most 20 characters, got 21",
+		"(10)LOT 42":                     "GS1 AI (10) contains ' ', which is not in the GS1 character set",
+		"(30)":                           "GS1 AI (30) has no data",
+		"(400)" + strings.Repeat("1", 30) + "(21)1234567890123": "GS1-128 barcodes can hold at most 48 characters, got 49",
+	}
+	for text, msg := range cases {
+		resetRateLimiter() // Reset rate limiter before each request
+
+		req := httptest.NewRequest("GET", "/barcode?gs1=true&text="+url.QueryEscape(text), nil)
+		rr := httptest.NewRecorder()
+		barcodeHandler(rr, req)
+
+		if rr.Code != http.StatusBadRequest {
+			t.Fatalf("expected status 400 for %s, got %d", text, rr.Code)
+		}
+		if !strings.Contains(rr.Body.String(), msg) {
+			t.Fatalf("expected error %q for %s, got %s", msg, text, rr.Body.String())
+		}
+	}
+
+	// Symbologies other than Code 128 and Data Matrix have no GS1 mode
+	req := httptest.NewRequest("GET", "/qr?type=aztec&gs1=true&text=(01)09501101530003", nil)
+	rr := httptest.NewRecorder()
+	qrHandler(rr, req)
+	if rr.Code != http.StatusBadRequest || !strings.Contains(rr.Body.String(), "does not support the 'gs1' option") {
+		t.Fatalf("expected the gs1 option to be rejected for aztec, got %d: %s", rr.Code, rr.Body.String())
+	}
+}
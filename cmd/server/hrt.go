@@ -0,0 +1,147 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"unicode/utf8"
+
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/math/fixed"
+)
+
+// Bitmap font of the human-readable text under 1D barcodes. Its pixels are
+// drawn at most one module wide, which makes a digit as wide as an EAN digit.
+var hrtFont = basicfont.Face7x13
+
+// hrtSegment is a run of human-readable text centered under the bars at x,
+// in modules from the first bar, in no more than width modules.
+type hrtSegment struct {
+	text     string
+	x, width float64
+}
+
+// hrtRect is a dark rectangle of the human-readable text, in modules from
+// the top left of the band below the bars.
+type hrtRect struct {
+	x, y, w, h float64
+}
+
+// hrtCaption is the human-readable text line drawn below a 1D barcode.
+type hrtCaption struct {
+	rects  []hrtRect
+	height float64 // Height of the band, in modules
+}
+
+// eanSegments groups the digits of an EAN-13 code as printed: the first
+// digit in the quiet zone, then six digits under each half of the bars.
+func eanSegments(code string) []hrtSegment {
+	return []hrtSegment{{code[:1], -4, 7}, {code[1:7], 24, 42}, {code[7:], 71, 42}}
+}
+
+// ean8Segments puts four digits under each half of an EAN-8 code.
+func ean8Segments(code string) []hrtSegment {
+	return []hrtSegment{{code[:4], 17, 28}, {code[4:], 50, 28}}
+}
+
+// upcaSegments prints the number system and check digits of a UPC-A code in
+// the quiet zones and five digits under each half. The code is encoded as an
+// EAN-13 code with a leading zero, which is not printed.
+func upcaSegments(code string) []hrtSegment {
+	code = code[1:]
+	return []hrtSegment{{code[:1], -4, 7}, {code[1:6], 27.5, 35}, {code[6:11], 67.5, 35}, {code[11:], 99, 7}}
+}
+
+// upceSegments prints the number system and check digits of a UPC-E code in
+// the quiet zones and its six digits under the bars.
+func upceSegments(code string) []hrtSegment {
+	return []hrtSegment{{code[:1], -4, 7}, {code[1:7], 24, 42}, {code[7:], 55, 7}}
+}
+
+// parseHRT reads the hrt and hrt_text parameters, which print text below
+// the bars of a 1D barcode. It returns whether text is printed and the text
+// to print instead of the encoded one, if any; hrt_text implies hrt.
+func parseHRT(q url.Values) (bool, string, error) {
+	hrt := q.Get("hrt")
+	if hrt != "" && hrt != "true" && hrt != "false" {
+		return false, "", fmt.Errorf("hrt must be 'true' or 'false'")
+	}
+	hrtText := q.Get("hrt_text")
+	if hrtText != "" && hrt == "false" {
+		return false, "", fmt.Errorf("hrt_text can not be combined with hrt=false")
+	}
+	if n := utf8.RuneCountInString(hrtText); n > maxLinearText {
+		return false, "", fmt.Errorf("hrt_text can hold at most %d characters, got %d", maxLinearText, n)
+	}
+	return hrt == "true" || hrtText != "", hrtText, nil
+}
+
+// linearCaption lays out the text below a barcode of the symbology, drawn
+// as modules with a quiet zone of margin modules: hrtText if it is given,
+// or else the encoded content, grouped the way the symbology prints it.
+func linearCaption(symbology linearSymbology, text, content, hrtText string, modules [][]bool, margin int) *hrtCaption {
+	bars := float64(len(modules[0]) - 2*margin)
+	segments := []hrtSegment{{text, bars / 2, bars}}
+	switch {
+	case hrtText != "":
+		segments[0].text = hrtText
+	case symbology.hrt != nil:
+		segments = symbology.hrt(content)
+	}
+	return newHRTCaption(segments, margin)
+}
+
+// newHRTCaption lays out the segments under bars that start margin modules
+// from the left. The font is scaled down, evenly for all segments, when a
+// segment does not fit its width.
+func newHRTCaption(segments []hrtSegment, margin int) *hrtCaption {
+	scale := 1.0
+	for _, seg := range segments {
+		if w := textWidth(seg.text); w > 0 && seg.width/w < scale {
+			scale = seg.width / w
+		}
+	}
+
+	caption := &hrtCaption{height: float64(hrtFont.Height) * scale}
+	for _, seg := range segments {
+		left := float64(margin) + seg.x - textWidth(seg.text)*scale/2
+		for i, r := range []rune(seg.text) {
+			// Glyphs outside the font come out as its replacement character
+			_, mask, maskp, _, _ := hrtFont.Glyph(fixed.P(0, hrtFont.Ascent), r)
+			if mask == nil {
+				continue
+			}
+			glyphX := left + float64(i*hrtFont.Advance)*scale
+			lit := func(x, y int) bool {
+				_, _, _, a := mask.At(maskp.X+x, maskp.Y+y).RGBA()
+				return a >= 0x8000
+			}
+			for y := 0; y < hrtFont.Height; y++ {
+				// Draw each horizontal run of pixels as one rectangle
+				for x := 0; x < hrtFont.Width; {
+					if !lit(x, y) {
+						x++
+						continue
+					}
+					start := x
+					for x < hrtFont.Width && lit(x, y) {
+						x++
+					}
+					caption.rects = append(caption.rects, hrtRect{
+						glyphX + float64(start)*scale, float64(y) * scale, float64(x-start) * scale, scale,
+					})
+				}
+			}
+		}
+	}
+	return caption
+}
+
+// textWidth returns the width of text in font pixels, without the spacing
+// after the last glyph.
+func textWidth(text string) float64 {
+	n := len([]rune(text))
+	if n == 0 {
+		return 0
+	}
+	return float64(n*hrtFont.Advance - (hrtFont.Advance - hrtFont.Width))
+}
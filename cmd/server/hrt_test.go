@@ -0,0 +1,211 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/makiuchi-d/gozxing"
+	"github.com/makiuchi-d/gozxing/oned"
+)
+
+func TestNewHRTCaption_EANGrouping(t *testing.T) {
+	segments := eanSegments("4006381333931")
+	if segments[0].text != "4" || segments[1].text != "006381" || segments[2].text != "333931" {
+		t.Fatalf("unexpected EAN-13 groups %v", segments)
+	}
+
+	// EAN digits fit at one module per font pixel, and the first digit sits
+	// in the quiet zone left of the bars
+	caption := newHRTCaption(segments[:1], 11)
+	if caption.height != float64(hrtFont.Height) {
+		t.Fatalf("expected a caption %d modules high, got %g", hrtFont.Height, caption.height)
+	}
+	for _, r := range caption.rects {
+		if r.x < 11-7.5 || r.x+r.w > 11 {
+			t.Fatalf("first digit drawn at %g-%g, outside the quiet zone", r.x, r.x+r.w)
+		}
+	}
+}
+
+func TestNewHRTCaption_Scale(t *testing.T) {
+	// Text wider than its segment is scaled down to fit
+	caption := newHRTCaption([]hrtSegment{{"1234567890", 20, 40}}, 0)
+	if caption.height >= float64(hrtFont.Height) {
+		t.Fatalf("expected the caption to shrink, got height %g", caption.height)
+	}
+	for _, r := range caption.rects {
+		if r.x < 0 || r.x+r.w > 40 {
+			t.Fatalf("text drawn at %g-%g, outside its 40 modules", r.x, r.x+r.w)
+		}
+	}
+}
+
+func TestBarcodeHandler_HRT(t *testing.T) {
+	isolateRateLimiter(t)
+
+	get := func(query string) image.Image {
+		t.Helper()
+		resetRateLimiter()
+
+		req := httptest.NewRequest("GET", "/barcode?symbology=ean13&text=400638133393&size=200&"+query, nil)
+		rr := httptest.NewRecorder()
+		barcodeHandler(rr, req)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected status 200 for %s, got %d: %s", query, rr.Code, rr.Body.String())
+		}
+		img, err := png.Decode(bytes.NewReader(rr.Body.Bytes()))
+		if err != nil {
+			t.Fatalf("failed to decode PNG: %v", err)
+		}
+		return img
+	}
+
+	// 117 modules at 6 pixels each; the caption adds 13 font pixels of 6
+	// pixels each to the 200 pixel bars
+	img := get("hrt=true")
+	if b := img.Bounds(); b.Dx() != 800 || b.Dy() != 200+13*6 {
+		t.Fatalf("expected an 800x278 image, got %dx%d", b.Dx(), b.Dy())
+	}
+	bmp, err := gozxing.NewBinaryBitmapFromImage(img)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := oned.NewEAN13Reader().Decode(bmp, nil)
+	if err != nil {
+		t.Fatalf("failed to read barcode: %v", err)
+	}
+	if got := result.GetText(); got != "4006381333931" {
+		t.Fatalf("expected 4006381333931, got %q", got)
+	}
+
+	// The caption band holds text, which hrt_text replaces
+	band := func(img image.Image) string {
+		var s strings.Builder
+		for y := 200; y < img.Bounds().Dy(); y++ {
+			for x := 0; x < img.Bounds().Dx(); x++ {
+				if r, _, _, _ := img.At(x, y).RGBA(); r < 0x8000 {
+					s.WriteString(strconv.Itoa(x) + "," + strconv.Itoa(y) + " ")
+				}
+			}
+		}
+		return s.String()
+	}
+	text := band(img)
+	if text == "" {
+		t.Fatal("expected text below the bars")
+	}
+	if band(get("hrt_text=ABC-123")) == text {
+		t.Fatal("expected hrt_text to change the printed text")
+	}
+	if b := get("").Bounds(); b.Dy() != 200 {
+		t.Fatalf("expected no caption by default, got height %d", b.Dy())
+	}
+}
+
+func TestBarcodeHandler_HRT_Formats(t *testing.T) {
+	isolateRateLimiter(t)
+
+	// The SVG grows by the caption's 13 modules of 800/117 pixels
+	req := httptest.NewRequest("GET", "/barcode?symbology=ean13&text=400638133393&size=200&format=svg&hrt=true", nil)
+	rr := httptest.NewRecorder()
+	barcodeHandler(rr, req)
+	doc := parseSVG(t, rr.Body.Bytes())
+	if doc.Height != "289" || len(doc.Paths) != 2 {
+		t.Fatalf("expected a 289 pixel high SVG with bars and caption, got %s with %d paths", doc.Height, len(doc.Paths))
+	}
+
+	// So does the PDF page
+	resetRateLimiter()
+	req = httptest.NewRequest("GET", "/barcode?symbology=ean13&text=400638133393&format=pdf&width_mm=80&height_mm=20&hrt=true", nil)
+	rr = httptest.NewRecorder()
+	barcodeHandler(rr, req)
+	box, content := parsePDF(t, rr.Body.Bytes()).page(t)
+	if want := (20 + 13*80.0/117) * 72 / 25.4; !approxEqual(box[3], want) {
+		t.Fatalf("expected a page %g points high, got %v", want, box)
+	}
+	if strings.Count(content, " re\n") < 100 {
+		t.Fatal("expected the caption to be drawn")
+	}
+}
+
+func TestBarcodeHandler_HRT_Invalid(t *testing.T) {
+	isolateRateLimiter(t)
+
+	cases := map[string]string{
+		"text=1234&hrt=yes":                             "hrt must be 'true' or 'false'",
+		"text=1234&hrt=false&hrt_text=123":              "hrt_text can not be combined with hrt=false",
+		"text=1234&hrt_text=" + strings.Repeat("x", 81): "hrt_text can hold at most 80 characters, got 81",
+		"text=12345678901&hrt=true&symbology=ean13":     "EAN-13 codes must have 12 digits",
+	}
+	for query, msg := range cases {
+		resetRateLimiter() // Reset rate limiter before each request
+
+		req := httptest.NewRequest("GET", "/barcode?"+query, nil)
+		rr := httptest.NewRecorder()
+		barcodeHandler(rr, req)
+
+		if rr.Code != http.StatusBadRequest {
+			t.Fatalf("expected status 400 for %s, got %d", query, rr.Code)
+		}
+		if !strings.Contains(rr.Body.String(), msg) {
+			t.Fatalf("expected error %q for %s, got %s", msg, query, rr.Body.String())
+		}
+	}
+}
+
+func TestQRHandler_HRT(t *testing.T) {
+	isolateRateLimiter(t)
+
+	get := func(handler http.HandlerFunc, url string) []byte {
+		t.Helper()
+		resetRateLimiter()
+
+		req := httptest.NewRequest("GET", url, nil)
+		rr := httptest.NewRecorder()
+		handler(rr, req)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected status 200 for %s, got %d: %s", url, rr.Code, rr.Body.String())
+		}
+		return rr.Body.Bytes()
+	}
+
+	// A barcode on /qr prints the same caption as on /barcode, and the
+	// caption is part of the cache key
+	for _, query := range []string{"hrt=true", "hrt_text=ABC-123"} {
+		want := get(barcodeHandler, "/barcode?symbology=ean13&text=400638133393&size=200&"+query)
+		got := get(qrHandler, "/qr?type=ean13&text=400638133393&size=200&shape=rectangle&"+query)
+		if !bytes.Equal(got, want) {
+			t.Fatalf("expected /qr to draw the same barcode as /barcode for %s", query)
+		}
+	}
+	plain := get(qrHandler, "/qr?type=ean13&text=400638133393&size=200&shape=rectangle")
+	if img, err := png.Decode(bytes.NewReader(plain)); err != nil || img.Bounds().Dy() != 200 {
+		t.Fatalf("expected no caption by default: %v", err)
+	}
+
+	// Text is only printed below barcodes
+	cases := map[string]string{
+		"text=hello&hrt=true":                       "hrt and hrt_text are only supported for barcodes",
+		"text=hello&type=datamatrix&hrt_text=hello": "hrt and hrt_text are only supported for barcodes",
+		"text=1234&type=code128&hrt=yes":            "hrt must be 'true' or 'false'",
+	}
+	for query, msg := range cases {
+		resetRateLimiter() // Reset rate limiter before each request
+
+		req := httptest.NewRequest("GET", "/qr?"+query, nil)
+		rr := httptest.NewRecorder()
+		qrHandler(rr, req)
+		if rr.Code != http.StatusBadRequest {
+			t.Fatalf("expected status 400 for %s, got %d", query, rr.Code)
+		}
+		if !strings.Contains(rr.Body.String(), msg) {
+			t.Fatalf("expected error %q for %s, got %s", msg, query, rr.Body.String())
+		}
+	}
+}
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/jpeg" // Logos may be uploaded as JPEG
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+// Largest accepted logo upload, including the multipart framing
+const maxLogoUpload = 2 << 20
+
+// Largest accepted logo dimensions, checked before decoding the pixels
+const maxLogoPixels = 4096
+
+// Logos are stored no larger than this on their longer side, which is plenty
+// for a mark covering a fraction of the code and keeps embedded copies in
+// SVG and PDF output small
+const logoStoreSize = 512
+
+// errLogoTooLarge reports an upload over maxLogoUpload bytes.
+var errLogoTooLarge = errors.New("Logo upload must be at most 2 MB")
+
+// errLogoCoversPatterns reports a logo too large for the symbol's version to
+// leave its position, timing and format patterns visible. A larger version
+// of the same code may still fit it.
+var errLogoCoversPatterns = errors.New("Logo would cover the position, timing, alignment or format patterns of the QR code; reduce logo_size or logo_padding")
+
+// logoOverlay is an image composited over the middle of a QR code, on a
+// plate that clears the modules behind it.
+type logoOverlay struct {
+	img *image.NRGBA
+
+	// Length of the image's longer side, in percent of the symbol width
+	sizePercent int
+
+	// Modules of plate around the image
+	padding int
+
+	// Plate color; zero alpha leaves the cleared modules showing the
+	// background
+	bg color.RGBA
+
+	// Set by place: the modules covered by the plate, and the image's
+	// bounds, both in units of the module grid
+	plate      image.Rectangle
+	x, y, w, h float64
+}
+
+// parseLogoForm reads the multipart body of a POST request and returns the
+// query string parameters merged with the form fields, so that options can
+// be sent either way.
+func parseLogoForm(w http.ResponseWriter, r *http.Request) (url.Values, error) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxLogoUpload)
+	if err := r.ParseMultipartForm(maxLogoUpload); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			return nil, errLogoTooLarge
+		}
+		return nil, fmt.Errorf("POST requests must be multipart/form-data with a 'logo' file")
+	}
+	q := r.URL.Query()
+	for key, values := range r.MultipartForm.Value {
+		q[key] = append(q[key], values...)
+	}
+	return q, nil
+}
+
+// parseLogoRequest returns the parameters of a request to one of the QR code
+// endpoints: the query string, merged with the multipart form that carries
+// the logo for POST requests. If the form can not be read, it responds with
+// the error and returns false.
+func parseLogoRequest(w http.ResponseWriter, r *http.Request) (url.Values, bool) {
+	if r.Method != http.MethodPost {
+		return r.URL.Query(), true
+	}
+	q, err := parseLogoForm(w, r)
+	if err != nil {
+		status := http.StatusBadRequest
+		if errors.Is(err, errLogoTooLarge) {
+			status = http.StatusRequestEntityTooLarge
+		}
+		http.Error(w, err.Error(), status)
+		return nil, false
+	}
+	return q, true
+}
+
+// readLogo decodes the uploaded logo and its placement options. bg is the
+// code's background color, which the plate defaults to.
+func readLogo(r *http.Request, q url.Values, bg color.RGBA) (*logoOverlay, error) {
+	file, _, err := r.FormFile("logo")
+	if err != nil {
+		return nil, fmt.Errorf("Please upload the logo as a multipart 'logo' file")
+	}
+	defer file.Close()
+	data, err := io.ReadAll(file)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read the logo")
+	}
+
+	// Check the dimensions before decoding, so that a small file can not
+	// expand into a huge image
+	config, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil || (format != "png" && format != "jpeg") {
+		return nil, fmt.Errorf("Logo must be a PNG or JPEG image")
+	}
+	if config.Width > maxLogoPixels || config.Height > maxLogoPixels {
+		return nil, fmt.Errorf("Logo must be at most %dx%d pixels", maxLogoPixels, maxLogoPixels)
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("Logo must be a PNG or JPEG image")
+	}
+
+	logo := &logoOverlay{sizePercent: 20, padding: 1, bg: bg}
+	if s := q.Get("logo_size"); s != "" {
+		logo.sizePercent, err = strconv.Atoi(s)
+		if err != nil || logo.sizePercent < 5 || logo.sizePercent > 50 {
+			return nil, fmt.Errorf("logo_size must be a whole percentage between 5 and 50")
+		}
+	}
+	if s := q.Get("logo_padding"); s != "" {
+		logo.padding, err = strconv.Atoi(s)
+		if err != nil || logo.padding < 0 || logo.padding > 4 {
+			return nil, fmt.Errorf("logo_padding must be between 0 and 4 modules")
+		}
+	}
+	if s := q.Get("logo_bg"); s == "transparent" {
+		logo.bg = color.RGBA{}
+	} else if s != "" {
+		logo.bg, err = parseHexColor(s)
+		if err != nil {
+			return nil, fmt.Errorf("logo_bg must be a hex color such as 1a2b3c or #1a2b3c")
+		}
+	}
+
+	// Keep a bounded copy of the image
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if long := max(w, h); long > logoStoreSize {
+		w = max(1, w*logoStoreSize/long)
+		h = max(1, h*logoStoreSize/long)
+	}
+	logo.img = resizeImage(img, w, h)
+	return logo, nil
+}
+
+// place centers the logo on a QR code symbol of the given version, whose
+// module grid including the quiet zone is modules, and clears the modules
+// under its plate. It fails with errLogoCoversPatterns if the plate would
+// hide the patterns scanners use to locate the symbol and correct for its
+// distortion, and with another error
+// if it would damage more codewords than error correction at level H can
+// recover.
+func (l *logoOverlay) place(modules [][]bool, version int) error {
+	n := qrSymbolSize(version)
+	offset := (len(modules) - n) / 2
+
+	// Size the image in modules, keeping its aspect ratio
+	b := l.img.Bounds()
+	long := float64(n*l.sizePercent) / 100
+	l.w, l.h = long, long*float64(b.Dy())/float64(b.Dx())
+	if b.Dy() > b.Dx() {
+		l.w, l.h = long*float64(b.Dx())/float64(b.Dy()), long
+	}
+
+	// The plate covers whole modules around the image and shares the
+	// symbol's center, so each side has the same parity as the symbol
+	plateSide := func(length float64) int {
+		side := max(1, int(math.Ceil(length))) + 2*l.padding
+		if (n-side)%2 != 0 {
+			side++
+		}
+		return side
+	}
+	pw, ph := plateSide(l.w), plateSide(l.h)
+	plate := image.Rect((n-pw)/2, (n-ph)/2, (n+pw)/2, (n+ph)/2)
+
+	// Finder patterns, their separators, the format information and the
+	// timing patterns all lie outside this area. Alignment patterns, which
+	// scanners rely on for curved or skewed prints, lie within it from
+	// version 2 on, and from version 7 on one may sit under the plate.
+	if !plate.In(image.Rect(9, 9, n-8, n-8)) {
+		return errLogoCoversPatterns
+	}
+	function := qrFunctionModules(version)
+	for y := plate.Min.Y; y < plate.Max.Y; y++ {
+		for x := plate.Min.X; x < plate.Max.X; x++ {
+			if function[y][x] {
+				return errLogoCoversPatterns
+			}
+		}
+	}
+
+	// Count the damaged codewords of each Reed-Solomon block; each block can
+	// recover half as many codewords as it has error-correction codewords
+	blocks := qrBlockTable[version][qrLevelIndex["H"]]
+	bits := qrModuleBits(version)
+	damaged := make(map[int]bool)
+	for y := plate.Min.Y; y < plate.Max.Y; y++ {
+		for x := plate.Min.X; x < plate.Max.X; x++ {
+			if bit := bits[y][x]; bit >= 0 {
+				damaged[bit/8] = true
+			}
+		}
+	}
+	perBlock := make([]int, blocks.numBlocks())
+	order := blocks.codewordBlocks()
+	worst := 0
+	for codeword := range damaged {
+		perBlock[order[codeword]]++
+		worst = max(worst, perBlock[order[codeword]])
+	}
+	if recoverable := blocks.ecPerBlock / 2; worst > recoverable {
+		return fmt.Errorf("Logo covers too much of the QR code: it damages %d codewords in one block, but error correction can only recover %d; reduce logo_size or logo_padding",
+			worst, recoverable)
+	}
+
+	l.plate = plate.Add(image.Pt(offset, offset))
+	for y := l.plate.Min.Y; y < l.plate.Max.Y; y++ {
+		for x := l.plate.Min.X; x < l.plate.Max.X; x++ {
+			modules[y][x] = false
+		}
+	}
+	l.x = float64(offset) + (float64(n)-l.w)/2
+	l.y = float64(offset) + (float64(n)-l.h)/2
+	return nil
+}
+
+// resizeImage scales src to w x h pixels. Each destination pixel averages
+// the source pixels it covers, so downscaled logos stay smooth.
+func resizeImage(src image.Image, w, h int) *image.NRGBA {
+	b := src.Bounds()
+	dst := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		y0 := b.Min.Y + y*b.Dy()/h
+		y1 := max(b.Min.Y+(y+1)*b.Dy()/h, y0+1)
+		for x := 0; x < w; x++ {
+			x0 := b.Min.X + x*b.Dx()/w
+			x1 := max(b.Min.X+(x+1)*b.Dx()/w, x0+1)
+
+			// Sum premultiplied channels, then divide the color by alpha
+			var sr, sg, sb, sa, count uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					r, g, b, a := src.At(sx, sy).RGBA()
+					sr, sg, sb, sa = sr+uint64(r), sg+uint64(g), sb+uint64(b), sa+uint64(a)
+					count++
+				}
+			}
+			if sa == 0 {
+				continue
+			}
+			dst.SetNRGBA(x, y, color.NRGBA{
+				R: uint8(sr * 0xff / sa),
+				G: uint8(sg * 0xff / sa),
+				B: uint8(sb * 0xff / sa),
+				A: uint8(sa / count >> 8),
+			})
+		}
+	}
+	return dst
+}
@@ -0,0 +1,293 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// Text used by the logo tests; long enough for a version 4 symbol at ECC H
+const logoText = "https://example.com/products/12345"
+
+// testLogo returns a solid red square logo, with a transparent border if
+// transparent is set.
+func testLogo(transparent bool) image.Image {
+	img := image.NewNRGBA(image.Rect(0, 0, 40, 40))
+	inner := img.Bounds()
+	if transparent {
+		inner = inner.Inset(10)
+	}
+	draw.Draw(img, inner, image.NewUniform(color.NRGBA{R: 0xff, A: 0xff}), image.Point{}, draw.Src)
+	return img
+}
+
+// logoRequest builds a multipart POST to /qr uploading data as the logo,
+// with the given extra form fields.
+func logoRequest(t *testing.T, query string, data []byte, fields map[string]string) *http.Request {
+	t.Helper()
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	for name, value := range fields {
+		if err := mw.WriteField(name, value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if data != nil {
+		part, err := mw.CreateFormFile("logo", "logo")
+		if err != nil {
+			t.Fatal(err)
+		}
+		part.Write(data)
+	}
+	if err := mw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest("POST", "/qr?"+query, &body)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	return req
+}
+
+func encodePNG(t *testing.T, img image.Image) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestQRHandler_Logo_PNG(t *testing.T) {
+	isolateRateLimiter(t)
+
+	// Options may be sent in the query string or as form fields
+	req := logoRequest(t, "size=330&ecc=L", encodePNG(t, testLogo(false)), map[string]string{"text": logoText})
+	rr := httptest.NewRecorder()
+	qrHandler(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if ct := rr.Header().Get("Content-Type"); ct != "image/png" {
+		t.Fatalf("expected Content-Type image/png, got %s", ct)
+	}
+
+	// The requested ECC level is overridden with H
+	if ecc, _ := qrFormatInfo(t, rr.Body.Bytes()); ecc != "H" {
+		t.Fatalf("expected ECC H with a logo, got %s", ecc)
+	}
+
+	// 41 modules including the quiet zone at 8 pixels each: the logo sits in
+	// the middle, surrounded by the white plate
+	img, err := png.Decode(bytes.NewReader(rr.Body.Bytes()))
+	if err != nil {
+		t.Fatalf("failed to decode PNG: %v", err)
+	}
+	at := func(x, y int) color.RGBA {
+		return color.RGBAModel.Convert(img.At(x, y)).(color.RGBA)
+	}
+	if got := at(165, 165); got != (color.RGBA{R: 0xff, A: 0xff}) {
+		t.Fatalf("expected logo at the center, got %v", got)
+	}
+	white := color.RGBA{R: 0xff, G: 0xff, B: 0xff, A: 0xff}
+	for _, p := range []image.Point{{165, 165 - 4*8}, {165 + 4*8, 165}} {
+		if got := at(p.X, p.Y); got != white {
+			t.Fatalf("expected plate at %v, got %v", p, got)
+		}
+	}
+}
+
+func TestQRHandler_Logo_JPEG(t *testing.T) {
+	isolateRateLimiter(t)
+
+	var logo bytes.Buffer
+	if err := jpeg.Encode(&logo, testLogo(false), nil); err != nil {
+		t.Fatal(err)
+	}
+	req := logoRequest(t, "text="+logoText, logo.Bytes(), nil)
+	rr := httptest.NewRecorder()
+	qrHandler(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+}
+
+func TestQRHandler_Logo_ShortText(t *testing.T) {
+	isolateRateLimiter(t)
+
+	// A version 1 symbol has no room between its finder patterns, so the
+	// code grows instead
+	req := logoRequest(t, "text=hi&size=250", encodePNG(t, testLogo(false)), nil)
+	rr := httptest.NewRecorder()
+	qrHandler(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+}
+
+func TestQRHandler_Logo_Vector(t *testing.T) {
+	isolateRateLimiter(t)
+
+	logo := encodePNG(t, testLogo(true))
+	req := logoRequest(t, "format=svg&logo_bg=fff8e1&text="+logoText, logo, nil)
+	rr := httptest.NewRecorder()
+	qrHandler(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	body := rr.Body.String()
+	for _, want := range []string{
+		// A 33 module symbol in a 41 module grid; the 6.6 module logo sits
+		// on a 9 module plate
+		`<rect x="16" y="16" width="9" height="9" fill="#fff8e1"/>`,
+		`<image x="17.2" y="17.2" width="6.6" height="6.6" preserveAspectRatio="none" href="data:image/png;base64,`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Fatalf("expected %s in SVG, got %s", want, body)
+		}
+	}
+
+	req = logoRequest(t, "format=pdf&text="+logoText, logo, nil)
+	rr = httptest.NewRecorder()
+	qrHandler(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	pdf := parsePDF(t, rr.Body.Bytes())
+	_, content := pdf.page(t)
+	if !strings.HasSuffix(content, "1 1 1 rg\n16 16 9 9 re\nf\nq\n6.6 0 0 -6.6 17.2 23.8 cm\n/Im0 Do\nQ\n") {
+		t.Fatalf("expected the logo to be drawn on its plate, got %q", content)
+	}
+	page := pdf.object(t, 3)
+	xobject := pdf.object(t, pdf.ref(t, page, "Im0"))
+	for _, want := range []string{"/Subtype /Image", "/Width 40 /Height 40", "/ColorSpace /DeviceRGB", "/SMask"} {
+		if !strings.Contains(xobject, want) {
+			t.Fatalf("expected %s in image object, got %q", want, xobject)
+		}
+	}
+}
+
+func TestQRHandler_Logo_AlignmentPattern(t *testing.T) {
+	isolateRateLimiter(t)
+
+	// At level H the text takes version 9, whose middle alignment pattern
+	// the logo would hide. Versions 14 to 16 have none in the middle, but
+	// the gap between theirs is too narrow for the plate, so the code grows
+	// to version 17
+	text := strings.Repeat("abcdefghij", 9)
+	req := logoRequest(t, "text="+text, encodePNG(t, testLogo(false)), nil)
+	rr := httptest.NewRecorder()
+	qrHandler(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if code := decodeOutput(t, rr.Body.Bytes()); code.Text != text || code.Version != 17 {
+		t.Fatalf("expected the text in a version 17 code, got version %d: %q", code.Version, code.Text)
+	}
+
+	// A fixed version is not changed
+	resetRateLimiter()
+	req = logoRequest(t, "version=9&text="+text, encodePNG(t, testLogo(false)), nil)
+	rr = httptest.NewRecorder()
+	qrHandler(rr, req)
+	if rr.Code != http.StatusBadRequest || !strings.Contains(rr.Body.String(), "alignment") {
+		t.Fatalf("expected status 400 about the alignment pattern, got %d: %s", rr.Code, rr.Body.String())
+	}
+}
+
+func TestQRHandler_Logo_TooLarge(t *testing.T) {
+	isolateRateLimiter(t)
+
+	req := logoRequest(t, "logo_size=40&text="+logoText, encodePNG(t, testLogo(false)), nil)
+	rr := httptest.NewRecorder()
+	qrHandler(rr, req)
+
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected status 400, got %d", rr.Code)
+	}
+	if !strings.Contains(rr.Body.String(), "Logo covers too much of the QR code") {
+		t.Fatalf("expected error about the error-correction budget, got %s", rr.Body.String())
+	}
+}
+
+func TestQRHandler_Logo_Invalid(t *testing.T) {
+	isolateRateLimiter(t)
+
+	logo := encodePNG(t, testLogo(false))
+	cases := []struct {
+		query string
+		data  []byte
+		msg   string
+	}{
+		{"text=hello", nil, "Please upload the logo"},
+		{"text=hello", []byte("GIF89a not really"), "Logo must be a PNG or JPEG image"},
+		{"text=hello&logo_size=99", logo, "logo_size must be"},
+		{"text=hello&logo_padding=-1", logo, "logo_padding must be"},
+		{"text=hello&logo_bg=nope", logo, "logo_bg must be a hex color"},
+		{"text=1234567890&type=barcode", logo, "Logos are only supported for QR codes"},
+	}
+	for _, tc := range cases {
+		resetRateLimiter() // Reset rate limiter before each request
+
+		rr := httptest.NewRecorder()
+		qrHandler(rr, logoRequest(t, tc.query, tc.data, nil))
+
+		if rr.Code != http.StatusBadRequest {
+			t.Fatalf("expected status 400 for %s, got %d", tc.query, rr.Code)
+		}
+		if !strings.Contains(rr.Body.String(), tc.msg) {
+			t.Fatalf("expected error %q for %s, got %s", tc.msg, tc.query, rr.Body.String())
+		}
+	}
+
+	// A POST must be a multipart upload
+	resetRateLimiter()
+	req := httptest.NewRequest("POST", "/qr?text=hello", strings.NewReader("text=hello"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr := httptest.NewRecorder()
+	qrHandler(rr, req)
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected status 400 for a non-multipart POST, got %d", rr.Code)
+	}
+
+	// Oversized uploads are refused
+	resetRateLimiter()
+	rr = httptest.NewRecorder()
+	qrHandler(rr, logoRequest(t, "text=hello", make([]byte, maxLogoUpload+1), nil))
+	if rr.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("expected status 413 for an oversized logo, got %d", rr.Code)
+	}
+}
+
+func TestQRHandler_Logo_NotCached(t *testing.T) {
+	isolateRateLimiter(t)
+
+	qrCacheMutex.RLock()
+	before := len(qrCache)
+	qrCacheMutex.RUnlock()
+
+	rr := httptest.NewRecorder()
+	qrHandler(rr, logoRequest(t, "text=logocache", encodePNG(t, testLogo(false)), nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	qrCacheMutex.RLock()
+	after := len(qrCache)
+	qrCacheMutex.RUnlock()
+	if after != before {
+		t.Fatal("codes with a logo must not be cached")
+	}
+}
@@ -3,19 +3,19 @@ package main
 import (
 	"bytes"
 	"encoding/base64"
+	"errors"
 	"fmt"
 	"image"
 	"image/color"
 	"image/png"
 	"log"
 	"net/http"
+	"net/url"
 	"strconv"
 	"strings"
 	"sync"
 	"time"
 
-	"github.com/boombuler/barcode"
-	"github.com/boombuler/barcode/code128"
 	qrcode "github.com/skip2/go-qrcode"
 )
 
@@ -98,6 +98,21 @@ var (
 	qrCacheMutex sync.RWMutex
 )
 
+// Supported QR error-correction levels, keyed by their single-letter name
+var eccLevels = map[string]qrcode.RecoveryLevel{
+	"L": qrcode.Low,
+	"M": qrcode.Medium,
+	"Q": qrcode.High,
+	"H": qrcode.Highest,
+}
+
+// Supported output formats and the content type each is served with
+var formatContentTypes = map[string]string{
+	"png": "image/png",
+	"svg": "image/svg+xml",
+	"pdf": "application/pdf",
+}
+
 // Global IP-based rate limiter: 10 requests per second with a bucket size of 20
 var ipRateLimiter = NewIPRateLimiter(10, 20)
 
@@ -113,6 +128,201 @@ func parseHexColor(s string) (color.RGBA, error) {
 	return c, err
 }
 
+// parsePhysicalSize reads the width_mm and height_mm parameters that set the
+// page size of PDF output. A missing dimension follows the other one at the
+// aspect ratio of the width x height pixel output; with neither given, the
+// pixel size is converted at 96 DPI.
+func parsePhysicalSize(q url.Values, width, height int) (float64, float64, error) {
+	parse := func(name string) (float64, error) {
+		str := q.Get(name)
+		if str == "" {
+			return 0, nil
+		}
+		v, err := strconv.ParseFloat(str, 64)
+		if err != nil {
+			return 0, fmt.Errorf("%s must be a valid number", name)
+		}
+		if v < 5 || v > 1000 {
+			return 0, fmt.Errorf("%s must be between 5 and 1000 millimetres", name)
+		}
+		return v, nil
+	}
+
+	widthMM, err := parse("width_mm")
+	if err != nil {
+		return 0, 0, err
+	}
+	heightMM, err := parse("height_mm")
+	if err != nil {
+		return 0, 0, err
+	}
+
+	aspect := float64(width) / float64(height)
+	switch {
+	case widthMM == 0 && heightMM == 0:
+		widthMM = float64(width) * 25.4 / 96
+		heightMM = float64(height) * 25.4 / 96
+	case widthMM == 0:
+		widthMM = heightMM * aspect
+	case heightMM == 0:
+		heightMM = widthMM / aspect
+	}
+	return widthMM, heightMM, nil
+}
+
+// parseRenderOptions reads the parameters shared by /qr and /barcode that
+// control how a code is drawn: the output format, its pixel and physical
+// dimensions, and the fg/bg colors. bg may also be "transparent".
+func parseRenderOptions(q url.Values, size int, shape string) (renderOptions, error) {
+	opts := renderOptions{
+		format: q.Get("format"),
+		width:  size,
+		height: size,
+		fg:     color.RGBA{A: 255},                         // default black
+		bg:     color.RGBA{R: 255, G: 255, B: 255, A: 255}, // default white
+	}
+
+	if opts.format == "" {
+		opts.format = "png" // default format
+	}
+	if _, ok := formatContentTypes[opts.format]; !ok {
+		return opts, fmt.Errorf("Format must be 'png', 'svg' or 'pdf'")
+	}
+
+	if shape == "rectangle" {
+		// Rectangles use barcode proportions (4:1 ratio)
+		opts.width = size * 4
+	}
+	// The physical size only applies to PDF pages
+	var err error
+	if opts.format == "pdf" {
+		opts.widthMM, opts.heightMM, err = parsePhysicalSize(q, opts.width, opts.height)
+		if err != nil {
+			return opts, err
+		}
+	} else if q.Get("width_mm") != "" || q.Get("height_mm") != "" {
+		return opts, fmt.Errorf("width_mm and height_mm only apply to PDF output; add format=pdf or remove them")
+	}
+
+	for _, param := range []struct {
+		name string
+		c    *color.RGBA
+	}{{"fg", &opts.fg}, {"bg", &opts.bg}} {
+		if str := q.Get(param.name); str == "transparent" && param.name == "bg" {
+			*param.c = color.RGBA{}
+		} else if str != "" {
+			c, err := parseHexColor(str)
+			if err != nil {
+				return opts, fmt.Errorf("%s must be a hex color such as 1a2b3c or #1a2b3c", param.name)
+			}
+			*param.c = c
+		}
+	}
+
+	// A gradient replaces fg, so its ends are checked instead
+	if gradient := q.Get("gradient"); gradient != "" {
+		if q.Get("fg") != "" {
+			return opts, fmt.Errorf("Use either 'fg' or 'gradient', not both")
+		}
+		opts.gradient, err = parseGradient(gradient, opts.bg)
+		if err != nil {
+			return opts, err
+		}
+	} else if err := checkContrast(opts.fg, opts.bg); err != nil {
+		return opts, err
+	}
+
+	return opts, nil
+}
+
+// Default quiet zone around QR codes in modules, as ISO/IEC 18004 asks for;
+// barcodes take theirs from their symbology
+const defaultQRMargin = 4
+
+// Widest accepted quiet zone, in modules
+const maxMargin = 40
+
+// parseMargin reads the quiet zone width in modules, falling back to def.
+func parseMargin(q url.Values, def int) (int, error) {
+	s := q.Get("margin")
+	if s == "" {
+		return def, nil
+	}
+	margin, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("Margin must be a valid number")
+	}
+	if margin < 0 || margin > maxMargin {
+		return 0, fmt.Errorf("Margin must be between 0 and %d modules", maxMargin)
+	}
+	return margin, nil
+}
+
+// fitQuietZone returns the quiet zone to draw on each side of a barcode bars
+// modules wide, requested at size pixels. PNG output takes whole pixels per
+// module, so a default margin that does not fit is narrowed to what does.
+// If the bars, or the bars and a requested margin, do not fit, the error
+// names the smallest size that does.
+func fitQuietZone(q url.Values, bars, margin, size int, opts renderOptions) (int, error) {
+	if opts.format != "png" || bars+2*margin <= opts.width {
+		return margin, nil
+	}
+	if q.Get("margin") == "" {
+		if bars <= opts.width {
+			return (opts.width - bars) / 2, nil
+		}
+		margin = 0
+	}
+	modules := bars + 2*margin
+	minSize := (modules*size + opts.width - 1) / opts.width
+	if minSize > 1000 {
+		return 0, fmt.Errorf("The barcode is %d modules wide, too wide for a PNG image of at most 1000 pixels; use format=svg or format=pdf", modules)
+	}
+	return 0, fmt.Errorf("The barcode is %d modules wide, which needs a size of at least %d pixels", modules, minSize)
+}
+
+// parseQRLayout reads the version and mask pattern to draw a QR code with,
+// returning 0 and -1 for the ones left to the encoder.
+func parseQRLayout(q url.Values) (version, mask int, err error) {
+	version, mask = 0, -1
+	if s := q.Get("version"); s != "" {
+		version, err = strconv.Atoi(s)
+		if err != nil || version < 1 || version > 40 {
+			return 0, -1, fmt.Errorf("Version must be a whole number between 1 and 40")
+		}
+	}
+	if s := q.Get("mask"); s != "" {
+		mask, err = strconv.Atoi(s)
+		if err != nil || mask < 0 || mask > 7 {
+			return 0, -1, fmt.Errorf("Mask must be a whole number between 0 and 7")
+		}
+	}
+	return version, mask, nil
+}
+
+// parseGradient reads a "color1,color2" gradient for the dark modules. Both
+// ends must pass the contrast check against bg. That covers the whole
+// gradient: every color in between mixes the two ends, and its luminance
+// never exceeds that of the lighter end.
+func parseGradient(s string, bg color.RGBA) ([]color.RGBA, error) {
+	parts := strings.Split(s, ",")
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("Gradient must be two hex colors separated by a comma, such as 1a237e,00838f")
+	}
+	gradient := make([]color.RGBA, len(parts))
+	for i, part := range parts {
+		c, err := parseHexColor(strings.TrimSpace(part))
+		if err != nil {
+			return nil, fmt.Errorf("Gradient must be two hex colors separated by a comma, such as 1a237e,00838f")
+		}
+		if err := checkContrast(c, bg); err != nil {
+			return nil, fmt.Errorf("Gradient color %s does not contrast enough with the background: %v", hexColor(c), err)
+		}
+		gradient[i] = c
+	}
+	return gradient, nil
+}
+
 func generateImage(size int, c1, c2 color.RGBA) image.Image {
 	img := image.NewRGBA(image.Rect(0, 0, size, size))
 	for y := 0; y < size; y++ {
@@ -177,8 +387,21 @@ func qrHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Get the text parameter from the query string
-	text := r.URL.Query().Get("text")
+	// Parameters come from the query string, and for POST requests also from
+	// the multipart form that carries the logo
+	q, ok := parseLogoRequest(w, r)
+	if !ok {
+		return
+	}
+	serveQR(w, r, q, true)
+}
+
+// serveQR draws the code that the /qr parameters in q describe, with a logo
+// when r carries a multipart form. Unless cache is false, the code is looked
+// up in and stored to qrCache.
+func serveQR(w http.ResponseWriter, r *http.Request, q url.Values, cache bool) {
+	// Get the text parameter
+	text := q.Get("text")
 	if text == "" {
 		http.Error(w, "Please provide a 'text' parameter", http.StatusBadRequest)
 		return
@@ -186,7 +409,7 @@ func qrHandler(w http.ResponseWriter, r *http.Request) {
 
 	// Get and validate the size parameter
 	size := 256 // default size
-	if sizeStr := r.URL.Query().Get("size"); sizeStr != "" {
+	if sizeStr := q.Get("size"); sizeStr != "" {
 		var err error
 		size, err = strconv.Atoi(sizeStr)
 		if err != nil {
@@ -200,7 +423,7 @@ func qrHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Get and validate the shape parameter
-	shape := r.URL.Query().Get("shape")
+	shape := q.Get("shape")
 	if shape == "" {
 		shape = "square" // default shape
 	}
@@ -210,118 +433,275 @@ func qrHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Get and validate the type parameter
-	codeType := r.URL.Query().Get("type")
+	codeType := q.Get("type")
 	if codeType == "" {
 		codeType = "qr" // default type
 	}
-	if codeType != "qr" && codeType != "barcode" {
-		http.Error(w, "Type must be 'qr' or 'barcode'", http.StatusBadRequest)
+	if codeType == "barcode" {
+		codeType = "code128" // the barcode type is a Code 128 barcode
+	}
+	var symbology linearSymbology
+	var matrix matrixSymbology
+	isMatrix := false
+	if codeType != "qr" {
+		var ok bool
+		if name, sym, found := lookupMatrixSymbology(codeType); found {
+			codeType, matrix, isMatrix = name, sym, true
+		} else if codeType, symbology, ok = lookupSymbology(codeType); !ok {
+			http.Error(w, "Type must be 'qr' or 'barcode', one of the 2D symbologies "+matrixSymbologyList()+
+				", or one of the barcode symbologies "+symbologyList(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	// Get and validate the margin parameter
+	defaultMargin := defaultQRMargin
+	if isMatrix {
+		defaultMargin = matrix.margin
+	} else if codeType != "qr" {
+		defaultMargin = symbology.margin
+	}
+	margin, err := parseMargin(q, defaultMargin)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Get and validate the symbology's options
+	var codeOpts symbologyOptions
+	if isMatrix {
+		codeOpts, err = parseSymbologyOptions(q, codeType, matrix.options, matrix.defaults)
+	} else if codeType != "qr" {
+		codeOpts, err = parseSymbologyOptions(q, codeType, symbology.options, symbology.defaults)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Get and validate the error-correction level parameter
+	ecc := q.Get("ecc")
+	if ecc == "" {
+		ecc = "M" // default level
+	}
+	level, ok := eccLevels[ecc]
+	if !ok {
+		http.Error(w, "ECC must be 'L', 'M', 'Q' or 'H'", http.StatusBadRequest)
+		return
+	}
+	if q.Get("ecc") != "" && codeType != "qr" {
+		http.Error(w, "ECC is only supported for QR codes; Aztec and PDF417 codes take min_ecc and security_level", http.StatusBadRequest)
 		return
 	}
 
-	// Create cache key
-	cacheKey := fmt.Sprintf("%s:%d:%s:%s", text, size, shape, codeType)
+	// Get and validate the human-readable text parameters, which are only
+	// printed below barcodes
+	hrt, hrtText, err := parseHRT(q)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if hrt && (codeType == "qr" || isMatrix) {
+		http.Error(w, "hrt and hrt_text are only supported for barcodes", http.StatusBadRequest)
+		return
+	}
 
-	// Check cache first
-	qrCacheMutex.RLock()
-	cachedQR, found := qrCache[cacheKey]
-	qrCacheMutex.RUnlock()
+	// Gradients are only painted across QR codes
+	if q.Get("gradient") != "" && codeType != "qr" {
+		http.Error(w, "Gradient is only supported for QR codes", http.StatusBadRequest)
+		return
+	}
+
+	// Get and validate the version and mask pattern, which fix the layout
+	// of a QR code
+	version, mask, err := parseQRLayout(q)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if (version != 0 || mask >= 0) && codeType != "qr" {
+		http.Error(w, "Version and mask are only supported for QR codes", http.StatusBadRequest)
+		return
+	}
+
+	// Get and validate the parameters that control how the code is drawn
+	opts, err := parseRenderOptions(q, size, shape)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	contentType := formatContentTypes[opts.format]
+
+	// A POST uploads a logo to composite over the QR code, which needs the
+	// highest error-correction level to make up for the modules it hides
+	var logo *logoOverlay
+	if r.MultipartForm != nil {
+		if codeType != "qr" {
+			http.Error(w, "Logos are only supported for QR codes", http.StatusBadRequest)
+			return
+		}
+		logo, err = readLogo(r, q, opts.bg)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		ecc, level = "H", qrcode.Highest
+	}
+
+	// Get and validate the verify parameter, which decodes the code before
+	// responding
+	verify, err := parseVerify(q, codeType, codeOpts)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Split the text across several codes, which are never cached
+	if q.Get("symbols") != "" {
+		switch {
+		case codeType != "qr":
+			http.Error(w, "Structured Append is only supported for QR codes", http.StatusBadRequest)
+		case logo != nil:
+			http.Error(w, "Logos can not be combined with symbols", http.StatusBadRequest)
+		case q.Get("base64") == "true":
+			http.Error(w, "base64 can not be combined with symbols; use output=json", http.StatusBadRequest)
+		default:
+			serveStructuredAppend(w, q, text, ecc, version, mask, margin, opts, verify)
+		}
+		return
+	}
+
+	// Create cache key; a default quiet zone may be narrowed to fit, so it
+	// is told apart from the same margin requested
+	cacheKey := fmt.Sprintf("%s:%d:%s:%s:%s:%s:%d:%d:%d:%t:%t:%s:%s", text, size, shape, codeType, codeOpts.key(), ecc, version, mask,
+		margin, q.Get("margin") != "", hrt, hrtText, opts.key())
+
+	// Check cache first; codes with a logo are never cached, so uploaded
+	// images are not retained, and verified codes are always drawn afresh
+	cache = cache && logo == nil
+	var cachedQR []byte
+	found := false
+	if cache && !verify {
+		qrCacheMutex.RLock()
+		cachedQR, found = qrCache[cacheKey]
+		qrCacheMutex.RUnlock()
+	}
 
 	if found {
-		if r.URL.Query().Get("base64") == "true" {
+		if q.Get("base64") == "true" {
 			base64Str := base64.StdEncoding.EncodeToString(cachedQR)
 			w.Header().Set("Content-Type", "text/plain")
 			w.Write([]byte(base64Str))
 			return
 		}
-		w.Header().Set("Content-Type", "image/png")
+		w.Header().Set("Content-Type", contentType)
 		w.Write(cachedQR)
 		return
 	}
 
-	// Create a buffer to store the PNG
-	var buf bytes.Buffer
-	var codeImg image.Image
-
-	if codeType == "barcode" {
+	// Encode the text as a grid of modules
+	var modules [][]bool
+	var content string
+	if isMatrix {
+		// Generate the 2D symbol
+		symbol, err := matrix.encode(text, codeOpts)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		modules = addQuietZone(symbol, margin)
+	} else if codeType != "qr" {
 		// Generate barcode
-		bar, err := code128.Encode(text)
+		bar, err := symbology.encode(text, codeOpts)
 		if err != nil {
-			http.Error(w, "Failed to generate barcode", http.StatusInternalServerError)
+			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
-
-		if shape == "rectangle" {
-			// For barcodes, use natural barcode proportions
-			codeImg, err = barcode.Scale(bar, size*4, size)
+		symbol := barcodeModules(bar)
+		margin, err = fitQuietZone(q, len(symbol[0]), margin, size, opts)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		modules = addQuietZone(symbol, margin)
+		content = bar.Content()
+		if hrt {
+			opts.caption = linearCaption(symbology, text, content, hrtText, modules, margin)
+		}
+	} else {
+		// Generate QR code, in the smallest version the text fits unless a
+		// version is requested
+		var qr *qrcode.QRCode
+		if version != 0 {
+			qr, err = qrcode.NewWithForcedVersion(text, version, level)
 			if err != nil {
-				http.Error(w, "Failed to scale barcode", http.StatusInternalServerError)
+				digits, alphanumeric, bytes := qrCapacity(version, ecc)
+				http.Error(w, fmt.Sprintf("Text does not fit a version %d QR code at error-correction level %s, which holds at most %d bytes, %d alphanumeric characters or %d digits",
+					version, ecc, bytes, alphanumeric, digits), http.StatusBadRequest)
 				return
 			}
 		} else {
-			// Square shape for barcode
-			codeImg, err = barcode.Scale(bar, size, size)
+			qr, err = qrcode.New(text, level)
 			if err != nil {
-				http.Error(w, "Failed to scale barcode", http.StatusInternalServerError)
+				http.Error(w, "Failed to generate QR code", http.StatusInternalServerError)
 				return
 			}
 		}
-	} else {
-		// Generate QR code
-		qr, err := qrcode.New(text, qrcode.Medium)
-		if err != nil {
-			http.Error(w, "Failed to generate QR code", http.StatusInternalServerError)
-			return
+		modules = qrModules(qr, margin)
+		if mask >= 0 {
+			remaskQR(modules, margin, qr.VersionNumber, ecc, mask)
 		}
 
-		if shape == "rectangle" {
-			// For rectangle shape, use barcode proportions (approx 4:1 ratio)
-			qrImg := qr.Image(size)
-			width := size * 4
-			height := size
-
-			// Create a new rectangular image
-			rectImg := image.NewRGBA(image.Rect(0, 0, width, height))
-
-			// Fill background with white
-			for y := 0; y < height; y++ {
-				for x := 0; x < width; x++ {
-					rectImg.Set(x, y, color.RGBA{255, 255, 255, 255})
+		// Clear the middle of the symbol for the logo, moving to larger
+		// versions while it would hide the position patterns, unless the
+		// version is fixed
+		if logo != nil {
+			err = logo.place(modules, qr.VersionNumber)
+			for errors.Is(err, errLogoCoversPatterns) && version == 0 && qr.VersionNumber < 40 {
+				qr, err = qrcode.NewWithForcedVersion(text, qr.VersionNumber+1, level)
+				if err != nil {
+					http.Error(w, "Failed to generate QR code", http.StatusInternalServerError)
+					return
 				}
-			}
-
-			// Center the QR code in the rectangular image
-			offsetX := (width - size) / 2
-			offsetY := (height - size) / 2
-
-			// Draw the QR code in the center
-			for y := 0; y < size; y++ {
-				for x := 0; x < size; x++ {
-					c := qrImg.At(x, y)
-					rectImg.Set(x+offsetX, y+offsetY, c)
+				modules = qrModules(qr, margin)
+				if mask >= 0 {
+					remaskQR(modules, margin, qr.VersionNumber, ecc, mask)
 				}
+				err = logo.place(modules, qr.VersionNumber)
 			}
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			opts.logo = logo
+		}
+	}
 
-			codeImg = rectImg
-		} else {
-			// Default square shape
-			codeImg = qr.Image(size)
+	// Read the code back before rendering it in the requested format
+	if verify {
+		want := verifyText(codeType, text, content, codeOpts)
+		if err := verifyCode(w, modules, opts, codeType, want, codeOpts); err != nil {
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+			return
 		}
 	}
 
-	// Encode the image to PNG
-	if err := png.Encode(&buf, codeImg); err != nil {
+	// Render the code in the requested format
+	var buf bytes.Buffer
+	if err := renderCode(&buf, modules, opts); err != nil {
 		http.Error(w, "Failed to encode image", http.StatusInternalServerError)
 		return
 	}
 
 	// Store in cache
-	qrCacheMutex.Lock()
-	qrCache[cacheKey] = buf.Bytes()
-	qrCacheMutex.Unlock()
+	if cache {
+		qrCacheMutex.Lock()
+		qrCache[cacheKey] = buf.Bytes()
+		qrCacheMutex.Unlock()
+	}
 
 	// Check if base64 encoding is requested
-	if r.URL.Query().Get("base64") == "true" {
+	if q.Get("base64") == "true" {
 		// Encode the image to base64
 		base64Str := base64.StdEncoding.EncodeToString(buf.Bytes())
 		w.Header().Set("Content-Type", "text/plain")
@@ -329,8 +709,8 @@ func qrHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// If not base64, return the PNG image
-	w.Header().Set("Content-Type", "image/png")
+	// If not base64, return the image
+	w.Header().Set("Content-Type", contentType)
 	w.Write(buf.Bytes())
 }
 
@@ -371,29 +751,89 @@ func barcodeHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Generate barcode
-	bar, err := code128.Encode(text)
+	// Get and validate the symbology parameter
+	name := r.URL.Query().Get("symbology")
+	if name == "" {
+		name = "code128" // default symbology
+	}
+	name, symbology, ok := lookupSymbology(name)
+	if !ok {
+		http.Error(w, "Symbology must be "+symbologyList(), http.StatusBadRequest)
+		return
+	}
+	barOpts, err := parseSymbologyOptions(r.URL.Query(), name, symbology.options, symbology.defaults)
 	if err != nil {
-		http.Error(w, "Failed to generate barcode", http.StatusInternalServerError)
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	// Scale barcode to requested size based on shape
-	var scaledBar image.Image
-	if shape == "rectangle" {
-		// For rectangle shape, use natural barcode proportions (4:1 ratio)
-		scaledBar, err = barcode.Scale(bar, size*4, size)
-	} else {
-		// Square shape
-		scaledBar, err = barcode.Scale(bar, size, size)
+	// Get and validate the margin parameter
+	margin, err := parseMargin(r.URL.Query(), symbology.margin)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Gradients are only painted across QR codes
+	if r.URL.Query().Get("gradient") != "" {
+		http.Error(w, "Gradient is only supported for QR codes", http.StatusBadRequest)
+		return
 	}
+
+	// Get and validate the human-readable text parameters
+	hrt, hrtText, err := parseHRT(r.URL.Query())
 	if err != nil {
-		http.Error(w, "Failed to scale barcode", http.StatusInternalServerError)
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
+	// Get and validate the parameters that control how the barcode is drawn
+	opts, err := parseRenderOptions(r.URL.Query(), size, shape)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	contentType := formatContentTypes[opts.format]
+
+	// Get and validate the verify parameter, which decodes the barcode
+	// before responding
+	verify, err := parseVerify(r.URL.Query(), name, barOpts)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Generate barcode
+	bar, err := symbology.encode(text, barOpts)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	symbol := barcodeModules(bar)
+	margin, err = fitQuietZone(r.URL.Query(), len(symbol[0]), margin, size, opts)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	modules := addQuietZone(symbol, margin)
+
+	// Print the text below the bars, grouped the way the symbology prints
+	// it unless it is overridden
+	if hrt {
+		opts.caption = linearCaption(symbology, text, bar.Content(), hrtText, modules, margin)
+	}
+
+	if verify {
+		want := verifyText(name, text, bar.Content(), barOpts)
+		if err := verifyCode(w, modules, opts, name, want, barOpts); err != nil {
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+	}
+
 	var buf bytes.Buffer
-	if err := png.Encode(&buf, scaledBar); err != nil {
+	if err := renderCode(&buf, modules, opts); err != nil {
 		http.Error(w, "Failed to encode barcode", http.StatusInternalServerError)
 		return
 	}
@@ -405,7 +845,7 @@ func barcodeHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	w.Header().Set("Content-Type", "image/png")
+	w.Header().Set("Content-Type", contentType)
 	w.Write(buf.Bytes())
 }
 
@@ -418,8 +858,30 @@ func main() {
 	http.HandleFunc("/image", imageHandler)
 	// Register the QR code handler
 	http.HandleFunc("/qr", qrHandler)
+	// Register the Wi-Fi network QR code handler
+	http.HandleFunc("/qr/wifi", payloadHandler(wifiPayload, ""))
+	// Register the calendar event QR code handler
+	http.HandleFunc("/qr/event", payloadHandler(eventPayload, ""))
+	// Register the authenticator enrollment QR code handler
+	http.HandleFunc("/qr/otp", otpHandler)
+	// Register the URI scheme QR code handlers
+	http.HandleFunc("/qr/geo", payloadHandler(geoPayload, ""))
+	http.HandleFunc("/qr/tel", payloadHandler(telPayload, ""))
+	http.HandleFunc("/qr/sms", payloadHandler(smsPayload, ""))
+	http.HandleFunc("/qr/mailto", payloadHandler(mailtoPayload, ""))
+	// Register the cryptocurrency payment QR code handlers
+	http.HandleFunc("/qr/bitcoin", payloadHandler(bitcoinPayload, ""))
+	http.HandleFunc("/qr/ethereum", payloadHandler(ethereumPayload, ""))
+	// Register the contact card QR code handler
+	http.HandleFunc("/qr/contact", contactHandler)
+	// Register the EPC payment QR code handler, which mandates level M
+	http.HandleFunc("/qr/epc", payloadHandler(epcPayload, "M"))
+	// Register the Swiss QR-bill handler
+	http.HandleFunc("/qr/swiss", swissBillHandler)
 	// Register the barcode handler
 	http.HandleFunc("/barcode", barcodeHandler)
+	// Register the decode handler
+	http.HandleFunc("/decode", decodeHandler)
 	// Register the ping handler
 	http.HandleFunc("/ping", pingHandler)
 
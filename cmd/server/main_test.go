@@ -3,6 +3,7 @@ package main
 import (
 	"bytes"
 	"encoding/base64"
+	"image/png"
 	"net/http"
 	"net/http/httptest"
 	"strings"
@@ -361,6 +362,13 @@ func resetRateLimiter() {
 	ipRateLimiter = NewIPRateLimiter(10, 20)
 }
 
+// isolateRateLimiter gives the test a fresh rate limiter and resets it again
+// once the test ends, so its requests never starve the tests that follow.
+func isolateRateLimiter(t *testing.T) {
+	resetRateLimiter()
+	t.Cleanup(resetRateLimiter)
+}
+
 func TestQRHandler_RateLimit(t *testing.T) {
 	resetRateLimiter() // Reset rate limiter before test
 
@@ -701,3 +709,245 @@ func TestBarcodeHandler_Rectangle_DifferentFromSquare(t *testing.T) {
 		t.Fatal("square and rectangle shapes should produce different images")
 	}
 }
+
+// Tests for ECC Parameter
+
+// qrFormatInfo reads the format information next to the top-left finder
+// pattern of a rendered QR code and returns its error-correction level and
+// mask pattern.
+func qrFormatInfo(t *testing.T, data []byte) (string, int) {
+	t.Helper()
+
+	img, err := png.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("failed to decode PNG: %v", err)
+	}
+	dark := func(x, y int) bool {
+		r, g, b, _ := img.At(x, y).RGBA()
+		return r+g+b < 3*0x8000
+	}
+
+	// Locate the top-left corner of the finder pattern and derive the module
+	// size from its 7-module wide top edge
+	bounds := img.Bounds()
+	x0, y0 := -1, -1
+	for y := bounds.Min.Y; y < bounds.Max.Y && x0 < 0; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			if dark(x, y) {
+				x0, y0 = x, y
+				break
+			}
+		}
+	}
+	if x0 < 0 {
+		t.Fatal("no dark modules found")
+	}
+	run := 0
+	for dark(x0+run, y0) {
+		run++
+	}
+	module := float64(run) / 7
+	at := func(col, row int) bool {
+		return dark(x0+int((float64(col)+0.5)*module), y0+int((float64(row)+0.5)*module))
+	}
+
+	// Read the 15 format bits, most significant first
+	bits := 0
+	read := func(col, row int) {
+		bits <<= 1
+		if at(col, row) {
+			bits |= 1
+		}
+	}
+	for col := 0; col < 6; col++ {
+		read(col, 8)
+	}
+	read(7, 8)
+	read(8, 8)
+	read(8, 7)
+	for row := 5; row >= 0; row-- {
+		read(8, row)
+	}
+
+	// Find the format word matching the bits read
+	for info := 0; info < 32; info++ {
+		rem := info << 10
+		for i := 14; i >= 10; i-- {
+			if rem&(1<<i) != 0 {
+				rem ^= 0x537 << (i - 10)
+			}
+		}
+		if (info<<10|rem)^0x5412 == bits {
+			return []string{"M", "L", "H", "Q"}[info>>3], info & 7
+		}
+	}
+	t.Fatalf("invalid format information %015b", bits)
+	return "", 0
+}
+
+func TestQRHandler_ECC_Levels(t *testing.T) {
+	isolateRateLimiter(t)
+
+	for _, ecc := range []string{"L", "M", "Q", "H"} {
+		req := httptest.NewRequest("GET", "/qr?text=hello&ecc="+ecc, nil)
+		rr := httptest.NewRecorder()
+		qrHandler(rr, req)
+
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected status 200 for ecc %s, got %d", ecc, rr.Code)
+		}
+		if got, _ := qrFormatInfo(t, rr.Body.Bytes()); got != ecc {
+			t.Fatalf("expected ECC level %s, got %s", ecc, got)
+		}
+	}
+}
+
+func TestQRHandler_ECC_Default(t *testing.T) {
+	isolateRateLimiter(t)
+
+	// Test that default ECC level is M
+	req := httptest.NewRequest("GET", "/qr?text=hello", nil)
+	rr := httptest.NewRecorder()
+	qrHandler(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", rr.Code)
+	}
+	if got, _ := qrFormatInfo(t, rr.Body.Bytes()); got != "M" {
+		t.Fatalf("expected ECC level M, got %s", got)
+	}
+}
+
+func TestQRHandler_ECC_Invalid(t *testing.T) {
+	isolateRateLimiter(t)
+
+	req := httptest.NewRequest("GET", "/qr?text=hello&ecc=X", nil)
+	rr := httptest.NewRecorder()
+	qrHandler(rr, req)
+
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected status 400, got %d", rr.Code)
+	}
+	if !strings.Contains(rr.Body.String(), "ECC must be 'L', 'M', 'Q' or 'H'") {
+		t.Fatalf("expected error about ECC, got %s", rr.Body.String())
+	}
+}
+
+func TestQRHandler_ECC_NotQR(t *testing.T) {
+	isolateRateLimiter(t)
+
+	// Other types have their own error correction, or none at all
+	for _, typ := range []string{"aztec", "datamatrix", "pdf417", "barcode", "ean13"} {
+		resetRateLimiter() // Reset rate limiter before each request
+
+		req := httptest.NewRequest("GET", "/qr?text=5901234123457&ecc=H&type="+typ, nil)
+		rr := httptest.NewRecorder()
+		qrHandler(rr, req)
+
+		if rr.Code != http.StatusBadRequest {
+			t.Fatalf("expected status 400 for %s, got %d", typ, rr.Code)
+		}
+		if !strings.Contains(rr.Body.String(), "ECC is only supported for QR codes") {
+			t.Fatalf("expected error about ECC for %s, got %s", typ, rr.Body.String())
+		}
+	}
+}
+
+func TestQRHandler_Cache_DifferentECC(t *testing.T) {
+	isolateRateLimiter(t)
+
+	// Test that different ECC levels create different cache entries
+	req1 := httptest.NewRequest("GET", "/qr?text=testcache&ecc=L", nil)
+	rr1 := httptest.NewRecorder()
+	qrHandler(rr1, req1)
+
+	req2 := httptest.NewRequest("GET", "/qr?text=testcache&ecc=H", nil)
+	rr2 := httptest.NewRecorder()
+	qrHandler(rr2, req2)
+
+	if bytes.Equal(rr1.Body.Bytes(), rr2.Body.Bytes()) {
+		t.Fatal("different ECC levels should not have same cache entry")
+	}
+}
+
+func TestQRHandler_VersionAndMask(t *testing.T) {
+	isolateRateLimiter(t)
+
+	cases := []struct {
+		query   string
+		version int
+		mask    int
+	}{
+		{"version=5", 5, -1},
+		{"version=10&ecc=H", 10, -1},
+		{"mask=3", 1, 3},
+		{"version=7&mask=0", 7, 0},
+		{"version=2&mask=7&margin=1", 2, 7},
+	}
+	for _, tc := range cases {
+		resetRateLimiter() // Reset rate limiter before each request
+
+		req := httptest.NewRequest("GET", "/qr?text=hello&"+tc.query, nil)
+		rr := httptest.NewRecorder()
+		qrHandler(rr, req)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected status 200 for %s, got %d: %s", tc.query, rr.Code, rr.Body.String())
+		}
+		code := decodeOutput(t, rr.Body.Bytes())
+		if code.Text != "hello" || code.Version != tc.version {
+			t.Fatalf("expected a version %d code reading hello for %s, got %+v", tc.version, tc.query, code)
+		}
+		if _, mask := qrFormatInfo(t, rr.Body.Bytes()); tc.mask >= 0 && mask != tc.mask {
+			t.Fatalf("expected mask %d for %s, got %d", tc.mask, tc.query, mask)
+		}
+	}
+}
+
+func TestQRHandler_VersionAndMask_Invalid(t *testing.T) {
+	isolateRateLimiter(t)
+
+	cases := []struct {
+		query string
+		msg   string
+	}{
+		{"text=hello&version=0", "Version must be a whole number between 1 and 40"},
+		{"text=hello&version=41", "Version must be a whole number between 1 and 40"},
+		{"text=hello&version=v2", "Version must be a whole number between 1 and 40"},
+		{"text=hello&mask=8", "Mask must be a whole number between 0 and 7"},
+		{"text=hello&mask=-1", "Mask must be a whole number between 0 and 7"},
+		{"text=hello&type=datamatrix&version=2", "Version and mask are only supported for QR codes"},
+		{"text=hello&type=barcode&mask=2", "Version and mask are only supported for QR codes"},
+		{"text=" + strings.Repeat("a", 15) + "&version=1",
+			"Text does not fit a version 1 QR code at error-correction level M, which holds at most 14 bytes, 20 alphanumeric characters or 34 digits"},
+		{"text=" + strings.Repeat("1", 100) + "&version=2&ecc=H", "which holds at most 14 bytes, 20 alphanumeric characters or 34 digits"},
+	}
+	for _, tc := range cases {
+		resetRateLimiter() // Reset rate limiter before each request
+
+		req := httptest.NewRequest("GET", "/qr?"+tc.query, nil)
+		rr := httptest.NewRecorder()
+		qrHandler(rr, req)
+		if rr.Code != http.StatusBadRequest {
+			t.Fatalf("expected status 400 for %s, got %d", tc.query, rr.Code)
+		}
+		if !strings.Contains(rr.Body.String(), tc.msg) {
+			t.Fatalf("expected error %q, got %s", tc.msg, rr.Body.String())
+		}
+	}
+}
+
+func TestQRHandler_Cache_DifferentMasks(t *testing.T) {
+	isolateRateLimiter(t)
+
+	req1 := httptest.NewRequest("GET", "/qr?text=testcache&mask=1", nil)
+	rr1 := httptest.NewRecorder()
+	qrHandler(rr1, req1)
+
+	req2 := httptest.NewRequest("GET", "/qr?text=testcache&mask=2", nil)
+	rr2 := httptest.NewRecorder()
+	qrHandler(rr2, req2)
+
+	if bytes.Equal(rr1.Body.Bytes(), rr2.Body.Bytes()) {
+		t.Fatal("different masks should not have same cache entry")
+	}
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAddQuietZone(t *testing.T) {
+	// 2D codes get the margin on all four sides
+	got := addQuietZone([][]bool{{true, false}, {false, true}}, 2)
+	if len(got) != 6 || len(got[0]) != 6 {
+		t.Fatalf("expected a 6x6 grid, got %dx%d", len(got[0]), len(got))
+	}
+	if !got[2][2] || got[2][3] || got[3][2] || !got[3][3] {
+		t.Fatalf("modules not copied into the middle: %v", got)
+	}
+
+	// 1D codes only get it on the left and right
+	got = addQuietZone([][]bool{{true, true, false, true}}, 3)
+	if len(got) != 1 || len(got[0]) != 10 {
+		t.Fatalf("expected a 10x1 grid, got %dx%d", len(got[0]), len(got))
+	}
+	want := []bool{false, false, false, true, true, false, true, false, false, false}
+	for x := range want {
+		if got[0][x] != want[x] {
+			t.Fatalf("module %d: expected %v, got %v", x, want[x], got[0][x])
+		}
+	}
+}
+
+func TestQRHandler_Margin(t *testing.T) {
+	isolateRateLimiter(t)
+
+	// "hello" is a 21-module symbol; the default quiet zone is 4 modules
+	for margin, want := range map[string]int{"": 29, "0": 21, "1": 23, "10": 41} {
+		resetRateLimiter() // Reset rate limiter before each request
+
+		req := httptest.NewRequest("GET", "/qr?text=hello&format=svg&margin="+margin, nil)
+		rr := httptest.NewRecorder()
+		qrHandler(rr, req)
+
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected status 200 for margin %q, got %d", margin, rr.Code)
+		}
+		doc := parseSVG(t, rr.Body.Bytes())
+		if doc.ViewBox != fmt.Sprintf("0 0 %d %d", want, want) {
+			t.Fatalf("expected a %d-module viewBox for margin %q, got %q", want, margin, doc.ViewBox)
+		}
+		// The top-left finder pattern starts right after the quiet zone
+		start := fmt.Sprintf("M%d %dh7v1h-7z", (want-21)/2, (want-21)/2)
+		if !strings.HasPrefix(doc.Paths[0].D, start) {
+			t.Fatalf("expected path to start with %s for margin %q, got %q", start, margin, doc.Paths[0].D[:20])
+		}
+	}
+}
+
+func TestBarcodeHandler_Margin(t *testing.T) {
+	isolateRateLimiter(t)
+
+	// Code 128 defaults to a 10-module quiet zone on either side
+	for margin, want := range map[string]int{"": 10, "0": 0, "25": 25} {
+		resetRateLimiter() // Reset rate limiter before each request
+
+		req := httptest.NewRequest("GET", "/barcode?text=1234567890&format=svg&margin="+margin, nil)
+		rr := httptest.NewRecorder()
+		barcodeHandler(rr, req)
+
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected status 200 for margin %q, got %d", margin, rr.Code)
+		}
+		doc := parseSVG(t, rr.Body.Bytes())
+		if !strings.HasPrefix(doc.Paths[0].D, fmt.Sprintf("M%d 0h", want)) {
+			t.Fatalf("expected first bar at module %d for margin %q, got %q", want, margin, doc.Paths[0].D[:20])
+		}
+	}
+
+	// type=barcode on /qr uses the same default
+	resetRateLimiter()
+	req := httptest.NewRequest("GET", "/qr?text=1234567890&type=barcode&format=svg", nil)
+	rr := httptest.NewRecorder()
+	qrHandler(rr, req)
+	if doc := parseSVG(t, rr.Body.Bytes()); !strings.HasPrefix(doc.Paths[0].D, "M10 0h") {
+		t.Fatalf("expected a 10-module quiet zone, got %q", doc.Paths[0].D[:20])
+	}
+}
+
+func TestBarcodeHandler_NarrowSize(t *testing.T) {
+	isolateRateLimiter(t)
+
+	// The 90 modules of the Code 128 bars fit 100 pixels, but not with the
+	// default quiet zone, which is narrowed to fit
+	for _, tc := range []struct {
+		target  string
+		handler http.HandlerFunc
+	}{
+		{"/barcode?text=1234567890&shape=square&size=100", barcodeHandler},
+		{"/qr?text=1234567890&type=barcode&size=100", qrHandler},
+	} {
+		resetRateLimiter() // Reset rate limiter before each request
+
+		req := httptest.NewRequest("GET", tc.target, nil)
+		rr := httptest.NewRecorder()
+		tc.handler(rr, req)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected status 200 for %s, got %d: %s", tc.target, rr.Code, rr.Body.String())
+		}
+		if code := decodeOutput(t, rr.Body.Bytes()); code.Text != "1234567890" {
+			t.Fatalf("expected the barcode to read back for %s, got %+v", tc.target, code)
+		}
+	}
+
+	// A requested margin is kept, and bars too wide for the image are
+	// rejected, naming the size that fits
+	cases := map[string]string{
+		"type=barcode&text=1234567890&size=100&margin=10":                              "The barcode is 110 modules wide, which needs a size of at least 110 pixels",
+		"type=barcode&text=" + strings.Repeat("A", 40) + "&size=100":                   "needs a size of at least",
+		"type=code39&symbology=code39&full_ascii=true&text=" + strings.Repeat("a", 80): "too wide for a PNG image of at most 1000 pixels; use format=svg",
+	}
+	for query, msg := range cases {
+		for _, handler := range []http.HandlerFunc{qrHandler, barcodeHandler} {
+			resetRateLimiter() // Reset rate limiter before each request
+
+			req := httptest.NewRequest("GET", "/?shape=square&"+query, nil)
+			rr := httptest.NewRecorder()
+			handler(rr, req)
+
+			if rr.Code != http.StatusBadRequest {
+				t.Fatalf("expected status 400 for %s, got %d", query, rr.Code)
+			}
+			if !strings.Contains(rr.Body.String(), msg) {
+				t.Fatalf("expected error %q for %s, got %s", msg, query, rr.Body.String())
+			}
+		}
+	}
+}
+
+func TestMargin_Invalid(t *testing.T) {
+	isolateRateLimiter(t)
+
+	cases := map[string]string{
+		"margin=abc": "Margin must be a valid number",
+		"margin=-1":  "Margin must be between 0 and 40 modules",
+		"margin=41":  "Margin must be between 0 and 40 modules",
+	}
+	for query, msg := range cases {
+		for _, handler := range []http.HandlerFunc{qrHandler, barcodeHandler} {
+			resetRateLimiter() // Reset rate limiter before each request
+
+			req := httptest.NewRequest("GET", "/?text=1234567890&"+query, nil)
+			rr := httptest.NewRecorder()
+			handler(rr, req)
+
+			if rr.Code != http.StatusBadRequest {
+				t.Fatalf("expected status 400 for %s, got %d", query, rr.Code)
+			}
+			if !strings.Contains(rr.Body.String(), msg) {
+				t.Fatalf("expected error %q for %s, got %s", msg, query, rr.Body.String())
+			}
+		}
+	}
+}
+
+func TestQRHandler_Cache_DifferentMargins(t *testing.T) {
+	isolateRateLimiter(t)
+
+	// Test that different margins create different cache entries
+	req1 := httptest.NewRequest("GET", "/qr?text=testcache&margin=4", nil)
+	rr1 := httptest.NewRecorder()
+	qrHandler(rr1, req1)
+
+	req2 := httptest.NewRequest("GET", "/qr?text=testcache&margin=8", nil)
+	rr2 := httptest.NewRecorder()
+	qrHandler(rr2, req2)
+
+	if bytes.Equal(rr1.Body.Bytes(), rr2.Body.Bytes()) {
+		t.Fatal("different margins should not have same cache entry")
+	}
+}
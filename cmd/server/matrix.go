@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/boombuler/barcode/aztec"
+	"github.com/makiuchi-d/gozxing"
+	"github.com/makiuchi-d/gozxing/datamatrix"
+	dmdecoder "github.com/makiuchi-d/gozxing/datamatrix/decoder"
+	"github.com/makiuchi-d/gozxing/datamatrix/encoder"
+)
+
+// matrixSymbology is a 2D symbology other than QR that /qr can draw through
+// its type parameter.
+type matrixSymbology struct {
+	// encode validates text and returns the symbol's module grid, without a
+	// quiet zone. Its errors are shown to the client.
+	encode func(text string, opts symbologyOptions) ([][]bool, error)
+
+	// Quiet zone around the symbol, in modules, when no margin is given
+	margin int
+
+	// Names of the options the symbology accepts, and their defaults
+	options  []string
+	defaults symbologyOptions
+}
+
+// Supported 2D symbologies besides QR, keyed by the lower-case name without
+// dashes
+var matrixSymbologies = map[string]matrixSymbology{
+	"datamatrix": {encode: encodeDataMatrix, margin: 1, options: []string{"rectangular", "gs1"}},
+	"aztec": {encode: encodeAztec, margin: 1, options: []string{"min_ecc"},
+		defaults: symbologyOptions{minECC: 23}},
+	"pdf417": {encode: encodePDF417, margin: 2, options: []string{"security_level", "columns"},
+		defaults: symbologyOptions{securityLevel: 2}},
+}
+
+// lookupMatrixSymbology finds a 2D symbology by name. It returns the
+// canonical name along with the symbology.
+func lookupMatrixSymbology(name string) (string, matrixSymbology, bool) {
+	name = symbologyName(name)
+	sym, ok := matrixSymbologies[name]
+	return name, sym, ok
+}
+
+// matrixSymbologyList lists the 2D symbology names for error messages.
+func matrixSymbologyList() string {
+	names := make([]string, 0, len(matrixSymbologies))
+	for name := range matrixSymbologies {
+		names = append(names, "'"+name+"'")
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// latin1Bytes returns text in Latin-1, the character set 2D symbols carry
+// their bytes in unless told otherwise, naming the symbology in its error.
+func latin1Bytes(text, name string) ([]byte, error) {
+	latin1 := make([]byte, 0, len(text))
+	for _, r := range text {
+		if r > 0xff {
+			return nil, fmt.Errorf("%s codes can only encode Latin-1 characters", name)
+		}
+		latin1 = append(latin1, byte(r))
+	}
+	return latin1, nil
+}
+
+// encodeDataMatrix draws an ECC 200 Data Matrix symbol, square unless
+// rectangular is set.
+func encodeDataMatrix(text string, opts symbologyOptions) ([][]bool, error) {
+	if opts.gs1 {
+		return encodeGS1DataMatrix(text, opts)
+	}
+	latin1, err := latin1Bytes(text, "Data Matrix")
+	if err != nil {
+		return nil, err
+	}
+	shape, shapeName := encoder.SymbolShapeHint_FORCE_SQUARE, "square"
+	if opts.rectangular {
+		shape, shapeName = encoder.SymbolShapeHint_FORCE_RECTANGLE, "rectangular"
+	}
+	hints := map[gozxing.EncodeHintType]interface{}{gozxing.EncodeHintType_DATA_MATRIX_SHAPE: shape}
+	matrix, err := datamatrix.NewDataMatrixWriter().Encode(text, gozxing.BarcodeFormat_DATA_MATRIX, 0, 0, hints)
+	if err != nil {
+		return nil, fmt.Errorf("Text is too long for a %s Data Matrix code", shapeName)
+	}
+	modules := make([][]bool, matrix.GetHeight())
+	for y := range modules {
+		modules[y] = make([]bool, matrix.GetWidth())
+		for x := range modules[y] {
+			modules[y][x] = matrix.Get(x, y)
+		}
+	}
+
+	// The writer truncates text that outgrows the largest symbol of the
+	// shape instead of failing, so read the symbol back to be sure. The
+	// decoder returns the Latin-1 bytes as they are.
+	result, err := dmdecoder.NewDecoder().DecodeBoolMap(modules)
+	if err != nil || result.GetText() != string(latin1) {
+		return nil, fmt.Errorf("Text is too long for a %s Data Matrix code", shapeName)
+	}
+	return modules, nil
+}
+
+// encodeAztec draws an Aztec symbol with at least minECC percent of the data
+// added as error correction.
+func encodeAztec(text string, opts symbologyOptions) ([][]bool, error) {
+	latin1, err := latin1Bytes(text, "Aztec")
+	if err != nil {
+		return nil, err
+	}
+	bar, err := aztec.Encode(latin1, opts.minECC, aztec.DEFAULT_LAYERS)
+	if err != nil {
+		return nil, fmt.Errorf("Text is too long for an Aztec code with %d%% error correction", opts.minECC)
+	}
+	return barcodeModules(bar), nil
+}
@@ -0,0 +1,313 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/boombuler/barcode/pdf417"
+	"github.com/makiuchi-d/gozxing"
+	"github.com/makiuchi-d/gozxing/aztec"
+	"github.com/makiuchi-d/gozxing/datamatrix"
+)
+
+func TestPDF417ErrorCorrection(t *testing.T) {
+	// The worked example of the PDF417 specification, at security level 1
+	got := pdf417ErrorCorrection([]int{5, 453, 178, 121, 239}, 4)
+	want := []int{452, 327, 657, 619}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("expected error-correction codewords %v, got %v", want, got)
+	}
+}
+
+func TestPDF417ByteCompaction(t *testing.T) {
+	// Six bytes make five base-900 codewords after latch 924; single bytes
+	// are sent as they are after latch 901
+	if got := fmt.Sprint(pdf417ByteCompaction([]byte("ABCDEF"))); got != "[924 109 326 368 127 330]" {
+		t.Fatalf("unexpected codewords for ABCDEF: %s", got)
+	}
+	if got := fmt.Sprint(pdf417ByteCompaction([]byte("AB"))); got != "[901 65 66]" {
+		t.Fatalf("unexpected codewords for AB: %s", got)
+	}
+}
+
+func TestEncodePDF417(t *testing.T) {
+	// 20 bytes take 1 + 18 codewords, plus the length descriptor and 8
+	// error-correction codewords at level 2: 7 rows of 4 columns
+	modules, err := encodePDF417("PDF417 boarding pass", symbologyOptions{securityLevel: 2, columns: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(modules) != 7*pdf417RowHeight || len(modules[0]) != 17*4+69 {
+		t.Fatalf("expected a %dx%d grid, got %dx%d", 17*4+69, 7*pdf417RowHeight, len(modules[0]), len(modules))
+	}
+
+	bits := func(row []bool) string {
+		var s strings.Builder
+		for _, dark := range row {
+			if dark {
+				s.WriteByte('1')
+			} else {
+				s.WriteByte('0')
+			}
+		}
+		return s.String()
+	}
+	first := bits(modules[0])
+	if !strings.HasPrefix(first, fmt.Sprintf("%b", pdf417Start)) || !strings.HasSuffix(first, fmt.Sprintf("%b", pdf417Stop)) {
+		t.Fatalf("expected the row between start and stop patterns, got %s", first)
+	}
+
+	// The first row's indicators hold the row count on the left and the
+	// column count on the right
+	if left := first[17:34]; left != fmt.Sprintf("%b", pdf417Patterns[0][(7-1)/3]) {
+		t.Fatalf("unexpected left row indicator %s", left)
+	}
+	if right := first[len(first)-35 : len(first)-18]; right != fmt.Sprintf("%b", pdf417Patterns[0][4-1]) {
+		t.Fatalf("unexpected right row indicator %s", right)
+	}
+}
+
+// readPDF417 reads the codewords of a PDF417 symbol drawn rowHeight modules
+// per row, row indicators included, by looking up the pattern of each in
+// the cluster of its row. gozxing has no PDF417 reader.
+func readPDF417(t *testing.T, modules [][]bool, rowHeight int) [][]int {
+	t.Helper()
+	var codes [3]map[uint32]int
+	for cluster := range codes {
+		codes[cluster] = map[uint32]int{}
+		for cw, pattern := range pdf417Patterns[cluster] {
+			codes[cluster][pattern] = cw
+		}
+	}
+	var rows [][]int
+	for y := 0; y < len(modules); y += rowHeight {
+		row := modules[y]
+		var codewords []int
+		// Skip the start pattern and stop at the stop pattern
+		for x := 17; x+17 < len(row)-1; x += 17 {
+			var pattern uint32
+			for _, dark := range row[x : x+17] {
+				pattern <<= 1
+				if dark {
+					pattern |= 1
+				}
+			}
+			cw, ok := codes[len(rows)%3][pattern]
+			if !ok {
+				t.Fatalf("row %d holds %017b, which is not a codeword of its cluster", len(rows), pattern)
+			}
+			codewords = append(codewords, cw)
+		}
+		rows = append(rows, codewords)
+	}
+	return rows
+}
+
+// pdf417Data joins the codewords of the rows read by readPDF417 without
+// their row indicators.
+func pdf417Data(rows [][]int) []int {
+	var data []int
+	for _, row := range rows {
+		data = append(data, row[1:len(row)-1]...)
+	}
+	return data
+}
+
+func TestEncodePDF417_ReadBack(t *testing.T) {
+	text := "Boarding pass LH 2024, seat 17A"
+	modules, err := encodePDF417(text, symbologyOptions{securityLevel: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	codewords := pdf417Data(readPDF417(t, modules, pdf417RowHeight))
+
+	// The length descriptor counts the data codewords, which are followed
+	// by 16 error-correction codewords at level 3
+	n := codewords[0]
+	if len(codewords)-n != 16 {
+		t.Fatalf("expected 16 error-correction codewords after %d data codewords, got %d", n, len(codewords)-n)
+	}
+	if ec := pdf417ErrorCorrection(codewords[:n], 16); fmt.Sprint(ec) != fmt.Sprint(codewords[n:]) {
+		t.Fatalf("expected error-correction codewords %v, got %v", ec, codewords[n:])
+	}
+
+	// Byte compaction turns five codewords back into six bytes, and the
+	// bytes left over are one codeword each
+	data := codewords[1:n]
+	for len(data) > 0 && data[len(data)-1] == 900 {
+		data = data[:len(data)-1] // Padding
+	}
+	latch, data := data[0], data[1:]
+	var got []byte
+	for len(data) > 5 || latch == 924 && len(data) == 5 {
+		var v uint64
+		for _, cw := range data[:5] {
+			v = v*900 + uint64(cw)
+		}
+		for i := 5; i >= 0; i-- {
+			got = append(got, byte(v>>(8*i)))
+		}
+		data = data[5:]
+	}
+	for _, cw := range data {
+		got = append(got, byte(cw))
+	}
+	if string(got) != text {
+		t.Fatalf("expected %q, got %q", text, got)
+	}
+}
+
+func TestPDF417_Boombuler(t *testing.T) {
+	// The codeword tables and the error correction agree with an
+	// independent encoder: every codeword boombuler draws is in the tables,
+	// and its error-correction codewords are the ones computed here
+	for level := 0; level <= 8; level++ {
+		bar, err := pdf417.Encode("PDF417 boarding pass 0123456789", byte(level))
+		if err != nil {
+			t.Fatal(err)
+		}
+		codewords := pdf417Data(readPDF417(t, barcodeModules(bar), 2))
+		k := 2 << level
+		n := len(codewords) - k
+		if codewords[0] != n {
+			t.Fatalf("expected a length descriptor of %d at level %d, got %d", n, level, codewords[0])
+		}
+		if ec := pdf417ErrorCorrection(codewords[:n], k); fmt.Sprint(ec) != fmt.Sprint(codewords[n:]) {
+			t.Fatalf("expected error-correction codewords %v at level %d, got %v", ec, level, codewords[n:])
+		}
+	}
+}
+
+func decodeMatrix(t *testing.T, reader gozxing.Reader, data []byte) string {
+	t.Helper()
+	img, err := png.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("failed to decode PNG: %v", err)
+	}
+	bmp, err := gozxing.NewBinaryBitmapFromImage(img)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := reader.Decode(bmp, nil)
+	if err != nil {
+		t.Fatalf("failed to read symbol: %v", err)
+	}
+	return result.GetText()
+}
+
+func TestQRHandler_MatrixSymbologies(t *testing.T) {
+	isolateRateLimiter(t)
+
+	const text = "SHIP 00354123450000000017"
+	cases := []struct {
+		query  string
+		reader gozxing.Reader
+	}{
+		{"type=datamatrix", datamatrix.NewDataMatrixReader()},
+		{"type=data-matrix&rectangular=true&shape=rectangle", datamatrix.NewDataMatrixReader()},
+		{"type=aztec", aztec.NewAztecReader()},
+		{"type=Aztec&min_ecc=50", aztec.NewAztecReader()},
+	}
+	for _, tc := range cases {
+		resetRateLimiter() // Reset rate limiter before each request
+
+		req := httptest.NewRequest("GET", "/qr?text="+strings.ReplaceAll(text, " ", "+")+"&"+tc.query, nil)
+		rr := httptest.NewRecorder()
+		qrHandler(rr, req)
+
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected status 200 for %s, got %d: %s", tc.query, rr.Code, rr.Body.String())
+		}
+		if got := decodeMatrix(t, tc.reader, rr.Body.Bytes()); got != text {
+			t.Fatalf("expected %q for %s, got %q", text, tc.query, got)
+		}
+	}
+
+	// Latin-1 letters are encoded as Latin-1 bytes and read back as such
+	resetRateLimiter()
+	req := httptest.NewRequest("GET", "/qr?type=aztec&text="+url.QueryEscape("Zürich"), nil)
+	rr := httptest.NewRecorder()
+	qrHandler(rr, req)
+	if got := decodeMatrix(t, aztec.NewAztecReader(), rr.Body.Bytes()); got != "Zürich" {
+		t.Fatalf("expected %q, got %q", "Zürich", got)
+	}
+}
+
+func TestEncodeDataMatrix_Shape(t *testing.T) {
+	for rectangular, want := range map[bool][2]int{false: {10, 10}, true: {18, 8}} {
+		modules, err := encodeDataMatrix("1234", symbologyOptions{rectangular: rectangular})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(modules[0]) != want[0] || len(modules) != want[1] {
+			t.Fatalf("expected a %dx%d symbol for rectangular=%v, got %dx%d",
+				want[0], want[1], rectangular, len(modules[0]), len(modules))
+		}
+	}
+}
+
+func TestQRHandler_MatrixSymbologies_Cache(t *testing.T) {
+	isolateRateLimiter(t)
+
+	// The Data Matrix quiet zone defaults to one module
+	req := httptest.NewRequest("GET", "/qr?format=svg&type=datamatrix&text=1234", nil)
+	rr := httptest.NewRecorder()
+	qrHandler(rr, req)
+	if doc := parseSVG(t, rr.Body.Bytes()); doc.ViewBox != "0 0 12 12" {
+		t.Fatalf("expected a 12-module viewBox, got %q", doc.ViewBox)
+	}
+
+	// Options are part of the cache key
+	var bodies [2][]byte
+	for i, query := range []string{"", "&security_level=5"} {
+		resetRateLimiter()
+
+		req := httptest.NewRequest("GET", "/qr?type=pdf417&text=cachekey"+query, nil)
+		rr := httptest.NewRecorder()
+		qrHandler(rr, req)
+		bodies[i] = rr.Body.Bytes()
+	}
+	if bytes.Equal(bodies[0], bodies[1]) {
+		t.Fatal("different security levels should not have same cache entry")
+	}
+}
+
+func TestQRHandler_MatrixSymbologies_Invalid(t *testing.T) {
+	isolateRateLimiter(t)
+
+	cases := map[string]string{
+		"type=datamatrix&text=%E2%82%AC":                                    "Data Matrix codes can only encode Latin-1 characters",
+		"type=datamatrix&rectangular=true&text=" + strings.Repeat("x", 100): "Text is too long for a rectangular Data Matrix code",
+		"type=datamatrix&rectangular=maybe&text=1234":                       "rectangular must be 'true' or 'false'",
+		"type=datamatrix&columns=4&text=1234":                               "The 'datamatrix' symbology does not support the 'columns' option",
+		"type=aztec&text=%E2%82%AC":                                         "Aztec codes can only encode Latin-1 characters",
+		"type=pdf417&text=%E2%82%AC":                                        "PDF417 codes can only encode Latin-1 characters",
+		"type=aztec&min_ecc=2&text=1234":                                    "min_ecc must be a whole number between 5 and 95",
+		"type=aztec&text=" + strings.Repeat("x", 4000):                      "Text is too long for an Aztec code with 23% error correction",
+		"type=pdf417&security_level=9&text=1234":                            "security_level must be a whole number between 0 and 8",
+		"type=pdf417&columns=0&text=1234":                                   "columns must be a whole number between 1 and 30",
+		"type=pdf417&columns=1&text=" + strings.Repeat("x", 200):            "Text is too long for a PDF417 code with 1 columns at security level 2: it needs 177 rows, but at most 90 fit",
+		"type=pdf417&rectangular=true&text=1234":                            "The 'pdf417' symbology does not support the 'rectangular' option",
+		"type=maxicode&text=1234":                                           "Type must be 'qr' or 'barcode', one of the 2D symbologies 'aztec', 'datamatrix', 'pdf417'",
+	}
+	for query, msg := range cases {
+		resetRateLimiter() // Reset rate limiter before each request
+
+		req := httptest.NewRequest("GET", "/qr?"+query, nil)
+		rr := httptest.NewRecorder()
+		qrHandler(rr, req)
+
+		if rr.Code != http.StatusBadRequest {
+			t.Fatalf("expected status 400 for %s, got %d", query, rr.Code)
+		}
+		if !strings.Contains(rr.Body.String(), msg) {
+			t.Fatalf("expected error %q for %s, got %s", msg, query, rr.Body.String())
+		}
+	}
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base32"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Length of generated secrets in bytes: 160 bits, as RFC 4226 recommends
+const otpSecretBytes = 20
+
+// Shortest accepted secret in bytes: the 80 bits many issuers still use
+const minOTPSecretBytes = 10
+
+// Base32 without padding, as otpauth:// URIs write secrets
+var otpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// HMAC algorithms authenticator apps support
+var otpAlgorithms = map[string]bool{"SHA1": true, "SHA256": true, "SHA512": true}
+
+// otpPayload builds an otpauth:// key URI, as read by authenticator apps,
+// from the otp, issuer, account, secret, algorithm, digits, period and
+// counter parameters. Without a secret, a random one is generated and
+// returned along with the URI.
+func otpPayload(q url.Values) (uri, generated string, err error) {
+	kind := q.Get("otp")
+	if kind == "" {
+		kind = "totp" // default type
+	}
+	if kind != "totp" && kind != "hotp" {
+		return "", "", fmt.Errorf("otp must be 'totp' or 'hotp'")
+	}
+
+	// The label is "issuer:account", so neither may hold a colon
+	issuer, account := q.Get("issuer"), q.Get("account")
+	if account == "" {
+		return "", "", fmt.Errorf("Please provide an 'account' parameter")
+	}
+	if strings.Contains(issuer, ":") || strings.Contains(account, ":") {
+		return "", "", fmt.Errorf("The issuer and account can not contain a colon")
+	}
+
+	secret := strings.ToUpper(strings.TrimRight(strings.ReplaceAll(q.Get("secret"), " ", ""), "="))
+	if secret == "" {
+		key := make([]byte, otpSecretBytes)
+		if _, err := rand.Read(key); err != nil {
+			return "", "", fmt.Errorf("Failed to generate a secret")
+		}
+		secret = otpEncoding.EncodeToString(key)
+		generated = secret
+	} else if key, err := otpEncoding.DecodeString(secret); err != nil {
+		return "", "", fmt.Errorf("Secret must be base32 encoded (A-Z and 2-7)")
+	} else if len(key) < minOTPSecretBytes {
+		return "", "", fmt.Errorf("Secret must be at least %d bits (%d base32 characters), got %d bits",
+			minOTPSecretBytes*8, minOTPSecretBytes*8/5, len(key)*8)
+	}
+
+	// Spaces are written as %20, as not every app reads "+" as a space
+	params := []string{"secret=" + secret}
+	if issuer != "" {
+		params = append(params, "issuer="+strings.ReplaceAll(url.QueryEscape(issuer), "+", "%20"))
+	}
+
+	// Optional parameters are only written when they differ from the
+	// defaults, which some apps do not support changing
+	if s := strings.ToUpper(q.Get("algorithm")); s != "" {
+		if !otpAlgorithms[s] {
+			return "", "", fmt.Errorf("Algorithm must be 'SHA1', 'SHA256' or 'SHA512'")
+		}
+		if s != "SHA1" {
+			params = append(params, "algorithm="+s)
+		}
+	}
+	if s := q.Get("digits"); s != "" {
+		if s != "6" && s != "8" {
+			return "", "", fmt.Errorf("Digits must be 6 or 8")
+		}
+		if s != "6" {
+			params = append(params, "digits="+s)
+		}
+	}
+	if s := q.Get("period"); s != "" {
+		if kind != "totp" {
+			return "", "", fmt.Errorf("period is only used with otp=totp")
+		}
+		period, err := strconv.Atoi(s)
+		if err != nil || period < 10 || period > 300 {
+			return "", "", fmt.Errorf("Period must be between 10 and 300 seconds")
+		}
+		if period != 30 {
+			params = append(params, "period="+s)
+		}
+	}
+	counter := q.Get("counter")
+	if kind == "hotp" {
+		// The initial counter is required for HOTP
+		if counter == "" {
+			counter = "0"
+		}
+		if _, err := strconv.ParseUint(counter, 10, 64); err != nil {
+			return "", "", fmt.Errorf("Counter must be a whole number of at least 0")
+		}
+		params = append(params, "counter="+counter)
+	} else if counter != "" {
+		return "", "", fmt.Errorf("counter is only used with otp=hotp")
+	}
+
+	label := url.PathEscape(account)
+	if issuer != "" {
+		label = url.PathEscape(issuer) + ":" + label
+	}
+	return "otpauth://" + kind + "/" + label + "?" + strings.Join(params, "&"), generated, nil
+}
+
+// otpHandler draws the otpauth:// QR code that enrolls an account in an
+// authenticator app. A generated secret is returned in the X-OTP-Secret
+// header. As the code carries the secret, it is never cached, neither here
+// nor along the way.
+func otpHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Pragma", "no-cache")
+
+	// Check rate limit per IP
+	if !ipRateLimiter.Allow(getIP(r)) {
+		http.Error(w, "Rate limit exceeded", http.StatusTooManyRequests)
+		return
+	}
+
+	// A multipart POST adds a logo, as for /qr
+	q, ok := parseLogoRequest(w, r)
+	if !ok {
+		return
+	}
+	if err := checkPayloadParams(q); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	text, generated, err := otpPayload(q)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if generated != "" {
+		w.Header().Set("X-OTP-Secret", generated)
+	}
+	q.Set("text", text)
+	serveQR(w, r, q, false)
+}
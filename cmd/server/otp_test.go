@@ -0,0 +1,136 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	zxqrcode "github.com/makiuchi-d/gozxing/qrcode"
+)
+
+func TestOTPPayload(t *testing.T) {
+	cases := []struct {
+		query url.Values
+		want  string
+	}{
+		{
+			url.Values{"issuer": {"Acme Co"}, "account": {"jane@example.com"}, "secret": {"jbsw y3dp ehpk 3pxp"}},
+			"otpauth://totp/Acme%20Co:jane@example.com?secret=JBSWY3DPEHPK3PXP&issuer=Acme%20Co",
+		},
+		{
+			url.Values{"account": {"jane"}, "secret": {"JBSWY3DPEHPK3PXP"}, "algorithm": {"sha256"}, "digits": {"8"}, "period": {"60"}},
+			"otpauth://totp/jane?secret=JBSWY3DPEHPK3PXP&algorithm=SHA256&digits=8&period=60",
+		},
+		{
+			// Defaults are left out
+			url.Values{"account": {"jane"}, "secret": {"JBSWY3DPEHPK3PXP"}, "algorithm": {"SHA1"}, "digits": {"6"}, "period": {"30"}},
+			"otpauth://totp/jane?secret=JBSWY3DPEHPK3PXP",
+		},
+		{
+			url.Values{"otp": {"hotp"}, "issuer": {"Acme"}, "account": {"jane"}, "secret": {"JBSWY3DPEHPK3PXP"}},
+			"otpauth://hotp/Acme:jane?secret=JBSWY3DPEHPK3PXP&issuer=Acme&counter=0",
+		},
+	}
+	for _, tc := range cases {
+		got, generated, err := otpPayload(tc.query)
+		if err != nil {
+			t.Fatalf("unexpected error for %v: %v", tc.query, err)
+		}
+		if got != tc.want || generated != "" {
+			t.Fatalf("expected %q for %v, got %q (generated %q)", tc.want, tc.query, got, generated)
+		}
+	}
+
+	// Generated secrets are 160 random bits
+	uri1, secret1, err := otpPayload(url.Values{"account": {"jane"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, secret2, _ := otpPayload(url.Values{"account": {"jane"}})
+	if len(secret1) != 32 || secret1 == secret2 {
+		t.Fatalf("expected distinct 32-character secrets, got %q and %q", secret1, secret2)
+	}
+	if !strings.Contains(uri1, "secret="+secret1) {
+		t.Fatalf("expected the generated secret in %q", uri1)
+	}
+}
+
+func TestOTPHandler_NotCached(t *testing.T) {
+	isolateRateLimiter(t)
+
+	for _, query := range []string{"issuer=Acme&account=jane", "issuer=Acme&account=jane&secret=JBSWY3DPEHPK3PXP"} {
+		resetRateLimiter() // Reset rate limiter before each request
+
+		req := httptest.NewRequest("GET", "/qr/otp?"+query, nil)
+		rr := httptest.NewRecorder()
+		otpHandler(rr, req)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected status 200 for %s, got %d: %s", query, rr.Code, rr.Body.String())
+		}
+		if cc := rr.Header().Get("Cache-Control"); cc != "no-store" {
+			t.Fatalf("expected Cache-Control no-store for %s, got %q", query, cc)
+		}
+
+		// The secret is returned only when generated
+		text := decodeMatrix(t, zxqrcode.NewQRCodeReader(), rr.Body.Bytes())
+		secret := rr.Header().Get("X-OTP-Secret")
+		if strings.Contains(query, "secret=") {
+			if secret != "" {
+				t.Fatalf("expected no X-OTP-Secret for a given secret, got %q", secret)
+			}
+			secret = "JBSWY3DPEHPK3PXP"
+		}
+		if want := "otpauth://totp/Acme:jane?secret=" + secret + "&issuer=Acme"; secret == "" || text != want {
+			t.Fatalf("expected %q, got %q", want, text)
+		}
+
+		// Nothing about the code is kept
+		qrCacheMutex.RLock()
+		for key := range qrCache {
+			if strings.Contains(key, secret) {
+				qrCacheMutex.RUnlock()
+				t.Fatalf("expected the secret not to be cached, found %q", key)
+			}
+		}
+		qrCacheMutex.RUnlock()
+	}
+}
+
+func TestOTPHandler_Invalid(t *testing.T) {
+	isolateRateLimiter(t)
+
+	cases := map[string]string{
+		"issuer=Acme":                                 "Please provide an 'account' parameter",
+		"account=jane&otp=motp":                       "otp must be 'totp' or 'hotp'",
+		"account=a:b":                                 "The issuer and account can not contain a colon",
+		"account=jane&secret=JBSWY3DP18":              "Secret must be base32 encoded",
+		"account=jane&secret=JBSWY3DP":                "Secret must be at least 80 bits (16 base32 characters), got 40 bits",
+		"account=jane&algorithm=MD5":                  "Algorithm must be 'SHA1', 'SHA256' or 'SHA512'",
+		"account=jane&digits=7":                       "Digits must be 6 or 8",
+		"account=jane&period=5":                       "Period must be between 10 and 300 seconds",
+		"account=jane&otp=hotp&period=30":             "period is only used with otp=totp",
+		"account=jane&otp=hotp&counter=-1":            "Counter must be a whole number of at least 0",
+		"account=jane&counter=1":                      "counter is only used with otp=hotp",
+		"account=jane&text=otpauth://":                "remove the 'text' parameter",
+		"account=jane&secret=JBSWY3DPEHPK3PXP&size=1": "Size must be between 50 and 1000 pixels",
+	}
+	for query, msg := range cases {
+		resetRateLimiter() // Reset rate limiter before each request
+
+		req := httptest.NewRequest("GET", "/qr/otp?"+query, nil)
+		rr := httptest.NewRecorder()
+		otpHandler(rr, req)
+
+		if rr.Code != http.StatusBadRequest {
+			t.Fatalf("expected status 400 for %s, got %d", query, rr.Code)
+		}
+		if !strings.Contains(rr.Body.String(), msg) {
+			t.Fatalf("expected error %q for %s, got %s", msg, query, rr.Body.String())
+		}
+		if cc := rr.Header().Get("Cache-Control"); cc != "no-store" {
+			t.Fatalf("expected Cache-Control no-store for %s, got %q", query, cc)
+		}
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// payloadHandler serves an endpoint that builds the text of a QR code from
+// structured parameters, so that callers need not know its syntax or
+// escaping. The code is drawn like /qr, and takes all of its parameters
+// except text; a multipart POST adds a logo as it does for /qr. Payloads
+// whose specification mandates an error-correction level pass it as ecc.
+func payloadHandler(build func(q url.Values) (string, error), ecc string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Check rate limit per IP
+		if !ipRateLimiter.Allow(getIP(r)) {
+			http.Error(w, "Rate limit exceeded", http.StatusTooManyRequests)
+			return
+		}
+
+		q, ok := parseLogoRequest(w, r)
+		if !ok {
+			return
+		}
+		if err := checkPayloadParams(q); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if ecc != "" {
+			// A logo would need level H
+			if r.Method == http.MethodPost {
+				http.Error(w, fmt.Sprintf("These QR codes must use error-correction level %s, which leaves no room for a logo", ecc), http.StatusBadRequest)
+				return
+			}
+			if e := q.Get("ecc"); e != "" && e != ecc {
+				http.Error(w, fmt.Sprintf("These QR codes must use error-correction level %s", ecc), http.StatusBadRequest)
+				return
+			}
+			q.Set("ecc", ecc)
+		}
+
+		text, err := build(q)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		q.Set("text", text)
+		serveQR(w, r, q, true)
+	}
+}
+
+// checkPayloadParams rejects the /qr parameters a payload endpoint decides
+// itself: the text, and the type, as payloads are read from QR codes.
+func checkPayloadParams(q url.Values) error {
+	if q.Get("text") != "" {
+		return fmt.Errorf("The text is built from the other parameters; remove the 'text' parameter")
+	}
+	if t := q.Get("type"); t != "" && t != "qr" {
+		return fmt.Errorf("Type must be 'qr'")
+	}
+	return nil
+}
+
+// Wi-Fi authentication types, keyed by their lower-case name
+var wifiAuthTypes = map[string]string{
+	"wpa":    "WPA",
+	"wep":    "WEP",
+	"sae":    "SAE", // WPA3
+	"nopass": "nopass",
+}
+
+// escapeMeCard escapes the characters with a meaning in MeCard-style
+// payloads, such as Wi-Fi networks and MeCard contacts, with a backslash.
+func escapeMeCard(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `;`, `\;`, `,`, `\,`, `:`, `\:`, `"`, `\"`).Replace(s)
+}
+
+// isHex reports whether s consists of hexadecimal digits only.
+func isHex(s string) bool {
+	for _, r := range s {
+		if !strings.ContainsRune("0123456789abcdefABCDEF", r) {
+			return false
+		}
+	}
+	return true
+}
+
+// wifiPayload builds a "WIFI:T:WPA;S:ssid;P:password;;" network
+// configuration from the ssid, password, auth and hidden parameters. The
+// auth type defaults to WPA with a password and nopass without one.
+func wifiPayload(q url.Values) (string, error) {
+	ssid, password := q.Get("ssid"), q.Get("password")
+	if ssid == "" {
+		return "", fmt.Errorf("Please provide an 'ssid' parameter")
+	}
+	if len(ssid) > 32 {
+		return "", fmt.Errorf("SSID can be at most 32 bytes long, got %d", len(ssid))
+	}
+
+	auth := "nopass"
+	if password != "" {
+		auth = "WPA"
+	}
+	if s := q.Get("auth"); s != "" {
+		var ok bool
+		if auth, ok = wifiAuthTypes[strings.ToLower(s)]; !ok {
+			return "", fmt.Errorf("Auth must be 'WPA', 'WEP', 'SAE' or 'nopass'")
+		}
+	}
+
+	// Check the password against what the authentication type accepts
+	switch auth {
+	case "nopass":
+		if password != "" {
+			return "", fmt.Errorf("Open networks (auth=nopass) take no password")
+		}
+	case "WEP":
+		n := len(password)
+		if n != 5 && n != 13 && !((n == 10 || n == 26) && isHex(password)) {
+			return "", fmt.Errorf("WEP keys must be 5 or 13 characters, or 10 or 26 hex digits")
+		}
+	default:
+		n := len(password)
+		if (n < 8 || n > 63) && !(n == 64 && isHex(password)) {
+			return "", fmt.Errorf("%s passwords must be 8 to 63 characters, or 64 hex digits", auth)
+		}
+	}
+
+	hidden := q.Get("hidden")
+	if hidden != "" && hidden != "true" && hidden != "false" {
+		return "", fmt.Errorf("Hidden must be 'true' or 'false'")
+	}
+
+	payload := "WIFI:T:" + auth + ";S:" + escapeMeCard(ssid) + ";"
+	if auth != "nopass" {
+		payload += "P:" + escapeMeCard(password) + ";"
+	}
+	if hidden == "true" {
+		payload += "H:true;"
+	}
+	return payload + ";", nil
+}
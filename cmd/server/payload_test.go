@@ -0,0 +1,142 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	zxqrcode "github.com/makiuchi-d/gozxing/qrcode"
+)
+
+// parseWiFi splits a Wi-Fi QR code payload into its fields, undoing the
+// escaping the way scanners read it.
+func parseWiFi(t *testing.T, payload string) map[string]string {
+	t.Helper()
+	if !strings.HasPrefix(payload, "WIFI:") || !strings.HasSuffix(payload, ";;") {
+		t.Fatalf("not a Wi-Fi payload: %q", payload)
+	}
+	fields := map[string]string{}
+	var field strings.Builder
+	body := payload[len("WIFI:") : len(payload)-1]
+	for i := 0; i < len(body); i++ {
+		switch c := body[i]; c {
+		case '\\':
+			i++
+			field.WriteByte(body[i])
+		case ';':
+			key, value, ok := strings.Cut(field.String(), "\x00")
+			if !ok {
+				t.Fatalf("field without a key in %q", payload)
+			}
+			fields[key] = value
+			field.Reset()
+		case ':':
+			if !strings.Contains(field.String(), "\x00") {
+				field.WriteByte(0)
+				continue
+			}
+			field.WriteByte(c)
+		default:
+			field.WriteByte(c)
+		}
+	}
+	return fields
+}
+
+func TestWiFiPayload(t *testing.T) {
+	cases := []struct {
+		query url.Values
+		want  string
+	}{
+		{url.Values{"ssid": {"Office"}, "password": {"hunter22"}}, "WIFI:T:WPA;S:Office;P:hunter22;;"},
+		{url.Values{"ssid": {"Guest"}}, "WIFI:T:nopass;S:Guest;;"},
+		{url.Values{"ssid": {"Lab"}, "password": {"abcde"}, "auth": {"wep"}, "hidden": {"true"}}, "WIFI:T:WEP;S:Lab;P:abcde;H:true;;"},
+		{url.Values{"ssid": {`a;b,c:d\e"f`}, "password": {`p;a,s:s\w"d`}}, `WIFI:T:WPA;S:a\;b\,c\:d\\e\"f;P:p\;a\,s\:s\\w\"d;;`},
+	}
+	for _, tc := range cases {
+		got, err := wifiPayload(tc.query)
+		if err != nil {
+			t.Fatalf("unexpected error for %v: %v", tc.query, err)
+		}
+		if got != tc.want {
+			t.Fatalf("expected %q for %v, got %q", tc.want, tc.query, got)
+		}
+	}
+}
+
+func TestWiFiHandler_RoundTrip(t *testing.T) {
+	isolateRateLimiter(t)
+
+	cases := []struct{ ssid, password, auth, hidden string }{
+		{"Office", "correct horse battery", "WPA", "false"},
+		{`Café "Le Coin"; 2,4:GHz\`, `pa;ss,wo:rd\"`, "SAE", "true"},
+		{"Open Guest", "", "nopass", "false"},
+	}
+	for _, tc := range cases {
+		resetRateLimiter() // Reset rate limiter before each request
+
+		q := url.Values{"ssid": {tc.ssid}, "password": {tc.password}, "auth": {tc.auth}, "hidden": {tc.hidden}}
+		req := httptest.NewRequest("GET", "/qr/wifi?"+q.Encode(), nil)
+		rr := httptest.NewRecorder()
+		payloadHandler(wifiPayload, "")(rr, req)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected status 200 for %q, got %d: %s", tc.ssid, rr.Code, rr.Body.String())
+		}
+
+		fields := parseWiFi(t, decodeMatrix(t, zxqrcode.NewQRCodeReader(), rr.Body.Bytes()))
+		if fields["S"] != tc.ssid || fields["P"] != tc.password || fields["T"] != tc.auth {
+			t.Fatalf("expected %q/%q/%s back, got %v", tc.ssid, tc.password, tc.auth, fields)
+		}
+		if hidden := fields["H"] == "true"; hidden != (tc.hidden == "true") {
+			t.Fatalf("expected hidden=%s, got %v", tc.hidden, fields)
+		}
+	}
+}
+
+func TestWiFiHandler_Render(t *testing.T) {
+	isolateRateLimiter(t)
+
+	// Rendering parameters work as for /qr
+	req := httptest.NewRequest("GET", "/qr/wifi?ssid=Office&password=hunter22&format=svg&ecc=H", nil)
+	rr := httptest.NewRecorder()
+	payloadHandler(wifiPayload, "")(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if ct := rr.Header().Get("Content-Type"); ct != "image/svg+xml" {
+		t.Fatalf("expected Content-Type image/svg+xml, got %s", ct)
+	}
+}
+
+func TestWiFiHandler_Invalid(t *testing.T) {
+	isolateRateLimiter(t)
+
+	cases := map[string]string{
+		"":                                     "Please provide an 'ssid' parameter",
+		"ssid=" + strings.Repeat("x", 33):      "SSID can be at most 32 bytes long, got 33",
+		"ssid=Office&password=short":           "WPA passwords must be 8 to 63 characters, or 64 hex digits",
+		"ssid=Office&auth=wpa2":                "Auth must be 'WPA', 'WEP', 'SAE' or 'nopass'",
+		"ssid=Office&auth=wep&password=abcdef": "WEP keys must be 5 or 13 characters, or 10 or 26 hex digits",
+		"ssid=Office&auth=nopass&password=x":   "Open networks (auth=nopass) take no password",
+		"ssid=Office&hidden=yes":               "Hidden must be 'true' or 'false'",
+		"ssid=Office&text=WIFI:":               "remove the 'text' parameter",
+		"ssid=Office&type=datamatrix":          "Type must be 'qr'",
+		"ssid=Office&size=10":                  "Size must be between 50 and 1000 pixels",
+	}
+	for query, msg := range cases {
+		resetRateLimiter() // Reset rate limiter before each request
+
+		req := httptest.NewRequest("GET", "/qr/wifi?"+query, nil)
+		rr := httptest.NewRecorder()
+		payloadHandler(wifiPayload, "")(rr, req)
+
+		if rr.Code != http.StatusBadRequest {
+			t.Fatalf("expected status 400 for %s, got %d", query, rr.Code)
+		}
+		if !strings.Contains(rr.Body.String(), msg) {
+			t.Fatalf("expected error %q for %s, got %s", msg, query, rr.Body.String())
+		}
+	}
+}
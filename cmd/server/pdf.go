@@ -0,0 +1,247 @@
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Points per millimetre; PDF user space is measured in 1/72 inch
+const pointsPerMM = 72 / 25.4
+
+// pdfWriter assembles a PDF file from numbered objects and writes it with a
+// matching cross-reference table.
+type pdfWriter struct {
+	objects [][]byte
+}
+
+// addObject stores an object body and returns its object number.
+func (p *pdfWriter) addObject(body string) int {
+	p.objects = append(p.objects, []byte(body))
+	return len(p.objects)
+}
+
+// addStream stores a stream object with the given extra dictionary entries.
+func (p *pdfWriter) addStream(dict string, data []byte) int {
+	var body bytes.Buffer
+	fmt.Fprintf(&body, "<< /Length %d%s >>\nstream\n", len(data), dict)
+	body.Write(data)
+	body.WriteString("\nendstream")
+	p.objects = append(p.objects, body.Bytes())
+	return len(p.objects)
+}
+
+// setObject replaces the body of object n.
+func (p *pdfWriter) setObject(n int, body string) {
+	p.objects[n-1] = []byte(body)
+}
+
+// addImage stores img as a compressed RGB image XObject, with a soft mask
+// carrying its alpha channel unless it is fully opaque, and returns the
+// image's object number.
+func (p *pdfWriter) addImage(img *image.NRGBA) (int, error) {
+	b := img.Bounds()
+	rgb := make([]byte, 0, 3*b.Dx()*b.Dy())
+	alpha := make([]byte, 0, b.Dx()*b.Dy())
+	opaque := true
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			c := img.NRGBAAt(x, y)
+			rgb = append(rgb, c.R, c.G, c.B)
+			alpha = append(alpha, c.A)
+			opaque = opaque && c.A == 0xff
+		}
+	}
+
+	dict := fmt.Sprintf(" /Type /XObject /Subtype /Image /Width %d /Height %d /BitsPerComponent 8 /Filter /FlateDecode", b.Dx(), b.Dy())
+	extra := ""
+	if !opaque {
+		data, err := deflate(alpha)
+		if err != nil {
+			return 0, err
+		}
+		mask := p.addStream(dict+" /ColorSpace /DeviceGray", data)
+		extra = fmt.Sprintf(" /SMask %d 0 R", mask)
+	}
+	data, err := deflate(rgb)
+	if err != nil {
+		return 0, err
+	}
+	return p.addStream(dict+" /ColorSpace /DeviceRGB"+extra, data), nil
+}
+
+// deflate compresses data for a /FlateDecode stream.
+func deflate(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	zw := zlib.NewWriter(&buf)
+	if _, err := zw.Write(data); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// writeTo writes the complete document with the given catalog as root.
+func (p *pdfWriter) writeTo(w io.Writer, root int) error {
+	var buf bytes.Buffer
+	buf.WriteString("%PDF-1.4\n%\xe2\xe3\xcf\xd3\n")
+
+	offsets := make([]int, len(p.objects))
+	for i, obj := range p.objects {
+		offsets[i] = buf.Len()
+		fmt.Fprintf(&buf, "%d 0 obj\n", i+1)
+		buf.Write(obj)
+		buf.WriteString("\nendobj\n")
+	}
+
+	xref := buf.Len()
+	fmt.Fprintf(&buf, "xref\n0 %d\n0000000000 65535 f \n", len(p.objects)+1)
+	for _, off := range offsets {
+		fmt.Fprintf(&buf, "%010d 00000 n \n", off)
+	}
+	fmt.Fprintf(&buf, "trailer\n<< /Size %d /Root %d 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(p.objects)+1, root, xref)
+
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// pdfNumber formats a coordinate with at most three decimals.
+func pdfNumber(v float64) string {
+	s := strconv.FormatFloat(v, 'f', 3, 64)
+	s = strings.TrimRight(s, "0")
+	s = strings.TrimSuffix(s, ".")
+	if s == "-0" {
+		s = "0"
+	}
+	return s
+}
+
+// pdfColor formats c as PDF color operands.
+func pdfColor(c color.RGBA) string {
+	return fmt.Sprintf("%s %s %s", pdfNumber(float64(c.R)/255), pdfNumber(float64(c.G)/255), pdfNumber(float64(c.B)/255))
+}
+
+// writePDF renders a module grid as a single-page vector PDF whose page is
+// exactly opts.widthMM x opts.heightMM. As with writeSVG, 2D codes keep
+// square modules and are centered, while 1D codes fill the whole page,
+// which grows to fit their caption if any.
+func writePDF(w io.Writer, modules [][]bool, opts renderOptions) error {
+	rows := len(modules)
+	cols := 0
+	if rows > 0 {
+		cols = len(modules[0])
+	}
+	if rows == 0 || cols == 0 {
+		return fmt.Errorf("empty module grid")
+	}
+
+	pageW := opts.widthMM * pointsPerMM
+	pageH := opts.heightMM * pointsPerMM
+
+	// Map module units onto the page, flipping the y axis so rows run top-down
+	var scaleX, scaleY, offsetX, offsetY float64
+	var captionH float64
+	if rows == 1 {
+		scaleX = pageW / float64(cols)
+		scaleY = pageH
+		if opts.caption != nil {
+			captionH = opts.caption.height * scaleX
+			pageH += captionH
+		}
+	} else {
+		scaleX = pageW / float64(cols)
+		if s := pageH / float64(rows); s < scaleX {
+			scaleX = s
+		}
+		scaleY = scaleX
+		offsetX = (pageW - float64(cols)*scaleX) / 2
+		offsetY = (pageH - float64(rows)*scaleY) / 2
+	}
+
+	var content bytes.Buffer
+	if opts.bg.A != 0 {
+		fmt.Fprintf(&content, "%s rg\n0 0 %s %s re f\n", pdfColor(opts.bg), pdfNumber(pageW), pdfNumber(pageH))
+	}
+
+	// Draw the caption in the band below the bars, in module units
+	if c := opts.caption; c != nil {
+		fmt.Fprintf(&content, "q\n%s 0 0 %s 0 %s cm\n%s rg\n",
+			pdfNumber(scaleX), pdfNumber(-scaleX), pdfNumber(captionH), pdfColor(opts.fg))
+		for _, r := range c.rects {
+			fmt.Fprintf(&content, "%s %s %s %s re\n", pdfNumber(r.x), pdfNumber(r.y), pdfNumber(r.w), pdfNumber(r.h))
+		}
+		content.WriteString("f\nQ\n")
+	}
+	fmt.Fprintf(&content, "%s 0 0 %s %s %s cm\n",
+		pdfNumber(scaleX), pdfNumber(-scaleY), pdfNumber(offsetX), pdfNumber(pageH-offsetY))
+	if opts.gradient == nil {
+		fmt.Fprintf(&content, "%s rg\n", pdfColor(opts.fg))
+	} else {
+		content.WriteString("q\n")
+	}
+
+	// Draw each horizontal run of dark modules as one rectangle
+	darkRuns(modules, func(y, start, end int) {
+		fmt.Fprintf(&content, "%d %d %d 1 re\n", start, y, end-start)
+	})
+	if opts.gradient == nil {
+		content.WriteString("f\n")
+	} else {
+		// Clip to the modules and paint an axial shading across them
+		content.WriteString("W n\n/Sh0 sh\nQ\n")
+	}
+
+	// Draw the Swiss cross over the modules
+	if c := opts.cross; c != nil {
+		for _, l := range c.layers() {
+			fill := opts.bg
+			if l.dark {
+				fill = opts.fg
+			}
+			fmt.Fprintf(&content, "%s rg\n%s %s %s %s re\nf\n",
+				pdfColor(fill), pdfNumber(l.x), pdfNumber(l.y), pdfNumber(l.w), pdfNumber(l.h))
+		}
+	}
+
+	// Composite the logo on its plate, flipping the image upright
+	if l := opts.logo; l != nil {
+		if l.bg.A != 0 {
+			fmt.Fprintf(&content, "%s rg\n%d %d %d %d re\nf\n",
+				pdfColor(l.bg), l.plate.Min.X, l.plate.Min.Y, l.plate.Dx(), l.plate.Dy())
+		}
+		fmt.Fprintf(&content, "q\n%s 0 0 %s %s %s cm\n/Im0 Do\nQ\n",
+			pdfNumber(l.w), pdfNumber(-l.h), pdfNumber(l.x), pdfNumber(l.y+l.h))
+	}
+
+	var doc pdfWriter
+	catalog := doc.addObject("<< /Type /Catalog /Pages 2 0 R >>")
+	doc.addObject("<< /Type /Pages /Kids [3 0 R] /Count 1 >>")
+	page := doc.addObject("") // Written once its resources are known
+	contents := doc.addStream("", content.Bytes())
+
+	var resources []string
+	if opts.gradient != nil {
+		bounds := darkBounds(modules)
+		shading := doc.addObject(fmt.Sprintf("<< /ShadingType 2 /ColorSpace /DeviceRGB /Coords [%d 0 %d 0] /Extend [true true] "+
+			"/Function << /FunctionType 2 /Domain [0 1] /C0 [%s] /C1 [%s] /N 1 >> >>",
+			bounds.Min.X, bounds.Max.X, pdfColor(opts.gradient[0]), pdfColor(opts.gradient[1])))
+		resources = append(resources, fmt.Sprintf("/Shading << /Sh0 %d 0 R >>", shading))
+	}
+	if opts.logo != nil {
+		logo, err := doc.addImage(opts.logo.img)
+		if err != nil {
+			return err
+		}
+		resources = append(resources, fmt.Sprintf("/XObject << /Im0 %d 0 R >>", logo))
+	}
+	doc.setObject(page, fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %s %s] /Contents %d 0 R /Resources << %s>> >>",
+		pdfNumber(pageW), pdfNumber(pageH), contents, strings.Join(append(resources, ""), " ")))
+	return doc.writeTo(w, catalog)
+}
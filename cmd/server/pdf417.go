@@ -0,0 +1,176 @@
+package main
+
+import (
+	"fmt"
+	"math"
+)
+
+// PDF417 start and stop patterns; the stop pattern is 18 modules wide
+const (
+	pdf417Start = 0x1fea8
+	pdf417Stop  = 0x3fa29
+)
+
+// Height of a PDF417 row in modules, the minimum the specification
+// recommends
+const pdf417RowHeight = 3
+
+// Most rows, columns and codewords a PDF417 symbol may have
+const (
+	pdf417MaxRows      = 90
+	pdf417MaxColumns   = 30
+	pdf417MaxCodewords = 928
+)
+
+// pdf417ByteCompaction encodes data in byte compaction mode: every six bytes
+// become five base-900 codewords, and any bytes left over one codeword each.
+// It handles any text, at some cost in density for text and digits.
+func pdf417ByteCompaction(data []byte) []int {
+	// Latch 924 announces that the bytes come in whole groups of six
+	latch := 901
+	if len(data)%6 == 0 {
+		latch = 924
+	}
+	codewords := []int{latch}
+	for ; len(data) >= 6; data = data[6:] {
+		var v uint64
+		for _, b := range data[:6] {
+			v = v<<8 | uint64(b)
+		}
+		var group [5]int
+		for i := 4; i >= 0; i-- {
+			group[i] = int(v % 900)
+			v /= 900
+		}
+		codewords = append(codewords, group[:]...)
+	}
+	for _, b := range data {
+		codewords = append(codewords, int(b))
+	}
+	return codewords
+}
+
+// pdf417Generator returns the coefficients of the Reed-Solomon generator
+// polynomial (x - 3)(x - 3²)...(x - 3^k) over GF(929), lowest order first and
+// without the leading 1.
+func pdf417Generator(k int) []int {
+	g := []int{1}
+	root := 1
+	for i := 0; i < k; i++ {
+		root = root * 3 % 929
+		next := make([]int, len(g)+1)
+		for j, c := range g {
+			next[j+1] = (next[j+1] + c) % 929
+			next[j] = (next[j] + 929 - c*root%929) % 929
+		}
+		g = next
+	}
+	return g[:k]
+}
+
+// pdf417ErrorCorrection returns the k error-correction codewords for data.
+func pdf417ErrorCorrection(data []int, k int) []int {
+	a := pdf417Generator(k)
+	e := make([]int, k)
+	for _, d := range data {
+		t := (d + e[k-1]) % 929
+		for j := k - 1; j > 0; j-- {
+			e[j] = (e[j-1] + 929 - t*a[j]%929) % 929
+		}
+		e[0] = (929 - t*a[0]%929) % 929
+	}
+	ec := make([]int, k)
+	for j, c := range e {
+		if c != 0 {
+			c = 929 - c
+		}
+		ec[k-1-j] = c
+	}
+	return ec
+}
+
+// pdf417Columns picks the number of data columns for a symbol of the given
+// number of codewords, bringing it closest to three times as wide as high.
+func pdf417Columns(codewords int) int {
+	best, bestDiff := 0, math.Inf(1)
+	for c := 1; c <= pdf417MaxColumns; c++ {
+		rows := max(3, (codewords+c-1)/c)
+		if rows > pdf417MaxRows || rows*c > pdf417MaxCodewords {
+			continue
+		}
+		ratio := float64(17*c+69) / float64(rows*pdf417RowHeight)
+		if diff := math.Abs(ratio - 3); diff < bestDiff {
+			best, bestDiff = c, diff
+		}
+	}
+	return best
+}
+
+// encodePDF417 draws a PDF417 symbol at the given security level, which adds
+// 2^(level+1) error-correction codewords, with the given number of data
+// columns, or as many as suit the text if columns is 0.
+func encodePDF417(text string, opts symbologyOptions) ([][]bool, error) {
+	latin1, err := latin1Bytes(text, "PDF417")
+	if err != nil {
+		return nil, err
+	}
+	data := pdf417ByteCompaction(latin1)
+	ecCount := 2 << opts.securityLevel
+	total := 1 + len(data) + ecCount
+
+	columns := opts.columns
+	if columns == 0 {
+		columns = pdf417Columns(total)
+		if columns == 0 {
+			return nil, fmt.Errorf("Text is too long for a PDF417 code at security level %d", opts.securityLevel)
+		}
+	}
+	rows := max(3, (total+columns-1)/columns)
+	maxRows := pdf417MaxRows
+	if n := pdf417MaxCodewords / columns; n < maxRows {
+		maxRows = n
+	}
+	if rows > maxRows {
+		return nil, fmt.Errorf("Text is too long for a PDF417 code with %d columns at security level %d: it needs %d rows, but at most %d fit",
+			columns, opts.securityLevel, rows, maxRows)
+	}
+
+	// The symbol length descriptor counts the data codewords including
+	// itself and the padding
+	padding := rows*columns - total
+	codewords := append([]int{1 + len(data) + padding}, data...)
+	for i := 0; i < padding; i++ {
+		codewords = append(codewords, 900)
+	}
+	codewords = append(codewords, pdf417ErrorCorrection(codewords, ecCount)...)
+
+	// Each row holds its codewords between row indicators, which spread the
+	// number of rows and columns and the security level over three rows
+	var modules [][]bool
+	for y := 0; y < rows; y++ {
+		row := make([]bool, 0, 17*columns+69)
+		add := func(pattern uint32, width int) {
+			for i := width - 1; i >= 0; i-- {
+				row = append(row, pattern>>i&1 == 1)
+			}
+		}
+		cluster := y % 3
+		base := y / 3 * 30
+		indicators := [3]int{
+			base + (rows-1)/3,
+			base + opts.securityLevel*3 + (rows-1)%3,
+			base + columns - 1,
+		}
+		add(pdf417Start, 17)
+		add(pdf417Patterns[cluster][indicators[cluster]], 17)
+		for _, cw := range codewords[y*columns : (y+1)*columns] {
+			add(pdf417Patterns[cluster][cw], 17)
+		}
+		add(pdf417Patterns[cluster][indicators[(cluster+2)%3]], 17)
+		add(pdf417Stop, 18)
+		for i := 0; i < pdf417RowHeight; i++ {
+			modules = append(modules, row)
+		}
+	}
+	return modules, nil
+}
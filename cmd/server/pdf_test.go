@@ -0,0 +1,273 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/boombuler/barcode/code128"
+	qrcode "github.com/skip2/go-qrcode"
+)
+
+// pdfFile is a minimal reader for the PDFs produced by pdfWriter. It
+// resolves objects through the cross-reference table, so a broken xref makes
+// the tests fail.
+type pdfFile struct {
+	data    []byte
+	offsets []int
+}
+
+func parsePDF(t *testing.T, data []byte) *pdfFile {
+	t.Helper()
+	if !bytes.HasPrefix(data, []byte("%PDF-1.")) {
+		t.Fatal("missing PDF header")
+	}
+	if !bytes.HasSuffix(bytes.TrimSpace(data), []byte("%%EOF")) {
+		t.Fatal("missing EOF marker")
+	}
+
+	m := regexp.MustCompile(`startxref\s+(\d+)\s+%%EOF\s*$`).FindSubmatch(data)
+	if m == nil {
+		t.Fatal("missing startxref")
+	}
+	xref, _ := strconv.Atoi(string(m[1]))
+	if !bytes.HasPrefix(data[xref:], []byte("xref\n")) {
+		t.Fatalf("startxref %d does not point at the xref table", xref)
+	}
+
+	var first, count int
+	if _, err := fmt.Sscanf(string(data[xref+5:]), "%d %d", &first, &count); err != nil {
+		t.Fatalf("invalid xref subsection: %v", err)
+	}
+	entries := strings.Split(string(data[xref:]), "\n")[2 : 2+count]
+
+	f := &pdfFile{data: data, offsets: make([]int, count)}
+	for i, entry := range entries[1:] {
+		off, _ := strconv.Atoi(entry[:10])
+		if !bytes.HasPrefix(data[off:], []byte(fmt.Sprintf("%d 0 obj", i+1))) {
+			t.Fatalf("xref entry for object %d points at the wrong offset", i+1)
+		}
+		f.offsets[i+1] = off
+	}
+	return f
+}
+
+// object returns the body of object n.
+func (f *pdfFile) object(t *testing.T, n int) string {
+	t.Helper()
+	if n <= 0 || n >= len(f.offsets) {
+		t.Fatalf("object %d out of range", n)
+	}
+	body := string(f.data[f.offsets[n]:])
+	return body[:strings.Index(body, "endobj")]
+}
+
+// ref returns the object number referenced by key in the dictionary.
+func (f *pdfFile) ref(t *testing.T, dict, key string) int {
+	t.Helper()
+	m := regexp.MustCompile(`/` + key + `\s+(\d+) 0 R`).FindStringSubmatch(dict)
+	if m == nil {
+		t.Fatalf("missing /%s reference in %q", key, dict)
+	}
+	n, _ := strconv.Atoi(m[1])
+	return n
+}
+
+// stream returns the data of stream object n, honoring its /Length.
+func (f *pdfFile) stream(t *testing.T, n int) string {
+	t.Helper()
+	obj := f.object(t, n)
+	m := regexp.MustCompile(`/Length (\d+)`).FindStringSubmatch(obj)
+	if m == nil {
+		t.Fatalf("stream object %d has no /Length", n)
+	}
+	length, _ := strconv.Atoi(m[1])
+	start := strings.Index(obj, "stream\n") + len("stream\n")
+	if !strings.HasPrefix(obj[start+length:], "\nendstream") {
+		t.Fatalf("stream object %d /Length does not match its data", n)
+	}
+	return obj[start : start+length]
+}
+
+// page returns the first page's media box and content stream.
+func (f *pdfFile) page(t *testing.T) ([4]float64, string) {
+	t.Helper()
+	trailer := string(f.data[bytes.LastIndex(f.data, []byte("trailer")):])
+	catalog := f.object(t, f.ref(t, trailer, "Root"))
+	pages := f.object(t, f.ref(t, catalog, "Pages"))
+	m := regexp.MustCompile(`/Kids \[(\d+) 0 R`).FindStringSubmatch(pages)
+	if m == nil {
+		t.Fatal("page tree has no kids")
+	}
+	n, _ := strconv.Atoi(m[1])
+	page := f.object(t, n)
+
+	var box [4]float64
+	m = regexp.MustCompile(`/MediaBox \[([^\]]*)\]`).FindStringSubmatch(page)
+	if m == nil {
+		t.Fatal("page has no /MediaBox")
+	}
+	if _, err := fmt.Sscanf(m[1], "%g %g %g %g", &box[0], &box[1], &box[2], &box[3]); err != nil {
+		t.Fatalf("invalid /MediaBox %q", m[1])
+	}
+	return box, f.stream(t, f.ref(t, page, "Contents"))
+}
+
+// pdfModules rebuilds the module grid from the rectangles drawn in module
+// units after the content stream's "cm" operator.
+func pdfModules(t *testing.T, content string, cols, rows int) [][]bool {
+	t.Helper()
+	modules := make([][]bool, rows)
+	for y := range modules {
+		modules[y] = make([]bool, cols)
+	}
+	body := content[strings.Index(content, " cm\n"):]
+	for _, m := range regexp.MustCompile(`(?m)^(\d+) (\d+) (\d+) 1 re$`).FindAllStringSubmatch(body, -1) {
+		x, _ := strconv.Atoi(m[1])
+		y, _ := strconv.Atoi(m[2])
+		w, _ := strconv.Atoi(m[3])
+		for i := x; i < x+w; i++ {
+			modules[y][i] = true
+		}
+	}
+	return modules
+}
+
+func approxEqual(a, b float64) bool {
+	return math.Abs(a-b) < 0.01
+}
+
+func TestQRHandler_PDF(t *testing.T) {
+	isolateRateLimiter(t)
+
+	req := httptest.NewRequest("GET", "/qr?text=hello&format=pdf&width_mm=50", nil)
+	rr := httptest.NewRecorder()
+	qrHandler(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", rr.Code)
+	}
+	if ct := rr.Header().Get("Content-Type"); ct != "application/pdf" {
+		t.Fatalf("expected Content-Type application/pdf, got %s", ct)
+	}
+
+	box, content := parsePDF(t, rr.Body.Bytes()).page(t)
+	want := 50 * 72 / 25.4
+	if box[0] != 0 || box[1] != 0 || !approxEqual(box[2], want) || !approxEqual(box[3], want) {
+		t.Fatalf("expected a 50x50mm page, got %v", box)
+	}
+
+	// The drawn modules must match the QR bitmap
+	qr, err := qrcode.New("hello", qrcode.Medium)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bitmap := qr.Bitmap()
+	got := pdfModules(t, content, len(bitmap), len(bitmap))
+	for y := range bitmap {
+		for x := range bitmap[y] {
+			if got[y][x] != bitmap[y][x] {
+				t.Fatalf("module (%d,%d) differs from QR bitmap", x, y)
+			}
+		}
+	}
+}
+
+func TestQRHandler_PDF_DefaultSize(t *testing.T) {
+	isolateRateLimiter(t)
+
+	// Without explicit dimensions the pixel size is converted at 96 DPI
+	req := httptest.NewRequest("GET", "/qr?text=hello&format=pdf&size=96", nil)
+	rr := httptest.NewRecorder()
+	qrHandler(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", rr.Code)
+	}
+	box, _ := parsePDF(t, rr.Body.Bytes()).page(t)
+	if !approxEqual(box[2], 72) || !approxEqual(box[3], 72) {
+		t.Fatalf("expected a 1x1 inch page, got %v", box)
+	}
+}
+
+func TestQRHandler_PDF_Rectangle(t *testing.T) {
+	isolateRateLimiter(t)
+
+	// A single dimension follows the shape's 4:1 aspect ratio
+	req := httptest.NewRequest("GET", "/qr?text=hello&format=pdf&shape=rectangle&height_mm=20", nil)
+	rr := httptest.NewRecorder()
+	qrHandler(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", rr.Code)
+	}
+	box, _ := parsePDF(t, rr.Body.Bytes()).page(t)
+	if !approxEqual(box[2], 80*72/25.4) || !approxEqual(box[3], 20*72/25.4) {
+		t.Fatalf("expected an 80x20mm page, got %v", box)
+	}
+}
+
+func TestQRHandler_PDF_InvalidDimensions(t *testing.T) {
+	isolateRateLimiter(t)
+
+	cases := map[string]string{
+		"format=pdf&width_mm=abc":  "width_mm must be a valid number",
+		"format=pdf&height_mm=2":   "height_mm must be between 5 and 1000 millimetres",
+		"format=pdf&width_mm=5000": "width_mm must be between 5 and 1000 millimetres",
+		"width_mm=50":              "width_mm and height_mm only apply to PDF output",
+		"format=svg&height_mm=50":  "width_mm and height_mm only apply to PDF output",
+	}
+	for query, msg := range cases {
+		resetRateLimiter() // Reset rate limiter before each request
+
+		req := httptest.NewRequest("GET", "/qr?text=hello&"+query, nil)
+		rr := httptest.NewRecorder()
+		qrHandler(rr, req)
+
+		if rr.Code != http.StatusBadRequest {
+			t.Fatalf("expected status 400 for %s, got %d", query, rr.Code)
+		}
+		if !strings.Contains(rr.Body.String(), msg) {
+			t.Fatalf("expected error %q for %s, got %s", msg, query, rr.Body.String())
+		}
+	}
+}
+
+func TestBarcodeHandler_PDF(t *testing.T) {
+	isolateRateLimiter(t)
+
+	req := httptest.NewRequest("GET", "/barcode?text=1234567890&format=pdf&width_mm=80&height_mm=20", nil)
+	rr := httptest.NewRecorder()
+	barcodeHandler(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", rr.Code)
+	}
+	if ct := rr.Header().Get("Content-Type"); ct != "application/pdf" {
+		t.Fatalf("expected Content-Type application/pdf, got %s", ct)
+	}
+
+	box, content := parsePDF(t, rr.Body.Bytes()).page(t)
+	if !approxEqual(box[2], 80*72/25.4) || !approxEqual(box[3], 20*72/25.4) {
+		t.Fatalf("expected an 80x20mm page, got %v", box)
+	}
+
+	// The bars must match the Code 128 modules, quiet zone included
+	bar, err := code128.Encode("1234567890")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := addQuietZone(barcodeModules(bar), linearSymbologies["code128"].margin)
+	got := pdfModules(t, content, len(want[0]), 1)
+	for x := range want[0] {
+		if got[0][x] != want[0][x] {
+			t.Fatalf("module %d differs from Code 128 bars", x)
+		}
+	}
+}
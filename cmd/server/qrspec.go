@@ -0,0 +1,342 @@
+package main
+
+// QR Code symbol structure from ISO/IEC 18004, shared by the code that has
+// to know where each codeword of a symbol ends up.
+
+// qrBlocks describes how the codewords of one version and error-correction
+// level are split into Reed-Solomon blocks. Every block ends with ecPerBlock
+// error-correction codewords, and the blocks come in up to two groups whose
+// data codeword counts differ by one.
+type qrBlocks struct {
+	ecPerBlock int
+	blocks1    int
+	dataBlock1 int
+	blocks2    int
+	dataBlock2 int
+}
+
+// qrBlockTable holds the block structure of versions 1 to 40, indexed by
+// version and then by level in L, M, Q, H order.
+var qrBlockTable = [41][4]qrBlocks{
+	{}, // Version 0 doesn't exist
+	{{7, 1, 19, 0, 0}, {10, 1, 16, 0, 0}, {13, 1, 13, 0, 0}, {17, 1, 9, 0, 0}},                // 1
+	{{10, 1, 34, 0, 0}, {16, 1, 28, 0, 0}, {22, 1, 22, 0, 0}, {28, 1, 16, 0, 0}},              // 2
+	{{15, 1, 55, 0, 0}, {26, 1, 44, 0, 0}, {18, 2, 17, 0, 0}, {22, 2, 13, 0, 0}},              // 3
+	{{20, 1, 80, 0, 0}, {18, 2, 32, 0, 0}, {26, 2, 24, 0, 0}, {16, 4, 9, 0, 0}},               // 4
+	{{26, 1, 108, 0, 0}, {24, 2, 43, 0, 0}, {18, 2, 15, 2, 16}, {22, 2, 11, 2, 12}},           // 5
+	{{18, 2, 68, 0, 0}, {16, 4, 27, 0, 0}, {24, 4, 19, 0, 0}, {28, 4, 15, 0, 0}},              // 6
+	{{20, 2, 78, 0, 0}, {18, 4, 31, 0, 0}, {18, 2, 14, 4, 15}, {26, 4, 13, 1, 14}},            // 7
+	{{24, 2, 97, 0, 0}, {22, 2, 38, 2, 39}, {22, 4, 18, 2, 19}, {26, 4, 14, 2, 15}},           // 8
+	{{30, 2, 116, 0, 0}, {22, 3, 36, 2, 37}, {20, 4, 16, 4, 17}, {24, 4, 12, 4, 13}},          // 9
+	{{18, 2, 68, 2, 69}, {26, 4, 43, 1, 44}, {24, 6, 19, 2, 20}, {28, 6, 15, 2, 16}},          // 10
+	{{20, 4, 81, 0, 0}, {30, 1, 50, 4, 51}, {28, 4, 22, 4, 23}, {24, 3, 12, 8, 13}},           // 11
+	{{24, 2, 92, 2, 93}, {22, 6, 36, 2, 37}, {26, 4, 20, 6, 21}, {28, 7, 14, 4, 15}},          // 12
+	{{26, 4, 107, 0, 0}, {22, 8, 37, 1, 38}, {24, 8, 20, 4, 21}, {22, 12, 11, 4, 12}},         // 13
+	{{30, 3, 115, 1, 116}, {24, 4, 40, 5, 41}, {20, 11, 16, 5, 17}, {24, 11, 12, 5, 13}},      // 14
+	{{22, 5, 87, 1, 88}, {24, 5, 41, 5, 42}, {30, 5, 24, 7, 25}, {24, 11, 12, 7, 13}},         // 15
+	{{24, 5, 98, 1, 99}, {28, 7, 45, 3, 46}, {24, 15, 19, 2, 20}, {30, 3, 15, 13, 16}},        // 16
+	{{28, 1, 107, 5, 108}, {28, 10, 46, 1, 47}, {28, 1, 22, 15, 23}, {28, 2, 14, 17, 15}},     // 17
+	{{30, 5, 120, 1, 121}, {26, 9, 43, 4, 44}, {28, 17, 22, 1, 23}, {28, 2, 14, 19, 15}},      // 18
+	{{28, 3, 113, 4, 114}, {26, 3, 44, 11, 45}, {26, 17, 21, 4, 22}, {26, 9, 13, 16, 14}},     // 19
+	{{28, 3, 107, 5, 108}, {26, 3, 41, 13, 42}, {30, 15, 24, 5, 25}, {28, 15, 15, 10, 16}},    // 20
+	{{28, 4, 116, 4, 117}, {26, 17, 42, 0, 0}, {28, 17, 22, 6, 23}, {30, 19, 16, 6, 17}},      // 21
+	{{28, 2, 111, 7, 112}, {28, 17, 46, 0, 0}, {30, 7, 24, 16, 25}, {24, 34, 13, 0, 0}},       // 22
+	{{30, 4, 121, 5, 122}, {28, 4, 47, 14, 48}, {30, 11, 24, 14, 25}, {30, 16, 15, 14, 16}},   // 23
+	{{30, 6, 117, 4, 118}, {28, 6, 45, 14, 46}, {30, 11, 24, 16, 25}, {30, 30, 16, 2, 17}},    // 24
+	{{26, 8, 106, 4, 107}, {28, 8, 47, 13, 48}, {30, 7, 24, 22, 25}, {30, 22, 15, 13, 16}},    // 25
+	{{28, 10, 114, 2, 115}, {28, 19, 46, 4, 47}, {28, 28, 22, 6, 23}, {30, 33, 16, 4, 17}},    // 26
+	{{30, 8, 122, 4, 123}, {28, 22, 45, 3, 46}, {30, 8, 23, 26, 24}, {30, 12, 15, 28, 16}},    // 27
+	{{30, 3, 117, 10, 118}, {28, 3, 45, 23, 46}, {30, 4, 24, 31, 25}, {30, 11, 15, 31, 16}},   // 28
+	{{30, 7, 116, 7, 117}, {28, 21, 45, 7, 46}, {30, 1, 23, 37, 24}, {30, 19, 15, 26, 16}},    // 29
+	{{30, 5, 115, 10, 116}, {28, 19, 47, 10, 48}, {30, 15, 24, 25, 25}, {30, 23, 15, 25, 16}}, // 30
+	{{30, 13, 115, 3, 116}, {28, 2, 46, 29, 47}, {30, 42, 24, 1, 25}, {30, 23, 15, 28, 16}},   // 31
+	{{30, 17, 115, 0, 0}, {28, 10, 46, 23, 47}, {30, 10, 24, 35, 25}, {30, 19, 15, 35, 16}},   // 32
+	{{30, 17, 115, 1, 116}, {28, 14, 46, 21, 47}, {30, 29, 24, 19, 25}, {30, 11, 15, 46, 16}}, // 33
+	{{30, 13, 115, 6, 116}, {28, 14, 46, 23, 47}, {30, 44, 24, 7, 25}, {30, 59, 16, 1, 17}},   // 34
+	{{30, 12, 121, 7, 122}, {28, 12, 47, 26, 48}, {30, 39, 24, 14, 25}, {30, 22, 15, 41, 16}}, // 35
+	{{30, 6, 121, 14, 122}, {28, 6, 47, 34, 48}, {30, 46, 24, 10, 25}, {30, 2, 15, 64, 16}},   // 36
+	{{30, 17, 122, 4, 123}, {28, 29, 46, 14, 47}, {30, 49, 24, 10, 25}, {30, 24, 15, 46, 16}}, // 37
+	{{30, 4, 122, 18, 123}, {28, 13, 46, 32, 47}, {30, 48, 24, 14, 25}, {30, 42, 15, 32, 16}}, // 38
+	{{30, 20, 117, 4, 118}, {28, 40, 47, 7, 48}, {30, 43, 24, 22, 25}, {30, 10, 15, 67, 16}},  // 39
+	{{30, 19, 118, 6, 119}, {28, 18, 47, 31, 48}, {30, 34, 24, 34, 25}, {30, 20, 15, 61, 16}}, // 40
+}
+
+// qrLevelIndex maps an error-correction level name to its qrBlockTable column.
+var qrLevelIndex = map[string]int{"L": 0, "M": 1, "Q": 2, "H": 3}
+
+// qrAlignmentCenters lists the row and column coordinates of the alignment
+// pattern centers of each version.
+var qrAlignmentCenters = [41][]int{
+	{}, {},
+	{6, 18}, {6, 22}, {6, 26}, {6, 30}, {6, 34},
+	{6, 22, 38}, {6, 24, 42}, {6, 26, 46}, {6, 28, 50}, {6, 30, 54}, {6, 32, 58}, {6, 34, 62},
+	{6, 26, 46, 66}, {6, 26, 48, 70}, {6, 26, 50, 74}, {6, 30, 54, 78}, {6, 30, 56, 82}, {6, 30, 58, 86}, {6, 34, 62, 90},
+	{6, 28, 50, 72, 94}, {6, 26, 50, 74, 98}, {6, 30, 54, 78, 102}, {6, 28, 54, 80, 106}, {6, 32, 58, 84, 110}, {6, 30, 58, 86, 114}, {6, 34, 62, 90, 118},
+	{6, 26, 50, 74, 98, 122}, {6, 30, 54, 78, 102, 126}, {6, 26, 52, 78, 104, 130}, {6, 30, 56, 82, 108, 134}, {6, 34, 60, 86, 112, 138}, {6, 30, 58, 86, 114, 142}, {6, 34, 62, 90, 118, 146},
+	{6, 30, 54, 78, 102, 126, 150}, {6, 24, 50, 76, 102, 128, 154}, {6, 28, 54, 80, 106, 132, 158}, {6, 32, 58, 84, 110, 136, 162}, {6, 26, 54, 82, 110, 138, 166}, {6, 30, 58, 86, 114, 142, 170},
+}
+
+// qrSymbolSize returns the number of modules along each side of a symbol,
+// not counting the quiet zone.
+func qrSymbolSize(version int) int {
+	return 17 + 4*version
+}
+
+// numBlocks returns the total number of Reed-Solomon blocks.
+func (b qrBlocks) numBlocks() int {
+	return b.blocks1 + b.blocks2
+}
+
+// dataCodewords returns the number of data codewords in block i.
+func (b qrBlocks) dataCodewords(i int) int {
+	if i < b.blocks1 {
+		return b.dataBlock1
+	}
+	return b.dataBlock2
+}
+
+// totalDataCodewords returns the number of data codewords in the symbol.
+func (b qrBlocks) totalDataCodewords() int {
+	return b.blocks1*b.dataBlock1 + b.blocks2*b.dataBlock2
+}
+
+// totalCodewords returns the number of data and error-correction codewords
+// in the symbol.
+func (b qrBlocks) totalCodewords() int {
+	return b.totalDataCodewords() + b.numBlocks()*b.ecPerBlock
+}
+
+// codewordBlocks returns, for each codeword in the interleaved order in which
+// codewords are placed in the symbol, the index of the block it belongs to.
+// Data codewords are interleaved first, taking one from each block in turn,
+// followed by the error-correction codewords in the same fashion.
+func (b qrBlocks) codewordBlocks() []int {
+	order := make([]int, 0, b.totalCodewords())
+	for i := 0; i < max(b.dataBlock1, b.dataBlock2); i++ {
+		for block := 0; block < b.numBlocks(); block++ {
+			if i < b.dataCodewords(block) {
+				order = append(order, block)
+			}
+		}
+	}
+	for i := 0; i < b.ecPerBlock; i++ {
+		for block := 0; block < b.numBlocks(); block++ {
+			order = append(order, block)
+		}
+	}
+	return order
+}
+
+// qrFunctionModules marks the modules of a symbol that hold function patterns
+// or format and version information rather than codewords: finder patterns
+// and their separators, timing patterns, alignment patterns, the areas
+// reserved for format and version information, and the dark module.
+func qrFunctionModules(version int) [][]bool {
+	n := qrSymbolSize(version)
+	function := make([][]bool, n)
+	for y := range function {
+		function[y] = make([]bool, n)
+	}
+	mark := func(x0, y0, w, h int) {
+		for y := y0; y < y0+h; y++ {
+			for x := x0; x < x0+w; x++ {
+				function[y][x] = true
+			}
+		}
+	}
+
+	// Finder patterns with their separators and the format information
+	// next to them
+	mark(0, 0, 9, 9)
+	mark(n-8, 0, 8, 9)
+	mark(0, n-8, 9, 8)
+
+	// Timing patterns
+	mark(6, 0, 1, n)
+	mark(0, 6, n, 1)
+
+	// Alignment patterns, except where they would overlap a finder pattern
+	centers := qrAlignmentCenters[version]
+	for _, cy := range centers {
+		for _, cx := range centers {
+			if (cx < 9 && cy < 9) || (cx < 9 && cy > n-9) || (cx > n-9 && cy < 9) {
+				continue
+			}
+			mark(cx-2, cy-2, 5, 5)
+		}
+	}
+
+	// Version information
+	if version >= 7 {
+		mark(n-11, 0, 3, 6)
+		mark(0, n-11, 6, 3)
+	}
+	return function
+}
+
+// qrModuleBits returns, for each module of a symbol, the index of the
+// codeword bit it holds, or -1 for function modules and remainder bits. Bit
+// i is bit 7-i%8 of codeword i/8 in placement order. Codewords are placed in
+// two-module-wide columns, starting at the bottom right and snaking up and
+// down towards the left, skipping the vertical timing pattern.
+func qrModuleBits(version int) [][]int {
+	n := qrSymbolSize(version)
+	function := qrFunctionModules(version)
+	bits := make([][]int, n)
+	for y := range bits {
+		bits[y] = make([]int, n)
+		for x := range bits[y] {
+			bits[y][x] = -1
+		}
+	}
+
+	// Modules past the last codeword hold remainder bits and stay at -1
+	total := 8 * qrBlockTable[version][0].totalCodewords()
+	bit := 0
+	upward := true
+	for right := n - 1; right > 0; right -= 2 {
+		if right == 6 {
+			right-- // The column pair never straddles the timing pattern
+		}
+		for i := 0; i < n; i++ {
+			y := i
+			if upward {
+				y = n - 1 - i
+			}
+			for x := right; x >= right-1; x-- {
+				if !function[y][x] && bit < total {
+					bits[y][x] = bit
+					bit++
+				}
+			}
+		}
+		upward = !upward
+	}
+	return bits
+}
+
+// qrMaskPatterns are the eight data mask patterns, which invert the codeword
+// modules at column x and row y where they return true.
+var qrMaskPatterns = [8]func(x, y int) bool{
+	func(x, y int) bool { return (x+y)%2 == 0 },
+	func(x, y int) bool { return y%2 == 0 },
+	func(x, y int) bool { return x%3 == 0 },
+	func(x, y int) bool { return (x+y)%3 == 0 },
+	func(x, y int) bool { return (y/2+x/3)%2 == 0 },
+	func(x, y int) bool { return x*y%2+x*y%3 == 0 },
+	func(x, y int) bool { return (x*y%2+x*y%3)%2 == 0 },
+	func(x, y int) bool { return ((x+y)%2+x*y%3)%2 == 0 },
+}
+
+// qrFormatLevelBits holds the level indicator of the format information.
+var qrFormatLevelBits = map[string]int{"L": 1, "M": 0, "Q": 3, "H": 2}
+
+// qrFormatBits returns the 15-bit format information of a symbol: the level
+// and mask pattern, a BCH(15,5) code and the fixed XOR pattern.
+func qrFormatBits(level string, mask int) int {
+	info := qrFormatLevelBits[level]<<3 | mask
+	rem := info << 10
+	for i := 14; i >= 10; i-- {
+		if rem&(1<<i) != 0 {
+			rem ^= 0x537 << (i - 10)
+		}
+	}
+	return (info<<10 | rem) ^ 0x5412
+}
+
+// qrFormatModules returns the column and row of the two modules holding
+// each format information bit, from the least significant bit up: one copy
+// around the top left finder pattern, and one split between the other two.
+func qrFormatModules(version int) [15][2][2]int {
+	n := qrSymbolSize(version)
+	topLeft := [15][2]int{
+		{8, 0}, {8, 1}, {8, 2}, {8, 3}, {8, 4}, {8, 5}, {8, 7}, {8, 8},
+		{7, 8}, {5, 8}, {4, 8}, {3, 8}, {2, 8}, {1, 8}, {0, 8},
+	}
+	var modules [15][2][2]int
+	for i, p := range topLeft {
+		modules[i][0] = p
+		if i < 8 {
+			modules[i][1] = [2]int{n - 1 - i, 8}
+		} else {
+			modules[i][1] = [2]int{8, n - 15 + i}
+		}
+	}
+	return modules
+}
+
+// remaskQR switches a symbol of the version and level, drawn margin modules
+// in from the edges of modules, to mask pattern mask: it swaps the codeword
+// modules from the pattern the symbol was drawn with over to the new one, and
+// rewrites the format information to match.
+func remaskQR(modules [][]bool, margin, version int, level string, mask int) {
+	at := func(x, y int) *bool { return &modules[margin+y][margin+x] }
+
+	// Read the current pattern from the format information around the top
+	// left finder pattern
+	format := qrFormatModules(version)
+	bits := 0
+	for i, m := range format {
+		if *at(m[0][0], m[0][1]) {
+			bits |= 1 << i
+		}
+	}
+	current := -1
+	for p := range qrMaskPatterns {
+		if qrFormatBits(level, p) == bits {
+			current = p
+		}
+	}
+	if current < 0 || current == mask {
+		return
+	}
+
+	function := qrFunctionModules(version)
+	for y, row := range function {
+		for x, isFunction := range row {
+			if !isFunction && qrMaskPatterns[current](x, y) != qrMaskPatterns[mask](x, y) {
+				*at(x, y) = !*at(x, y)
+			}
+		}
+	}
+	bits = qrFormatBits(level, mask)
+	for i, m := range format {
+		for _, p := range m {
+			*at(p[0], p[1]) = bits&(1<<i) != 0
+		}
+	}
+}
+
+// qrCapacity returns how many digits, alphanumeric characters or bytes a
+// symbol of the version and level holds, with the text all in one mode.
+func qrCapacity(version int, level string) (digits, alphanumeric, bytes int) {
+	// Bits left after the mode indicator and the character count, whose
+	// length grows with the version
+	data := 8*qrBlockTable[version][qrLevelIndex[level]].totalDataCodewords() - 4
+	countBits := [3]int{10, 9, 8}
+	switch {
+	case version >= 27:
+		countBits = [3]int{14, 13, 16}
+	case version >= 10:
+		countBits = [3]int{12, 11, 16}
+	}
+
+	// Digits take 10 bits per group of three, with 4 or 7 bits for a
+	// shorter last group; alphanumeric characters 11 bits per pair and 6
+	// for a single one
+	rest := data - countBits[0]
+	digits = rest / 10 * 3
+	switch rem := rest % 10; {
+	case rem >= 7:
+		digits += 2
+	case rem >= 4:
+		digits++
+	}
+	rest = data - countBits[1]
+	alphanumeric = rest / 11 * 2
+	if rest%11 >= 6 {
+		alphanumeric++
+	}
+	bytes = (data - countBits[2]) / 8
+	return digits, alphanumeric, bytes
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bytes"
+	"image/color"
+	"strings"
+	"testing"
+
+	qrcode "github.com/skip2/go-qrcode"
+)
+
+func TestQRModuleBits_Capacity(t *testing.T) {
+	for version := 1; version <= 40; version++ {
+		n := qrSymbolSize(version)
+		function := qrFunctionModules(version)
+		bits := qrModuleBits(version)
+
+		// Every codeword bit is placed exactly once, and only the few
+		// remainder bits are left over
+		total := 8 * qrBlockTable[version][0].totalCodewords()
+		placed := make([]bool, total)
+		free := 0
+		for y := 0; y < n; y++ {
+			for x := 0; x < n; x++ {
+				if function[y][x] {
+					continue
+				}
+				free++
+				if b := bits[y][x]; b >= 0 {
+					if placed[b] {
+						t.Fatalf("version %d: bit %d placed twice", version, b)
+					}
+					placed[b] = true
+				}
+			}
+		}
+		for b, ok := range placed {
+			if !ok {
+				t.Fatalf("version %d: bit %d not placed", version, b)
+			}
+		}
+		if remainder := free - total; remainder < 0 || remainder > 7 {
+			t.Fatalf("version %d: %d remainder bits", version, remainder)
+		}
+
+		// All levels of a version share the same number of codewords
+		for level := 1; level < 4; level++ {
+			if got := 8 * qrBlockTable[version][level].totalCodewords(); got != total {
+				t.Fatalf("version %d level %d: %d codeword bits, want %d", version, level, got, total)
+			}
+		}
+	}
+}
+
+func TestQRModuleBits_MatchesEncoder(t *testing.T) {
+	// Read the data codewords back out of symbols drawn by go-qrcode, from a
+	// single block up to versions with version information and two groups
+	for _, text := range []string{"hello", strings.Repeat("Lorem ipsum dolor sit amet. ", 8)} {
+		qr, err := qrcode.New(text, qrcode.Highest)
+		if err != nil {
+			t.Fatal(err)
+		}
+		n := qrSymbolSize(qr.VersionNumber)
+		png, err := qr.PNG((n + 8) * 8)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, mask := qrFormatInfo(t, png)
+
+		blocks := qrBlockTable[qr.VersionNumber][qrLevelIndex["H"]]
+		codewords := make([]byte, blocks.totalCodewords())
+		bitmap := qr.Bitmap()
+		for y, row := range qrModuleBits(qr.VersionNumber) {
+			for x, b := range row {
+				if b >= 0 && bitmap[y+4][x+4] != qrMaskPatterns[mask](x, y) {
+					codewords[b/8] |= 0x80 >> (b % 8)
+				}
+			}
+		}
+
+		// Undo the interleaving to get the data codewords in order
+		data := make([][]byte, blocks.numBlocks())
+		for i, block := range blocks.codewordBlocks()[:blocks.totalDataCodewords()] {
+			data[block] = append(data[block], codewords[i])
+		}
+		stream := bytes.Join(data, nil)
+
+		// Byte mode with an 8-bit length below version 10, 16-bit from there
+		header, shift := 12, 4
+		if qr.VersionNumber >= 10 {
+			header = 20
+		}
+		got := make([]byte, len(text))
+		for i := range got {
+			bit := header + 8*i
+			got[i] = stream[bit/8]<<shift | stream[bit/8+1]>>(8-shift)
+		}
+		if string(got) != text {
+			t.Fatalf("version %d: read %q, want %q", qr.VersionNumber, got, text)
+		}
+	}
+}
+
+func TestQRCapacity(t *testing.T) {
+	// Capacities from the tables of ISO/IEC 18004
+	cases := []struct {
+		version                      int
+		level                        string
+		digits, alphanumeric, nbytes int
+	}{
+		{1, "L", 41, 25, 17},
+		{1, "M", 34, 20, 14},
+		{1, "H", 17, 10, 7},
+		{9, "Q", 312, 189, 130},
+		{10, "M", 513, 311, 213},
+		{27, "H", 1501, 910, 625},
+		{40, "L", 7089, 4296, 2953},
+		{40, "H", 3057, 1852, 1273},
+	}
+	for _, tc := range cases {
+		digits, alphanumeric, nbytes := qrCapacity(tc.version, tc.level)
+		if digits != tc.digits || alphanumeric != tc.alphanumeric || nbytes != tc.nbytes {
+			t.Fatalf("version %d-%s: got %d digits, %d alphanumeric, %d bytes, want %d, %d, %d",
+				tc.version, tc.level, digits, alphanumeric, nbytes, tc.digits, tc.alphanumeric, tc.nbytes)
+		}
+	}
+}
+
+func TestRemaskQR(t *testing.T) {
+	// Every mask pattern reads back as the same text, with a matching
+	// format, from a single block up to versions with version information
+	for _, text := range []string{"hello", strings.Repeat("Lorem ipsum dolor sit amet. ", 8)} {
+		for mask := range qrMaskPatterns {
+			qr, err := qrcode.New(text, qrcode.Medium)
+			if err != nil {
+				t.Fatal(err)
+			}
+			modules := qrModules(qr, 4)
+			remaskQR(modules, 4, qr.VersionNumber, "M", mask)
+
+			img, err := rasterize(modules, renderOptions{width: 8 * len(modules), height: 8 * len(modules),
+				fg: color.RGBA{A: 255}, bg: color.RGBA{R: 255, G: 255, B: 255, A: 255}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, got := qrFormatInfo(t, encodePNG(t, img)); got != mask {
+				t.Fatalf("version %d: expected mask %d, got %d", qr.VersionNumber, mask, got)
+			}
+			codes := decodeImage(img)
+			if len(codes) != 1 || codes[0].Text != text {
+				t.Fatalf("version %d mask %d: expected %q, got %+v", qr.VersionNumber, mask, text, codes)
+			}
+		}
+	}
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"io"
+	"math"
+
+	"github.com/boombuler/barcode"
+	qrcode "github.com/skip2/go-qrcode"
+)
+
+// renderOptions describes how a module grid is turned into an image.
+type renderOptions struct {
+	format string
+
+	// Pixel size of PNG and SVG output
+	width, height int
+
+	// Page size of PDF output
+	widthMM, heightMM float64
+
+	// Colors of the dark and light modules; a bg with zero alpha leaves the
+	// background transparent
+	fg, bg color.RGBA
+
+	// When set, dark modules fade from gradient[0] on the left to
+	// gradient[1] on the right instead of using fg
+	gradient []color.RGBA
+
+	// Optional logo composited over the middle of the symbol
+	logo *logoOverlay
+
+	// Optional human-readable text below the bars of a 1D code, which adds
+	// to the height
+	caption *hrtCaption
+
+	// Optional Swiss cross drawn over the middle of a QR-bill code
+	cross *swissCross
+}
+
+// key identifies the options in cache keys.
+func (o renderOptions) key() string {
+	key := fmt.Sprintf("%s:%dx%d:%gx%g:%s:%s", o.format, o.width, o.height,
+		o.widthMM, o.heightMM, colorName(o.fg), colorName(o.bg))
+	for _, c := range o.gradient {
+		key += ":" + colorName(c)
+	}
+	if o.cross != nil {
+		key += ":cross"
+	}
+	return key
+}
+
+// barcodeModules converts an unscaled barcode into its module grid, where
+// true marks a dark module. 1D barcodes yield a single row.
+func barcodeModules(bar barcode.Barcode) [][]bool {
+	bounds := bar.Bounds()
+	modules := make([][]bool, bounds.Dy())
+	for y := range modules {
+		modules[y] = make([]bool, bounds.Dx())
+		for x := range modules[y] {
+			r, _, _, _ := bar.At(bounds.Min.X+x, bounds.Min.Y+y).RGBA()
+			modules[y][x] = r < 0x8000
+		}
+	}
+	return modules
+}
+
+// qrModules returns the module grid of a QR code with a quiet zone of margin
+// modules, replacing the fixed 4-module border go-qrcode draws.
+func qrModules(qr *qrcode.QRCode, margin int) [][]bool {
+	qr.DisableBorder = true
+	return addQuietZone(qr.Bitmap(), margin)
+}
+
+// addQuietZone surrounds a module grid with margin light modules. A 1D code
+// only gets them on its left and right, as its bars span the full height.
+func addQuietZone(modules [][]bool, margin int) [][]bool {
+	rows, cols := len(modules), 0
+	if rows > 0 {
+		cols = len(modules[0])
+	}
+	marginY := margin
+	if rows == 1 {
+		marginY = 0
+	}
+	padded := make([][]bool, rows+2*marginY)
+	for y := range padded {
+		padded[y] = make([]bool, cols+2*margin)
+		if y >= marginY && y < marginY+rows {
+			copy(padded[y][margin:], modules[y-marginY])
+		}
+	}
+	return padded
+}
+
+// darkBounds returns the smallest rectangle, in module units, holding every
+// dark module.
+func darkBounds(modules [][]bool) image.Rectangle {
+	var bounds image.Rectangle
+	for y, row := range modules {
+		for x, dark := range row {
+			if dark {
+				bounds = bounds.Union(image.Rect(x, y, x+1, y+1))
+			}
+		}
+	}
+	return bounds
+}
+
+// darkRuns calls draw with each horizontal run of dark modules, so that the
+// renderers can draw it as one shape: its row, and the columns it starts at
+// and ends before.
+func darkRuns(modules [][]bool, draw func(y, start, end int)) {
+	for y, row := range modules {
+		for x := 0; x < len(row); {
+			if !row[x] {
+				x++
+				continue
+			}
+			start := x
+			for x < len(row) && row[x] {
+				x++
+			}
+			draw(y, start, x)
+		}
+	}
+}
+
+// renderCode writes a module grid in the format selected by opts.
+func renderCode(w io.Writer, modules [][]bool, opts renderOptions) error {
+	switch opts.format {
+	case "svg":
+		return writeSVG(w, modules, opts)
+	case "pdf":
+		return writePDF(w, modules, opts)
+	default:
+		img, err := rasterize(modules, opts)
+		if err != nil {
+			return err
+		}
+		return png.Encode(w, img)
+	}
+}
+
+// rasterScale returns the whole number of pixels per module that fits a grid
+// of rows x cols modules into width x height pixels, or 0 if not even one
+// does. The modules of 1D codes only have to fit across.
+func rasterScale(rows, cols, width, height int) int {
+	scale := width / cols
+	if s := height / rows; rows > 1 && s < scale {
+		scale = s
+	}
+	return scale
+}
+
+// rasterize draws a module grid into an image of opts.width x opts.height
+// pixels using a whole number of pixels per module. 2D codes keep square
+// modules and are centered, growing the image if even one pixel per module
+// does not fit. 1D codes are scaled horizontally by the largest whole factor
+// that fits, centered, and span the full height, above their caption if
+// any.
+func rasterize(modules [][]bool, opts renderOptions) (image.Image, error) {
+	rows := len(modules)
+	cols := 0
+	if rows > 0 {
+		cols = len(modules[0])
+	}
+	if rows == 0 || cols == 0 {
+		return nil, fmt.Errorf("empty module grid")
+	}
+
+	width, height := opts.width, opts.height
+	scaleX := rasterScale(rows, cols, width, height)
+	var scaleY int
+	if rows == 1 {
+		if scaleX == 0 {
+			return nil, fmt.Errorf("can not fit %d modules into %d pixels", cols, width)
+		}
+		scaleY = height
+	} else {
+		if scaleX == 0 {
+			scaleX = 1
+			width = max(width, cols)
+			height = max(height, rows)
+		}
+		scaleY = scaleX
+	}
+	offsetX := (width - cols*scaleX) / 2
+	offsetY := (height - rows*scaleY) / 2
+	barsHeight := height
+	if opts.caption != nil {
+		height += int(math.Round(opts.caption.height * float64(scaleX)))
+	}
+
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(img, img.Bounds(), image.NewUniform(opts.bg), image.Point{}, draw.Src)
+
+	// Dark modules are painted in fg, or with the same gradient /image
+	// produces, stretched across the dark modules of the symbol
+	var paint image.Image = image.NewUniform(opts.fg)
+	var paintOrigin image.Point
+	if opts.gradient != nil {
+		bounds := darkBounds(modules)
+		paint = generateImage(bounds.Dx()*scaleX, opts.gradient[0], opts.gradient[1])
+		paintOrigin = image.Pt(offsetX+bounds.Min.X*scaleX, offsetY+bounds.Min.Y*scaleY)
+	}
+
+	// Draw each horizontal run of dark modules as one rectangle
+	darkRuns(modules, func(y, start, end int) {
+		rect := image.Rect(offsetX+start*scaleX, offsetY+y*scaleY, offsetX+end*scaleX, offsetY+(y+1)*scaleY)
+		draw.Draw(img, rect, paint, rect.Min.Sub(paintOrigin), draw.Src)
+	})
+
+	// Draw the caption in the band below the bars, rounding its pixels,
+	// which may be smaller than a module, to whole pixels
+	if c := opts.caption; c != nil {
+		px := func(v float64) int { return int(math.Round(v * float64(scaleX))) }
+		for _, r := range c.rects {
+			rect := image.Rect(offsetX+px(r.x), barsHeight+px(r.y), offsetX+px(r.x+r.w), barsHeight+px(r.y+r.h))
+			draw.Draw(img, rect, image.NewUniform(opts.fg), image.Point{}, draw.Src)
+		}
+	}
+
+	// Draw the Swiss cross over the modules
+	if c := opts.cross; c != nil {
+		for _, l := range c.layers() {
+			rect := image.Rect(offsetX+int(math.Round(l.x*float64(scaleX))), offsetY+int(math.Round(l.y*float64(scaleY))),
+				offsetX+int(math.Round((l.x+l.w)*float64(scaleX))), offsetY+int(math.Round((l.y+l.h)*float64(scaleY))))
+			fill := opts.bg
+			if l.dark {
+				fill = opts.fg
+			}
+			draw.Draw(img, rect, image.NewUniform(fill), image.Point{}, draw.Src)
+		}
+	}
+
+	// Composite the logo on its plate
+	if l := opts.logo; l != nil {
+		if l.bg.A != 0 {
+			plate := image.Rect(offsetX+l.plate.Min.X*scaleX, offsetY+l.plate.Min.Y*scaleY,
+				offsetX+l.plate.Max.X*scaleX, offsetY+l.plate.Max.Y*scaleY)
+			draw.Draw(img, plate, image.NewUniform(l.bg), image.Point{}, draw.Src)
+		}
+		rect := image.Rect(offsetX+int(math.Round(l.x*float64(scaleX))), offsetY+int(math.Round(l.y*float64(scaleY))),
+			offsetX+int(math.Round((l.x+l.w)*float64(scaleX))), offsetY+int(math.Round((l.y+l.h)*float64(scaleY))))
+		if !rect.Empty() {
+			draw.Draw(img, rect, resizeImage(l.img, rect.Dx(), rect.Dy()), image.Point{}, draw.Over)
+		}
+	}
+	return img, nil
+}
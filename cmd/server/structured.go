@@ -0,0 +1,362 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/png"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"unicode/utf8"
+
+	"github.com/makiuchi-d/gozxing"
+	"github.com/makiuchi-d/gozxing/common/reedsolomon"
+	"github.com/makiuchi-d/gozxing/qrcode/decoder"
+	"github.com/makiuchi-d/gozxing/qrcode/encoder"
+)
+
+// Most symbols Structured Append can split a message across
+const maxAppendSymbols = 16
+
+// Bits of the Structured Append header: its mode indicator, the position of
+// the symbol and the number of symbols, and the parity of the message
+const appendHeaderBits = 4 + 4 + 4 + 8
+
+// appendParity returns the Structured Append parity of a message, the XOR of
+// all of its bytes.
+func appendParity(text string) byte {
+	var parity byte
+	for i := 0; i < len(text); i++ {
+		parity ^= text[i]
+	}
+	return parity
+}
+
+// appendCapacity returns how many bytes of the message one symbol of the
+// version and level holds after its Structured Append header.
+func appendCapacity(version int, level string) int {
+	countBits := 8
+	if version >= 10 {
+		countBits = 16
+	}
+	data := 8 * qrBlockTable[version][qrLevelIndex[level]].totalDataCodewords()
+	return (data - appendHeaderBits - 4 - countBits) / 8
+}
+
+// splitEvenly splits text into n parts of about the same number of
+// characters, or returns nil if it has fewer than n characters.
+func splitEvenly(text string, n int) []string {
+	runes := utf8.RuneCountInString(text)
+	if runes < n {
+		return nil
+	}
+	parts := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		// The first runes%n parts take one character more
+		size := runes / n
+		if i < runes%n {
+			size++
+		}
+		end := 0
+		for j := 0; j < size; j++ {
+			_, w := utf8.DecodeRuneInString(text[end:])
+			end += w
+		}
+		parts = append(parts, text[:end])
+		text = text[end:]
+	}
+	return parts
+}
+
+// splitToFit splits text into as few parts of at most limit bytes as
+// possible, never splitting a character.
+func splitToFit(text string, limit int) []string {
+	var parts []string
+	for text != "" {
+		end := len(text)
+		if end > limit {
+			end = limit
+		}
+		for end < len(text) && !utf8.RuneStart(text[end]) {
+			end--
+		}
+		parts = append(parts, text[:end])
+		text = text[end:]
+	}
+	return parts
+}
+
+// encodeAppendSymbol encodes one part of a message split with Structured
+// Append as a QR code of the version and level, in byte mode after a header
+// holding its position from 0, the number of symbols and the parity of the
+// message. It returns the modules of the symbol, without a quiet zone,
+// masked with mask, or with the pattern that scans best if mask is -1.
+func encodeAppendSymbol(part string, position, total int, parity byte, version int, level string, mask int) ([][]bool, error) {
+	blocks := qrBlockTable[version][qrLevelIndex[level]]
+	if len(part) > appendCapacity(version, level) {
+		return nil, fmt.Errorf("part of %d bytes does not fit a version %d-%s symbol", len(part), version, level)
+	}
+
+	// The header, then the part in byte mode with an 8-bit length below
+	// version 10 and a 16-bit one from there
+	bits := gozxing.NewEmptyBitArray()
+	bits.AppendBits(0x3, 4)
+	bits.AppendBits(position, 4)
+	bits.AppendBits(total-1, 4)
+	bits.AppendBits(int(parity), 8)
+	bits.AppendBits(0x4, 4)
+	if version >= 10 {
+		bits.AppendBits(len(part), 16)
+	} else {
+		bits.AppendBits(len(part), 8)
+	}
+	for i := 0; i < len(part); i++ {
+		bits.AppendBits(int(part[i]), 8)
+	}
+
+	// Up to four bits of terminator, zeros up to the next byte, and pad
+	// codewords to fill the symbol
+	capacity := 8 * blocks.totalDataCodewords()
+	for i := 0; i < 4 && bits.GetSize() < capacity; i++ {
+		bits.AppendBit(false)
+	}
+	for bits.GetSize()%8 != 0 {
+		bits.AppendBit(false)
+	}
+	for pad := 0xec; bits.GetSize() < capacity; pad ^= 0xec ^ 0x11 {
+		bits.AppendBits(pad, 8)
+	}
+
+	// Split the data codewords into blocks, add the error correction of each
+	// and interleave them in placement order
+	data := make([][]int, blocks.numBlocks())
+	offset := 0
+	for block := range data {
+		n := blocks.dataCodewords(block)
+		data[block] = make([]int, n+blocks.ecPerBlock)
+		for i := 0; i < n; i++ {
+			for b := 0; b < 8; b++ {
+				if bits.Get(8*(offset+i) + b) {
+					data[block][i] |= 0x80 >> b
+				}
+			}
+		}
+		if err := reedsolomon.NewReedSolomonEncoder(reedsolomon.GenericGF_QR_CODE_FIELD_256).Encode(data[block], blocks.ecPerBlock); err != nil {
+			return nil, err
+		}
+		offset += n
+	}
+	final := gozxing.NewEmptyBitArray()
+	next := make([]int, blocks.numBlocks())
+	for _, block := range blocks.codewordBlocks() {
+		final.AppendBits(data[block][next[block]], 8)
+		next[block]++
+	}
+
+	ecLevel, err := decoder.ErrorCorrectionLevel_ValueOf(level)
+	if err != nil {
+		return nil, err
+	}
+	v, err := decoder.Version_GetVersionForNumber(version)
+	if err != nil {
+		return nil, err
+	}
+	n := qrSymbolSize(version)
+	matrix := encoder.NewByteMatrix(n, n)
+	build := func(mask int) error {
+		return encoder.MatrixUtil_buildMatrix(final, ecLevel, v, mask, matrix)
+	}
+	if mask < 0 {
+		// Choose the pattern with the lowest penalty, as the standard does
+		best := math.MaxInt
+		for p := range qrMaskPatterns {
+			if err := build(p); err != nil {
+				return nil, err
+			}
+			penalty := encoder.MaskUtil_applyMaskPenaltyRule1(matrix) + encoder.MaskUtil_applyMaskPenaltyRule2(matrix) +
+				encoder.MaskUtil_applyMaskPenaltyRule3(matrix) + encoder.MaskUtil_applyMaskPenaltyRule4(matrix)
+			if penalty < best {
+				best, mask = penalty, p
+			}
+		}
+	}
+	if err := build(mask); err != nil {
+		return nil, err
+	}
+
+	modules := make([][]bool, n)
+	for y := range modules {
+		modules[y] = make([]bool, n)
+		for x := range modules[y] {
+			modules[y][x] = matrix.Get(x, y) == 1
+		}
+	}
+	return modules, nil
+}
+
+// splitAppendText splits text across symbols of level as the symbols
+// parameter asks: into that many parts, drawn in the smallest version that
+// fits them all unless version is set, or with auto into as few symbols of
+// version as it takes. It returns the parts and the version to draw them in.
+func splitAppendText(text, symbols string, version int, level string) ([]string, int, error) {
+	var parts []string
+	if symbols == "auto" {
+		if version == 0 {
+			return nil, 0, fmt.Errorf("symbols=auto needs a version to fill, such as version=10")
+		}
+		capacity := appendCapacity(version, level)
+		parts = splitToFit(text, capacity)
+		if len(parts) == 1 {
+			return nil, 0, fmt.Errorf("Text fits one version %d QR code at error-correction level %s; remove the symbols parameter", version, level)
+		}
+		if len(parts) > maxAppendSymbols {
+			return nil, 0, fmt.Errorf("Text does not fit %d version %d QR codes at error-correction level %s, which hold at most %d bytes each",
+				maxAppendSymbols, version, level, capacity)
+		}
+		return parts, version, nil
+	}
+
+	n, err := strconv.Atoi(symbols)
+	if err != nil || n < 2 || n > maxAppendSymbols {
+		return nil, 0, fmt.Errorf("Symbols must be 'auto' or a whole number between 2 and %d", maxAppendSymbols)
+	}
+	parts = splitEvenly(text, n)
+	if parts == nil {
+		return nil, 0, fmt.Errorf("Text of %d characters can not be split into %d symbols", utf8.RuneCountInString(text), n)
+	}
+	longest := 0
+	for _, part := range parts {
+		longest = max(longest, len(part))
+	}
+	if version != 0 {
+		if capacity := appendCapacity(version, level); longest > capacity {
+			return nil, 0, fmt.Errorf("Text does not fit %d version %d QR codes at error-correction level %s, which hold at most %d bytes each",
+				n, version, level, capacity)
+		}
+		return parts, version, nil
+	}
+	for version = 1; version <= 40; version++ {
+		if longest <= appendCapacity(version, level) {
+			return parts, version, nil
+		}
+	}
+	return nil, 0, fmt.Errorf("Text does not fit %d QR codes at error-correction level %s, which hold at most %d bytes each",
+		n, level, appendCapacity(40, level))
+}
+
+// serveStructuredAppend splits the text across several QR codes linked by
+// Structured Append headers, as the symbols parameter in q asks, and returns
+// them together as the output parameter asks: a ZIP archive of images, one
+// PNG sheet laying them out in reading order, or a JSON array of the images
+// in base64.
+func serveStructuredAppend(w http.ResponseWriter, q url.Values, text, level string, version, mask, margin int, opts renderOptions, verify bool) {
+	output := q.Get("output")
+	if output == "" {
+		output = "zip" // default output
+	}
+	if output != "zip" && output != "sheet" && output != "json" {
+		http.Error(w, "Output must be 'zip', 'sheet' or 'json'", http.StatusBadRequest)
+		return
+	}
+	if output == "sheet" && opts.format != "png" {
+		http.Error(w, "The sheet is a PNG image; use output=zip or output=json for SVG or PDF codes", http.StatusBadRequest)
+		return
+	}
+
+	parts, version, err := splitAppendText(text, q.Get("symbols"), version, level)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	parity := appendParity(text)
+	symbols := make([][][]bool, len(parts))
+	for i, part := range parts {
+		symbol, err := encodeAppendSymbol(part, i, len(parts), parity, version, level, mask)
+		if err != nil {
+			http.Error(w, "Failed to generate QR code", http.StatusInternalServerError)
+			return
+		}
+		symbols[i] = addQuietZone(symbol, margin)
+
+		// Each symbol reads back as its own part of the text
+		if verify {
+			if err := verifyCode(w, symbols[i], opts, "qr", part, symbologyOptions{}); err != nil {
+				http.Error(w, fmt.Sprintf("Symbol %d: %v", i+1, err), http.StatusUnprocessableEntity)
+				return
+			}
+		}
+	}
+
+	if output == "sheet" {
+		// Lay the symbols out in a grid as close to square as possible
+		cols := int(math.Ceil(math.Sqrt(float64(len(symbols)))))
+		rows := (len(symbols) + cols - 1) / cols
+		var sheet *image.NRGBA
+		for i, symbol := range symbols {
+			img, err := rasterize(symbol, opts)
+			if err != nil {
+				http.Error(w, "Failed to encode image", http.StatusInternalServerError)
+				return
+			}
+			cell := img.Bounds().Size()
+			if sheet == nil {
+				sheet = image.NewNRGBA(image.Rect(0, 0, cols*cell.X, rows*cell.Y))
+				draw.Draw(sheet, sheet.Bounds(), image.NewUniform(opts.bg), image.Point{}, draw.Src)
+			}
+			at := image.Pt(i%cols*cell.X, i/cols*cell.Y)
+			draw.Draw(sheet, image.Rectangle{at, at.Add(cell)}, img, image.Point{}, draw.Src)
+		}
+		w.Header().Set("Content-Type", "image/png")
+		if err := png.Encode(w, sheet); err != nil {
+			http.Error(w, "Failed to encode image", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	images := make([][]byte, len(symbols))
+	for i, symbol := range symbols {
+		var buf bytes.Buffer
+		if err := renderCode(&buf, symbol, opts); err != nil {
+			http.Error(w, "Failed to encode image", http.StatusInternalServerError)
+			return
+		}
+		images[i] = buf.Bytes()
+	}
+
+	if output == "json" {
+		encoded := make([]string, len(images))
+		for i, img := range images {
+			encoded[i] = base64.StdEncoding.EncodeToString(img)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(encoded)
+		return
+	}
+
+	// Name the files so that they sort in reading order
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for i, img := range images {
+		f, err := zw.Create(fmt.Sprintf("qr-%02d-of-%02d.%s", i+1, len(images), opts.format))
+		if err == nil {
+			_, err = f.Write(img)
+		}
+		if err != nil {
+			http.Error(w, "Failed to build ZIP archive", http.StatusInternalServerError)
+			return
+		}
+	}
+	if err := zw.Close(); err != nil {
+		http.Error(w, "Failed to build ZIP archive", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", `attachment; filename="qr-codes.zip"`)
+	w.Write(buf.Bytes())
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// A manifest too long for one QR code at level M, with a few multi-byte
+// characters
+var appendText = strings.Repeat("Manifest line 0123456789, Zürich; ", 80)
+
+// appendRequest requests the symbols of appendText with the query.
+func appendRequest(t *testing.T, query string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest("GET", "/qr?text="+url.QueryEscape(appendText)+"&"+query, nil)
+	rr := httptest.NewRecorder()
+	qrHandler(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status 200 for %s, got %d: %s", query, rr.Code, rr.Body.String())
+	}
+	return rr
+}
+
+// decodeAppend POSTs images to /decode and returns its response.
+func decodeAppend(t *testing.T, images ...[]byte) (codes []decodedCode, messages []appendedMessage) {
+	t.Helper()
+	resetRateLimiter()
+	rr := httptest.NewRecorder()
+	decodeHandler(rr, decodeRequest(t, images...))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var body struct {
+		Codes    []decodedCode     `json:"codes"`
+		Messages []appendedMessage `json:"messages"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &body); err != nil {
+		t.Fatalf("invalid JSON %s: %v", rr.Body.String(), err)
+	}
+	return body.Codes, body.Messages
+}
+
+func TestStructuredAppend_Sheet(t *testing.T) {
+	isolateRateLimiter(t)
+
+	for _, query := range []string{"symbols=4", "symbols=16&size=300", "symbols=auto&version=10", "symbols=3&ecc=H&mask=4"} {
+		resetRateLimiter() // Reset rate limiter before each request
+
+		rr := appendRequest(t, query+"&output=sheet")
+		if ct := rr.Header().Get("Content-Type"); ct != "image/png" {
+			t.Fatalf("expected Content-Type image/png for %s, got %s", query, ct)
+		}
+
+		// Every symbol on the sheet is found, and they join up into the text
+		codes, messages := decodeAppend(t, rr.Body.Bytes())
+		if len(messages) != 1 || messages[0].Text != appendText || messages[0].Symbols != len(codes) {
+			t.Fatalf("expected the text from %d symbols for %s, got %+v", len(codes), query, messages)
+		}
+		if messages[0].Parity != int(appendParity(appendText)) {
+			t.Fatalf("expected parity %d for %s, got %d", appendParity(appendText), query, messages[0].Parity)
+		}
+		seen := map[int]bool{}
+		for _, code := range codes {
+			if code.Append == nil || code.Append.Total != len(codes) {
+				t.Fatalf("expected symbols of %d for %s, got %+v", len(codes), query, code)
+			}
+			seen[code.Append.Position] = true
+		}
+		if len(seen) != len(codes) {
+			t.Fatalf("expected %d different positions for %s, got %v", len(codes), query, seen)
+		}
+	}
+}
+
+func TestStructuredAppend_ZipAndJSON(t *testing.T) {
+	isolateRateLimiter(t)
+
+	rr := appendRequest(t, "symbols=3&version=25&mask=6")
+	if ct := rr.Header().Get("Content-Type"); ct != "application/zip" {
+		t.Fatalf("expected Content-Type application/zip, got %s", ct)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(rr.Body.Bytes()), int64(rr.Body.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var images [][]byte
+	for i, f := range zr.File {
+		if want := []string{"qr-01-of-03.png", "qr-02-of-03.png", "qr-03-of-03.png"}[i]; f.Name != want {
+			t.Fatalf("expected file %s, got %s", want, f.Name)
+		}
+		r, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, _ := io.ReadAll(r)
+		r.Close()
+		if _, mask := qrFormatInfo(t, data); mask != 6 {
+			t.Fatalf("expected mask 6 in %s, got %d", f.Name, mask)
+		}
+		images = append(images, data)
+	}
+
+	// The images are reassembled in any order
+	codes, messages := decodeAppend(t, images[2], images[0], images[1])
+	if len(messages) != 1 || messages[0].Text != appendText {
+		t.Fatalf("expected the text, got %+v", messages)
+	}
+	for i, code := range codes {
+		if code.Version != 25 || code.Image != i+1 || code.Append.Position != []int{3, 1, 2}[i] {
+			t.Fatalf("expected symbol %d of image %d in version 25, got %+v", []int{3, 1, 2}[i], i+1, code)
+		}
+	}
+
+	// JSON output holds the same images in base64
+	resetRateLimiter()
+	rr = appendRequest(t, "symbols=3&version=25&mask=6&output=json")
+	var encoded []string
+	if err := json.Unmarshal(rr.Body.Bytes(), &encoded); err != nil {
+		t.Fatalf("invalid JSON %s: %v", rr.Body.String(), err)
+	}
+	if len(encoded) != 3 {
+		t.Fatalf("expected 3 images, got %d", len(encoded))
+	}
+	for i, s := range encoded {
+		if data, err := base64.StdEncoding.DecodeString(s); err != nil || !bytes.Equal(data, images[i]) {
+			t.Fatalf("expected image %d to match the ZIP file", i+1)
+		}
+	}
+}
+
+func TestStructuredAppend_Invalid(t *testing.T) {
+	isolateRateLimiter(t)
+
+	cases := []struct {
+		query string
+		msg   string
+	}{
+		{"text=hello&symbols=1", "Symbols must be 'auto' or a whole number between 2 and 16"},
+		{"text=hello&symbols=17", "Symbols must be 'auto' or a whole number between 2 and 16"},
+		{"text=hello&symbols=6", "Text of 5 characters can not be split into 6 symbols"},
+		{"text=hello&symbols=auto", "symbols=auto needs a version to fill"},
+		{"text=hello&symbols=auto&version=5", "Text fits one version 5 QR code at error-correction level M; remove the symbols parameter"},
+		{"text=" + strings.Repeat("a", 300) + "&symbols=auto&version=1",
+			"Text does not fit 16 version 1 QR codes at error-correction level M, which hold at most 12 bytes each"},
+		{"text=" + strings.Repeat("a", 300) + "&symbols=2&version=5", "Text does not fit 2 version 5 QR codes"},
+		{"text=hello&symbols=2&output=pdf", "Output must be 'zip', 'sheet' or 'json'"},
+		{"text=hello&symbols=2&output=sheet&format=svg", "The sheet is a PNG image"},
+		{"text=hello&symbols=2&base64=true", "base64 can not be combined with symbols"},
+		{"text=hello&symbols=2&type=datamatrix", "Structured Append is only supported for QR codes"},
+	}
+	for _, tc := range cases {
+		resetRateLimiter() // Reset rate limiter before each request
+
+		req := httptest.NewRequest("GET", "/qr?"+tc.query, nil)
+		rr := httptest.NewRecorder()
+		qrHandler(rr, req)
+		if rr.Code != http.StatusBadRequest {
+			t.Fatalf("expected status 400 for %s, got %d", tc.query, rr.Code)
+		}
+		if !strings.Contains(rr.Body.String(), tc.msg) {
+			t.Fatalf("expected error %q, got %s", tc.msg, rr.Body.String())
+		}
+	}
+}
+
+func TestSplitAppendText(t *testing.T) {
+	// Parts are split by characters, never inside one
+	parts := splitEvenly("aéiöu", 2)
+	if len(parts) != 2 || parts[0] != "aéi" || parts[1] != "öu" {
+		t.Fatalf("expected [aéi öu], got %q", parts)
+	}
+	parts = splitToFit("aéiöu", 3)
+	if len(parts) != 3 || parts[0] != "aé" || parts[1] != "iö" || parts[2] != "u" {
+		t.Fatalf("expected [aé iö u], got %q", parts)
+	}
+
+	// The version fits the longest part, with room for the header
+	_, version, err := splitAppendText(strings.Repeat("a", 26), "2", 0, "M")
+	if err != nil || version != 2 {
+		t.Fatalf("expected version 2, got %d: %v", version, err)
+	}
+}
+
+func TestReassembleMessages(t *testing.T) {
+	symbol := func(text string, position, total int, parity byte) decodedCode {
+		return decodedCode{Text: text, Append: &decodedAppend{position, total, int(parity)}}
+	}
+	parity := appendParity("Hello, World")
+	codes := []decodedCode{
+		symbol("World", 3, 3, parity),
+		{Text: "plain"},
+		symbol("Hello", 1, 3, parity),
+		symbol("x", 2, 4, 7),
+		symbol(", ", 2, 3, parity),
+		symbol(", ", 2, 3, parity),
+		symbol("a", 1, 2, 0),
+		symbol("b", 2, 2, 0),
+		// Positions outside the number of symbols are left out
+		symbol("c", 3, 2, 0),
+		symbol("d", 0, 2, 0),
+		symbol("e", 0, 0, 5),
+	}
+	messages := reassembleMessages(codes)
+	want := []appendedMessage{
+		{Text: "Hello, World", Symbols: 3, Parity: int(parity)},
+		{Symbols: 4, Parity: 7, Error: "Symbols 1, 3, 4 of 4 are missing"},
+		{Symbols: 2, Parity: 0, Error: "The reassembled text does not match the parity of its symbols"},
+	}
+	if len(messages) != len(want) {
+		t.Fatalf("expected %+v, got %+v", want, messages)
+	}
+	for i := range want {
+		if messages[i] != want[i] {
+			t.Fatalf("expected %+v, got %+v", want[i], messages[i])
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"image/png"
+	"io"
+	"math"
+	"strconv"
+)
+
+// svgNumber formats a coordinate without trailing zeros.
+func svgNumber(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+// writeSVG renders a module grid as an SVG image of opts.width x opts.height
+// pixels. 2D codes keep square modules and are centered in the canvas, while
+// 1D codes (a single row of modules) fill the whole canvas, which grows to
+// fit their caption if any.
+func writeSVG(w io.Writer, modules [][]bool, opts renderOptions) error {
+	rows := len(modules)
+	cols := 0
+	if rows > 0 {
+		cols = len(modules[0])
+	}
+	if rows == 0 || cols == 0 {
+		return fmt.Errorf("empty module grid")
+	}
+
+	// Work out the viewBox in module units
+	width, height := opts.width, opts.height
+	var vbX, vbY, vbW, vbH, barHeight float64
+	if rows == 1 {
+		vbW = float64(cols)
+		vbH = vbW * float64(height) / float64(width)
+		barHeight = vbH
+		if opts.caption != nil {
+			height += int(math.Round(opts.caption.height * float64(width) / float64(cols)))
+			vbH = vbW * float64(height) / float64(width)
+		}
+	} else {
+		if cols*height <= rows*width {
+			vbH = float64(rows)
+			vbW = float64(rows*width) / float64(height)
+		} else {
+			vbW = float64(cols)
+			vbH = float64(cols*height) / float64(width)
+		}
+		vbX = (float64(cols) - vbW) / 2
+		vbY = (float64(rows) - vbH) / 2
+		barHeight = 1
+	}
+
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "<?xml version=\"1.0\" encoding=\"UTF-8\"?>\n")
+	fmt.Fprintf(bw, "<svg xmlns=\"http://www.w3.org/2000/svg\" version=\"1.1\" width=\"%d\" height=\"%d\" viewBox=\"%s %s %s %s\" shape-rendering=\"crispEdges\">\n",
+		width, height, svgNumber(vbX), svgNumber(vbY), svgNumber(vbW), svgNumber(vbH))
+	if opts.bg.A != 0 {
+		fmt.Fprintf(bw, "<rect x=\"%s\" y=\"%s\" width=\"%s\" height=\"%s\" fill=\"%s\"/>\n",
+			svgNumber(vbX), svgNumber(vbY), svgNumber(vbW), svgNumber(vbH), hexColor(opts.bg))
+	}
+
+	// Dark modules are filled with fg, or with a gradient spanning them
+	fill := hexColor(opts.fg)
+	if opts.gradient != nil {
+		bounds := darkBounds(modules)
+		fmt.Fprintf(bw, "<defs><linearGradient id=\"fg\" gradientUnits=\"userSpaceOnUse\" x1=\"%d\" y1=\"0\" x2=\"%d\" y2=\"0\">", bounds.Min.X, bounds.Max.X)
+		fmt.Fprintf(bw, "<stop offset=\"0\" stop-color=\"%s\"/><stop offset=\"1\" stop-color=\"%s\"/></linearGradient></defs>\n",
+			hexColor(opts.gradient[0]), hexColor(opts.gradient[1]))
+		fill = "url(#fg)"
+	}
+
+	// Draw each horizontal run of dark modules as one path segment
+	fmt.Fprintf(bw, "<path fill=\"%s\" d=\"", fill)
+	darkRuns(modules, func(y, start, end int) {
+		fmt.Fprintf(bw, "M%d %sh%dv%sh-%dz", start, svgNumber(float64(y)*barHeight), end-start, svgNumber(barHeight), end-start)
+	})
+	fmt.Fprintf(bw, "\"/>\n")
+
+	// Draw the caption below the bars
+	if c := opts.caption; c != nil {
+		fmt.Fprintf(bw, "<path fill=\"%s\" d=\"", hexColor(opts.fg))
+		for _, r := range c.rects {
+			fmt.Fprintf(bw, "M%s %sh%sv%sh-%sz", svgNumber(r.x), svgNumber(barHeight+r.y), svgNumber(r.w), svgNumber(r.h), svgNumber(r.w))
+		}
+		fmt.Fprintf(bw, "\"/>\n")
+	}
+
+	// Draw the Swiss cross over the modules
+	if c := opts.cross; c != nil {
+		for _, l := range c.layers() {
+			fill := opts.bg
+			if l.dark {
+				fill = opts.fg
+			}
+			fmt.Fprintf(bw, "<rect x=\"%s\" y=\"%s\" width=\"%s\" height=\"%s\" fill=\"%s\"/>\n",
+				svgNumber(l.x), svgNumber(l.y), svgNumber(l.w), svgNumber(l.h), hexColor(fill))
+		}
+	}
+
+	// Composite the logo on its plate, embedded as a PNG data URI
+	if l := opts.logo; l != nil {
+		if l.bg.A != 0 {
+			fmt.Fprintf(bw, "<rect x=\"%d\" y=\"%d\" width=\"%d\" height=\"%d\" fill=\"%s\"/>\n",
+				l.plate.Min.X, l.plate.Min.Y, l.plate.Dx(), l.plate.Dy(), hexColor(l.bg))
+		}
+		var logo bytes.Buffer
+		if err := png.Encode(&logo, l.img); err != nil {
+			return err
+		}
+		fmt.Fprintf(bw, "<image x=\"%s\" y=\"%s\" width=\"%s\" height=\"%s\" preserveAspectRatio=\"none\" href=\"data:image/png;base64,%s\"/>\n",
+			svgNumber(l.x), svgNumber(l.y), svgNumber(l.w), svgNumber(l.h), base64.StdEncoding.EncodeToString(logo.Bytes()))
+	}
+	fmt.Fprintf(bw, "</svg>\n")
+	return bw.Flush()
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	qrcode "github.com/skip2/go-qrcode"
+)
+
+// svgDoc captures the parts of a rendered SVG the tests inspect.
+type svgDoc struct {
+	Width   string `xml:"width,attr"`
+	Height  string `xml:"height,attr"`
+	ViewBox string `xml:"viewBox,attr"`
+	Rects   []struct {
+		Fill string `xml:"fill,attr"`
+	} `xml:"rect"`
+	Paths []struct {
+		D string `xml:"d,attr"`
+	} `xml:"path"`
+}
+
+func parseSVG(t *testing.T, data []byte) svgDoc {
+	t.Helper()
+	var doc svgDoc
+	if err := xml.Unmarshal(data, &doc); err != nil {
+		t.Fatalf("invalid SVG: %v", err)
+	}
+	return doc
+}
+
+// svgModules rebuilds the module grid from the "M x y h w v 1 h -w z" runs
+// written by writeSVG for 2D codes.
+func svgModules(t *testing.T, d string, cols, rows int) [][]bool {
+	t.Helper()
+	modules := make([][]bool, rows)
+	for y := range modules {
+		modules[y] = make([]bool, cols)
+	}
+	for _, seg := range strings.Split(d, "z") {
+		if seg == "" {
+			continue
+		}
+		var x, y, w, h, back int
+		if _, err := fmt.Sscanf(seg, "M%d %dh%dv%dh-%d", &x, &y, &w, &h, &back); err != nil {
+			t.Fatalf("unexpected path segment %q: %v", seg, err)
+		}
+		for i := x; i < x+w; i++ {
+			modules[y][i] = true
+		}
+	}
+	return modules
+}
+
+func TestQRHandler_SVG(t *testing.T) {
+	isolateRateLimiter(t)
+
+	req := httptest.NewRequest("GET", "/qr?text=hello&format=svg&size=300", nil)
+	rr := httptest.NewRecorder()
+	qrHandler(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", rr.Code)
+	}
+	if ct := rr.Header().Get("Content-Type"); ct != "image/svg+xml" {
+		t.Fatalf("expected Content-Type image/svg+xml, got %s", ct)
+	}
+
+	doc := parseSVG(t, rr.Body.Bytes())
+	if doc.Width != "300" || doc.Height != "300" {
+		t.Fatalf("expected 300x300 SVG, got %sx%s", doc.Width, doc.Height)
+	}
+
+	// The drawn modules must match the QR bitmap, quiet zone included
+	qr, err := qrcode.New("hello", qrcode.Medium)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := qr.Bitmap()
+	n := len(want)
+	if doc.ViewBox != fmt.Sprintf("0 0 %d %d", n, n) {
+		t.Fatalf("expected viewBox '0 0 %d %d', got %q", n, n, doc.ViewBox)
+	}
+	if len(doc.Paths) != 1 {
+		t.Fatalf("expected one path, got %d", len(doc.Paths))
+	}
+	got := svgModules(t, doc.Paths[0].D, n, n)
+	for y := range want {
+		for x := range want[y] {
+			if got[y][x] != want[y][x] {
+				t.Fatalf("module (%d,%d) differs from QR bitmap", x, y)
+			}
+		}
+	}
+}
+
+func TestQRHandler_SVG_Rectangle(t *testing.T) {
+	isolateRateLimiter(t)
+
+	req := httptest.NewRequest("GET", "/qr?text=hello&format=svg&shape=rectangle&size=100", nil)
+	rr := httptest.NewRecorder()
+	qrHandler(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", rr.Code)
+	}
+	doc := parseSVG(t, rr.Body.Bytes())
+	if doc.Width != "400" || doc.Height != "100" {
+		t.Fatalf("expected 400x100 SVG, got %sx%s", doc.Width, doc.Height)
+	}
+
+	// The square symbol is centered in a viewBox four times as wide
+	var x, y, w, h float64
+	if _, err := fmt.Sscanf(doc.ViewBox, "%g %g %g %g", &x, &y, &w, &h); err != nil {
+		t.Fatalf("invalid viewBox %q: %v", doc.ViewBox, err)
+	}
+	if w != 4*h || y != 0 || x != -(w-h)/2 {
+		t.Fatalf("unexpected viewBox for rectangle shape: %q", doc.ViewBox)
+	}
+}
+
+func TestQRHandler_SVG_Barcode(t *testing.T) {
+	isolateRateLimiter(t)
+
+	req := httptest.NewRequest("GET", "/qr?text=1234567890&type=barcode&format=svg", nil)
+	rr := httptest.NewRecorder()
+	qrHandler(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", rr.Code)
+	}
+	if ct := rr.Header().Get("Content-Type"); ct != "image/svg+xml" {
+		t.Fatalf("expected Content-Type image/svg+xml, got %s", ct)
+	}
+	doc := parseSVG(t, rr.Body.Bytes())
+	if len(doc.Paths) != 1 || doc.Paths[0].D == "" {
+		t.Fatal("expected bars to be drawn")
+	}
+}
+
+func TestQRHandler_Format_Invalid(t *testing.T) {
+	isolateRateLimiter(t)
+
+	req := httptest.NewRequest("GET", "/qr?text=hello&format=gif", nil)
+	rr := httptest.NewRecorder()
+	qrHandler(rr, req)
+
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected status 400, got %d", rr.Code)
+	}
+	if !strings.Contains(rr.Body.String(), "Format must be") {
+		t.Fatalf("expected error about format, got %s", rr.Body.String())
+	}
+}
+
+func TestQRHandler_Cache_DifferentFormats(t *testing.T) {
+	isolateRateLimiter(t)
+
+	// Test that PNG and SVG output get separate cache entries
+	req1 := httptest.NewRequest("GET", "/qr?text=testcache&format=png", nil)
+	rr1 := httptest.NewRecorder()
+	qrHandler(rr1, req1)
+
+	req2 := httptest.NewRequest("GET", "/qr?text=testcache&format=svg", nil)
+	rr2 := httptest.NewRecorder()
+	qrHandler(rr2, req2)
+
+	if bytes.Equal(rr1.Body.Bytes(), rr2.Body.Bytes()) {
+		t.Fatal("different formats should not have same cache entry")
+	}
+	if ct := rr2.Header().Get("Content-Type"); ct != "image/svg+xml" {
+		t.Fatalf("expected Content-Type image/svg+xml, got %s", ct)
+	}
+
+	// A cache hit must keep the SVG content type
+	req3 := httptest.NewRequest("GET", "/qr?text=testcache&format=svg", nil)
+	rr3 := httptest.NewRecorder()
+	qrHandler(rr3, req3)
+
+	if ct := rr3.Header().Get("Content-Type"); ct != "image/svg+xml" {
+		t.Fatalf("expected cached Content-Type image/svg+xml, got %s", ct)
+	}
+	if !bytes.Equal(rr2.Body.Bytes(), rr3.Body.Bytes()) {
+		t.Fatal("cached response differs from original")
+	}
+}
+
+func TestBarcodeHandler_SVG(t *testing.T) {
+	isolateRateLimiter(t)
+
+	for _, shape := range []string{"rectangle", "square"} {
+		req := httptest.NewRequest("GET", "/barcode?text=1234567890&format=svg&size=100&shape="+shape, nil)
+		rr := httptest.NewRecorder()
+		barcodeHandler(rr, req)
+
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected status 200 for %s, got %d", shape, rr.Code)
+		}
+		if ct := rr.Header().Get("Content-Type"); ct != "image/svg+xml" {
+			t.Fatalf("expected Content-Type image/svg+xml, got %s", ct)
+		}
+
+		doc := parseSVG(t, rr.Body.Bytes())
+		wantWidth := "100"
+		if shape == "rectangle" {
+			wantWidth = "400"
+		}
+		if doc.Width != wantWidth || doc.Height != "100" {
+			t.Fatalf("expected %sx100 SVG for %s, got %sx%s", wantWidth, shape, doc.Width, doc.Height)
+		}
+
+		// Bars span the full viewBox height
+		var x, y, w, h float64
+		if _, err := fmt.Sscanf(doc.ViewBox, "%g %g %g %g", &x, &y, &w, &h); err != nil {
+			t.Fatalf("invalid viewBox %q: %v", doc.ViewBox, err)
+		}
+		if !strings.Contains(doc.Paths[0].D, fmt.Sprintf("v%s", svgNumber(h))) {
+			t.Fatalf("expected bars of height %g, got %q", h, doc.Paths[0].D)
+		}
+	}
+}
+
+func TestBarcodeHandler_Format_Invalid(t *testing.T) {
+	isolateRateLimiter(t)
+
+	req := httptest.NewRequest("GET", "/barcode?text=1234567890&format=gif", nil)
+	rr := httptest.NewRecorder()
+	barcodeHandler(rr, req)
+
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected status 400, got %d", rr.Code)
+	}
+}
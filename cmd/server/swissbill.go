@@ -0,0 +1,452 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"image/color"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	qrcode "github.com/skip2/go-qrcode"
+)
+
+// Dimensions of the Swiss QR code on a QR-bill, in millimetres
+const (
+	swissCodeMM      = 46 // Side of the symbol, without its quiet zone
+	swissQuietZoneMM = 5
+	swissCrossMM     = 7
+)
+
+// Largest QR code version and payload a QR-bill may use
+const (
+	maxSwissVersion = 25
+	maxSwissPayload = 997
+)
+
+// IBAN lengths of the countries QR-bills are issued in
+var swissIBANLengths = map[string]int{"CH": 21, "LI": 21}
+
+// Parameters of /qr that QR-bills do not take, as the standard prescribes
+// how their codes are drawn
+var swissFixedParams = []string{"shape", "margin", "fg", "bg", "gradient", "width_mm", "height_mm", "version", "mask"}
+
+// Parameters of /qr that /qr/swiss does not implement, which are rejected
+// rather than ignored
+var swissUnsupportedParams = []string{"verify", "symbols", "output", "hrt", "hrt_text"}
+
+// swissAddress is a structured creditor or debtor address.
+type swissAddress struct {
+	name, street, building, postalCode, town, country string
+}
+
+// lines returns the address as printed on the payment slip, where only
+// foreign postal codes carry their country code.
+func (a *swissAddress) lines() []string {
+	lines := []string{a.name}
+	if street := strings.TrimSpace(a.street + " " + a.building); street != "" {
+		lines = append(lines, street)
+	}
+	town := a.postalCode + " " + a.town
+	if a.country != "CH" && a.country != "LI" {
+		town = a.country + "-" + town
+	}
+	return append(lines, town)
+}
+
+// swissBill holds the fields of a QR-bill payment part.
+type swissBill struct {
+	iban     string
+	creditor *swissAddress
+	debtor   *swissAddress // Nil when the payer fills it in
+	amount   int64         // In cents; 0 when the payer fills it in
+	currency string
+
+	// Reference type, QRR, SCOR or NON, and the reference
+	refType, reference string
+
+	// Unstructured message and structured bill information
+	message, billInfo string
+}
+
+// isSwissText reports whether s only has characters of the Latin character
+// set QR-bills allow: Basic Latin, Latin-1 Supplement and Latin Extended-A,
+// the Romanian Ș ș Ț ț and the euro sign.
+func isSwissText(s string) bool {
+	for _, r := range s {
+		switch {
+		case r >= 0x20 && r <= 0x7e, r >= 0xa0 && r <= 0x17f, r >= 0x218 && r <= 0x21b, r == '€':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// checkSwissText checks the length and character set of a text parameter.
+func checkSwissText(param, value string, max int) error {
+	if n := utf8.RuneCountInString(value); n > max {
+		return fmt.Errorf("The %s can be at most %d characters, got %d", param, max, n)
+	}
+	if !isSwissText(value) {
+		return fmt.Errorf("The %s can only contain Latin characters, without line breaks", param)
+	}
+	return nil
+}
+
+// parseSwissAddress reads the name, street, building, postal_code, town and
+// country parameters of the creditor or debtor, prefixed with its role. An
+// optional address with none of them given is nil.
+func parseSwissAddress(q url.Values, role string, optional bool) (*swissAddress, error) {
+	a := &swissAddress{
+		name:       q.Get(role + "_name"),
+		street:     q.Get(role + "_street"),
+		building:   q.Get(role + "_building"),
+		postalCode: q.Get(role + "_postal_code"),
+		town:       q.Get(role + "_town"),
+		country:    strings.ToUpper(q.Get(role + "_country")),
+	}
+	if optional && *a == (swissAddress{}) {
+		return nil, nil
+	}
+	for _, field := range []struct {
+		param, value string
+		max          int
+		required     bool
+	}{
+		{"name", a.name, 70, true},
+		{"street", a.street, 70, false},
+		{"building", a.building, 16, false},
+		{"postal_code", a.postalCode, 16, true},
+		{"town", a.town, 35, true},
+	} {
+		param := role + "_" + field.param
+		if field.required && field.value == "" {
+			return nil, fmt.Errorf("Please provide a '%s' parameter", param)
+		}
+		if err := checkSwissText(param, field.value, field.max); err != nil {
+			return nil, err
+		}
+	}
+	if len(a.country) != 2 || strings.Trim(a.country, "ABCDEFGHIJKLMNOPQRSTUVWXYZ") != "" {
+		return nil, fmt.Errorf("%s_country must be a two-letter ISO 3166 country code, such as CH", role)
+	}
+	return a, nil
+}
+
+// isQRIBAN reports whether iban is a QR-IBAN, whose institution ID lies in
+// the range reserved for payments with a QR reference.
+func isQRIBAN(iban string) bool {
+	iid := iban[4:9]
+	if strings.Trim(iid, "0123456789") != "" {
+		return false
+	}
+	return atoi(iid) >= 30000 && atoi(iid) <= 31999
+}
+
+// qrrCheckDigit computes the modulo 10 recursive check digit of the first 26
+// digits of a QR reference.
+func qrrCheckDigit(digits string) int {
+	table := [10]int{0, 9, 4, 6, 8, 2, 7, 1, 3, 5}
+	carry := 0
+	for _, d := range digits {
+		carry = table[(carry+int(d-'0'))%10]
+	}
+	return (10 - carry) % 10
+}
+
+// parseSwissReference picks the reference type the IBAN requires: QR-IBANs
+// are paid with a 27-digit QR reference (QRR), other IBANs with an ISO 11649
+// creditor reference (SCOR) or none (NON).
+func parseSwissReference(iban, reference string) (string, string, error) {
+	reference = strings.ToUpper(strings.ReplaceAll(reference, " ", ""))
+	isQRR := len(reference) == 27 && strings.Trim(reference, "0123456789") == ""
+	if isQRIBAN(iban) {
+		if !isQRR {
+			return "", "", fmt.Errorf("QR-IBANs must be paid with a 27-digit QR reference")
+		}
+		if check := qrrCheckDigit(reference[:26]); int(reference[26]-'0') != check {
+			return "", "", fmt.Errorf("Invalid QR reference check digit %c, expected %d", reference[26], check)
+		}
+		return "QRR", reference, nil
+	}
+	switch {
+	case reference == "":
+		return "NON", "", nil
+	case isQRR:
+		return "", "", fmt.Errorf("QR references can only be used with a QR-IBAN")
+	case !creditorRefPattern.MatchString(reference) || mod97(reference) != 1:
+		return "", "", fmt.Errorf("Reference must be an ISO 11649 creditor reference, such as RF18539007547034")
+	}
+	return "SCOR", reference, nil
+}
+
+// parseSwissBill reads a QR-bill from the iban, creditor_*, amount,
+// currency, debtor_*, reference, message and bill_info parameters.
+func parseSwissBill(q url.Values) (*swissBill, error) {
+	b := &swissBill{iban: normalizeIBAN(q.Get("iban")), currency: q.Get("currency")}
+	if b.iban == "" {
+		return nil, fmt.Errorf("Please provide an 'iban' parameter")
+	}
+	if err := validateIBAN(b.iban, swissIBANLengths); err != nil {
+		return nil, err
+	}
+
+	var err error
+	if b.creditor, err = parseSwissAddress(q, "creditor", false); err != nil {
+		return nil, err
+	}
+	if b.debtor, err = parseSwissAddress(q, "debtor", true); err != nil {
+		return nil, err
+	}
+
+	if s := q.Get("amount"); s != "" {
+		if b.amount, err = parseAmount(s); err != nil {
+			return nil, err
+		}
+	}
+	if b.currency == "" {
+		b.currency = "CHF" // default currency
+	}
+	if b.currency != "CHF" && b.currency != "EUR" {
+		return nil, fmt.Errorf("Currency must be 'CHF' or 'EUR'")
+	}
+
+	if b.refType, b.reference, err = parseSwissReference(b.iban, q.Get("reference")); err != nil {
+		return nil, err
+	}
+
+	// The message and bill information share the space of the additional
+	// information
+	b.message, b.billInfo = q.Get("message"), q.Get("bill_info")
+	if err := checkSwissText("message", b.message, 140); err != nil {
+		return nil, err
+	}
+	if err := checkSwissText("bill_info", b.billInfo, 140); err != nil {
+		return nil, err
+	}
+	if b.billInfo != "" && !strings.HasPrefix(b.billInfo, "//") {
+		return nil, fmt.Errorf("bill_info must start with '//', such as //S1/10/10201409")
+	}
+	if n := utf8.RuneCountInString(b.message + b.billInfo); n > 140 {
+		return nil, fmt.Errorf("The message and bill_info together can be at most 140 characters, got %d", n)
+	}
+
+	if n := utf8.RuneCountInString(b.payload()); n > maxSwissPayload {
+		return nil, fmt.Errorf("QR-bill payloads can be at most %d characters, got %d", maxSwissPayload, n)
+	}
+	return b, nil
+}
+
+// formattedAmount writes the amount with two decimals, or "" if the payer
+// fills it in.
+func (b *swissBill) formattedAmount() string {
+	if b.amount == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%d.%02d", b.amount/100, b.amount%100)
+}
+
+// payload builds the SPC payload: version 0200 of the Swiss Payments Code
+// in UTF-8, with structured addresses and no ultimate creditor.
+func (b *swissBill) payload() string {
+	address := func(a *swissAddress) []string {
+		if a == nil {
+			return make([]string, 7)
+		}
+		return []string{"S", a.name, a.street, a.building, a.postalCode, a.town, a.country}
+	}
+	lines := []string{"SPC", "0200", "1", b.iban}
+	lines = append(lines, address(b.creditor)...)
+	lines = append(lines, address(nil)...) // Ultimate creditor
+	lines = append(lines, b.formattedAmount(), b.currency)
+	lines = append(lines, address(b.debtor)...)
+	lines = append(lines, b.refType, b.reference, b.message, "EPD")
+	if b.billInfo != "" {
+		lines = append(lines, b.billInfo)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// crossLayer is one rectangle of the Swiss cross, in module units, painted
+// in the dark or the light color.
+type crossLayer struct {
+	x, y, w, h float64
+	dark       bool
+}
+
+// swissCross is the Swiss cross drawn over the middle of a QR-bill code, at
+// x, y in module units and size modules wide.
+type swissCross struct {
+	x, y, size float64
+}
+
+// newSwissCross centers a 7 mm cross on a 46 mm symbol of n modules with a
+// quiet zone of margin modules.
+func newSwissCross(n, margin int) *swissCross {
+	size := swissCrossMM * float64(n) / swissCodeMM
+	offset := float64(margin) + (float64(n)-size)/2
+	return &swissCross{x: offset, y: offset, size: size}
+}
+
+// layers returns the rectangles of the cross in painting order: a light
+// border, a dark square and the two light bars of the cross, which have the
+// proportions of the Swiss flag.
+func (c *swissCross) layers() []crossLayer {
+	border := c.size / 14
+	square := c.size - 2*border
+	bar, length := square*6/32, square*20/32
+	mid := c.size / 2
+	return []crossLayer{
+		{c.x, c.y, c.size, c.size, false},
+		{c.x + border, c.y + border, square, square, true},
+		{c.x + mid - length/2, c.y + mid - bar/2, length, bar, false},
+		{c.x + mid - bar/2, c.y + mid - length/2, bar, length, false},
+	}
+}
+
+// swissBillHandler draws the Swiss QR code of a QR-bill, with the Swiss
+// cross over its middle, or with slip=true the whole payment slip of
+// receipt and payment part as a PDF or SVG.
+func swissBillHandler(w http.ResponseWriter, r *http.Request) {
+	// Check rate limit per IP
+	if !ipRateLimiter.Allow(getIP(r)) {
+		http.Error(w, "Rate limit exceeded", http.StatusTooManyRequests)
+		return
+	}
+
+	q := r.URL.Query()
+	if err := checkPayloadParams(q); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	for _, param := range swissFixedParams {
+		if q.Get(param) != "" {
+			http.Error(w, fmt.Sprintf("QR-bill codes are drawn as the standard prescribes; remove the '%s' parameter", param), http.StatusBadRequest)
+			return
+		}
+	}
+	for _, param := range swissUnsupportedParams {
+		if q.Get(param) != "" {
+			http.Error(w, fmt.Sprintf("The '%s' parameter is not supported for QR-bills", param), http.StatusBadRequest)
+			return
+		}
+	}
+	if e := q.Get("ecc"); e != "" && e != "M" {
+		http.Error(w, "These QR codes must use error-correction level M", http.StatusBadRequest)
+		return
+	}
+
+	bill, err := parseSwissBill(q)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Get and validate the size parameter
+	size := 256 // default size
+	if sizeStr := q.Get("size"); sizeStr != "" {
+		size, err = strconv.Atoi(sizeStr)
+		if err != nil {
+			http.Error(w, "Size must be a valid number", http.StatusBadRequest)
+			return
+		}
+		if size < 50 || size > 1000 {
+			http.Error(w, "Size must be between 50 and 1000 pixels", http.StatusBadRequest)
+			return
+		}
+	}
+
+	// Get and validate the format and slip parameters
+	format := q.Get("format")
+	if format == "" {
+		format = "png" // default format
+	}
+	contentType, ok := formatContentTypes[format]
+	if !ok {
+		http.Error(w, "Format must be 'png', 'svg' or 'pdf'", http.StatusBadRequest)
+		return
+	}
+	slip := q.Get("slip")
+	if slip != "" && slip != "true" && slip != "false" {
+		http.Error(w, "slip must be 'true' or 'false'", http.StatusBadRequest)
+		return
+	}
+	if slip == "true" && format == "png" {
+		http.Error(w, "The payment slip can only be drawn as 'pdf' or 'svg'", http.StatusBadRequest)
+		return
+	}
+	if slip == "true" && format == "pdf" {
+		if err := checkPDFSlipText(bill); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	// Check cache first
+	text := bill.payload()
+	cacheKey := fmt.Sprintf("%s:%d:swiss:%s:%s", text, size, format, slip)
+	qrCacheMutex.RLock()
+	data, found := qrCache[cacheKey]
+	qrCacheMutex.RUnlock()
+
+	if !found {
+		qr, err := qrcode.New(text, qrcode.Medium)
+		if err != nil {
+			http.Error(w, "Failed to generate QR code", http.StatusInternalServerError)
+			return
+		}
+		if qr.VersionNumber > maxSwissVersion {
+			http.Error(w, fmt.Sprintf("The QR-bill needs QR code version %d, but Swiss QR codes may be at most version %d",
+				qr.VersionNumber, maxSwissVersion), http.StatusBadRequest)
+			return
+		}
+
+		// The quiet zone is at least 5 mm wide
+		n := qrSymbolSize(qr.VersionNumber)
+		margin := int(math.Ceil(swissQuietZoneMM * float64(n) / swissCodeMM))
+		modules := qrModules(qr, margin)
+		cross := newSwissCross(n, margin)
+
+		var buf bytes.Buffer
+		if slip == "true" {
+			err = writeSwissSlip(&buf, format, bill, modules, margin, cross)
+		} else {
+			// The symbol is 46 mm wide in PDF output
+			sideMM := swissCodeMM * float64(n+2*margin) / float64(n)
+			err = renderCode(&buf, modules, renderOptions{
+				format:   format,
+				width:    size,
+				height:   size,
+				widthMM:  sideMM,
+				heightMM: sideMM,
+				fg:       color.RGBA{A: 255},
+				bg:       color.RGBA{R: 255, G: 255, B: 255, A: 255},
+				cross:    cross,
+			})
+		}
+		if err != nil {
+			http.Error(w, "Failed to encode image", http.StatusInternalServerError)
+			return
+		}
+		data = buf.Bytes()
+
+		// Store in cache
+		qrCacheMutex.Lock()
+		qrCache[cacheKey] = data
+		qrCacheMutex.Unlock()
+	}
+
+	// Check if base64 encoding is requested
+	if q.Get("base64") == "true" {
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(base64.StdEncoding.EncodeToString(data)))
+		return
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Write(data)
+}
@@ -0,0 +1,261 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	zxqrcode "github.com/makiuchi-d/gozxing/qrcode"
+	qrcode "github.com/skip2/go-qrcode"
+)
+
+// testSwissBill is the example bill of the Swiss Implementation Guidelines,
+// paid to a QR-IBAN with a QR reference.
+func testSwissBill() url.Values {
+	return url.Values{
+		"iban":                 {"CH44 3199 9123 0008 8901 2"},
+		"creditor_name":        {"Robert Schneider AG"},
+		"creditor_street":      {"Rue du Lac"},
+		"creditor_building":    {"1268"},
+		"creditor_postal_code": {"2501"},
+		"creditor_town":        {"Biel"},
+		"creditor_country":     {"CH"},
+		"amount":               {"1949.75"},
+		"debtor_name":          {"Pia-Maria Rutschmann-Schnyder"},
+		"debtor_street":        {"Grosse Marktgasse"},
+		"debtor_building":      {"28"},
+		"debtor_postal_code":   {"9400"},
+		"debtor_town":          {"Rorschach"},
+		"debtor_country":       {"CH"},
+		"reference":            {"21 00000 00003 13947 14300 09017"},
+		"message":              {"Order of 15 June 2020"},
+		"bill_info":            {"//S1/10/10201409/11/200701/20/140.000-53/30/102673831/31/200615/32/7.7/33/7.7:139.40/40/0:30"},
+	}
+}
+
+func getSwissBill(t *testing.T, q url.Values) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest("GET", "/qr/swiss?"+q.Encode(), nil)
+	rr := httptest.NewRecorder()
+	swissBillHandler(rr, req)
+	return rr
+}
+
+func TestQRRCheckDigit(t *testing.T) {
+	for _, ref := range []string{"210000000003139471430009017", "000000000000000000000000000"} {
+		if got := qrrCheckDigit(ref[:26]); got != int(ref[26]-'0') {
+			t.Fatalf("expected check digit %c for %s, got %d", ref[26], ref, got)
+		}
+	}
+}
+
+func TestSwissBillPayload(t *testing.T) {
+	b, err := parseSwissBill(testSwissBill())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "SPC\n0200\n1\nCH4431999123000889012\n" +
+		"S\nRobert Schneider AG\nRue du Lac\n1268\n2501\nBiel\nCH\n" +
+		"\n\n\n\n\n\n\n" +
+		"1949.75\nCHF\n" +
+		"S\nPia-Maria Rutschmann-Schnyder\nGrosse Marktgasse\n28\n9400\nRorschach\nCH\n" +
+		"QRR\n210000000003139471430009017\nOrder of 15 June 2020\nEPD\n" +
+		"//S1/10/10201409/11/200701/20/140.000-53/30/102673831/31/200615/32/7.7/33/7.7:139.40/40/0:30"
+	if got := b.payload(); got != want {
+		t.Fatalf("unexpected payload:\n%q\nwant:\n%q", got, want)
+	}
+
+	// An IBAN that is not a QR-IBAN takes a creditor reference or none, and
+	// the payer may fill in the amount and their address
+	q := url.Values{"iban": {"CH5800791123000889012"}, "creditor_name": {"Robert Schneider AG"},
+		"creditor_postal_code": {"2501"}, "creditor_town": {"Biel"}, "creditor_country": {"ch"}, "currency": {"EUR"}}
+	b, err = parseSwissBill(q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = "SPC\n0200\n1\nCH5800791123000889012\nS\nRobert Schneider AG\n\n\n2501\nBiel\nCH\n\n\n\n\n\n\n\n\nEUR\n\n\n\n\n\n\n\nNON\n\n\nEPD"
+	if got := b.payload(); got != want {
+		t.Fatalf("unexpected payload:\n%q\nwant:\n%q", got, want)
+	}
+	q.Set("reference", "RF18 5390 0754 7034")
+	if b, err = parseSwissBill(q); err != nil || b.refType != "SCOR" || b.reference != "RF18539007547034" {
+		t.Fatalf("expected a SCOR reference, got %+v, %v", b, err)
+	}
+}
+
+func TestSwissBillHandler_RoundTrip(t *testing.T) {
+	isolateRateLimiter(t)
+
+	q := testSwissBill()
+	b, _ := parseSwissBill(q)
+	rr := getSwissBill(t, q)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if got := decodeMatrix(t, zxqrcode.NewQRCodeReader(), rr.Body.Bytes()); got != b.payload() {
+		t.Fatalf("expected the SPC payload back, got %q", got)
+	}
+	if ecc, _ := qrFormatInfo(t, rr.Body.Bytes()); ecc != "M" {
+		t.Fatalf("expected ECC M, got %s", ecc)
+	}
+
+	// The PDF symbol is 46 mm wide, with a quiet zone of at least 5 mm
+	resetRateLimiter()
+	q.Set("format", "pdf")
+	rr = getSwissBill(t, q)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	box, content := parsePDF(t, rr.Body.Bytes()).page(t)
+	qr, err := qrcode.New(b.payload(), qrcode.Medium)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := qrSymbolSize(qr.VersionNumber)
+	margin := int(math.Ceil(swissQuietZoneMM * float64(n) / swissCodeMM))
+	if quiet := float64(margin) * swissCodeMM / float64(n); quiet < swissQuietZoneMM || quiet > swissQuietZoneMM+2 {
+		t.Fatalf("expected a quiet zone of 5 mm or a little more, got %g mm", quiet)
+	}
+	if !approxEqual(box[2], swissCodeMM*pointsPerMM*float64(n+2*margin)/float64(n)) || box[2] != box[3] {
+		t.Fatalf("expected a symbol of 46 mm, got a page of %v for %d modules", box, n)
+	}
+
+	// The cross is drawn over the modules: a light border, a dark square
+	// and a light cross
+	cross := newSwissCross(n, margin)
+	layers := cross.layers()
+	if !approxEqual(layers[0].w*swissCodeMM/float64(n), swissCrossMM) {
+		t.Fatalf("expected a 7 mm cross, got %g mm", layers[0].w*swissCodeMM/float64(n))
+	}
+	if !strings.Contains(content, pdfNumber(layers[1].x)+" "+pdfNumber(layers[1].y)+" "+pdfNumber(layers[1].w)) {
+		t.Fatal("expected the cross in the PDF")
+	}
+
+	resetRateLimiter()
+	q.Set("format", "svg")
+	rr = getSwissBill(t, q)
+	if doc := parseSVG(t, rr.Body.Bytes()); len(doc.Rects) != 5 {
+		t.Fatalf("expected the background and the 4 rectangles of the cross, got %d", len(doc.Rects))
+	}
+}
+
+func TestSwissBillHandler_Slip(t *testing.T) {
+	isolateRateLimiter(t)
+
+	q := testSwissBill()
+	q.Set("slip", "true")
+	q.Set("format", "pdf")
+	rr := getSwissBill(t, q)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if ct := rr.Header().Get("Content-Type"); ct != "application/pdf" {
+		t.Fatalf("expected Content-Type application/pdf, got %s", ct)
+	}
+	box, content := parsePDF(t, rr.Body.Bytes()).page(t)
+	if !approxEqual(box[2], 210*pointsPerMM) || !approxEqual(box[3], 105*pointsPerMM) {
+		t.Fatalf("expected an A6 landscape page, got %v", box)
+	}
+	for _, text := range []string{"(Receipt) Tj", "(Payment part) Tj", "(CH44 3199 9123 0008 8901 2) Tj",
+		"(21 00000 00003 13947 14300 09017) Tj", "(1 949.75) Tj", "(Acceptance point) Tj"} {
+		if !strings.Contains(content, text) {
+			t.Fatalf("expected %q in the slip", text)
+		}
+	}
+
+	// Without an amount and debtor, the payer fills them in. The SVG slip
+	// prints letters outside Latin-1
+	resetRateLimiter()
+	q.Del("amount")
+	for _, field := range []string{"name", "street", "building", "postal_code", "town", "country"} {
+		q.Del("debtor_" + field)
+	}
+	q.Set("creditor_name", "Ștefan Łukasz AG")
+	q.Set("format", "svg")
+	rr = getSwissBill(t, q)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	doc := parseSVG(t, rr.Body.Bytes())
+	if doc.Width != "210mm" || doc.Height != "105mm" {
+		t.Fatalf("expected a 210mm x 105mm slip, got %s x %s", doc.Width, doc.Height)
+	}
+	if !strings.Contains(rr.Body.String(), ">Payable by (name/address)</text>") {
+		t.Fatal("expected a blank debtor field")
+	}
+	if !strings.Contains(rr.Body.String(), ">Ștefan Łukasz AG</text>") {
+		t.Fatal("expected the creditor name in the slip")
+	}
+}
+
+func TestWrapSlipText(t *testing.T) {
+	text := "Pia-Maria Rutschmann-Schnyder of Grosse Marktgasse with a rather long name"
+	lines := wrapSlipText(text, 10, 52)
+	if len(lines) < 2 {
+		t.Fatalf("expected the text to wrap, got %q", lines)
+	}
+	for _, line := range lines {
+		if slipTextWidth(line, 10) > 52 {
+			t.Fatalf("line %q is wider than its column", line)
+		}
+	}
+	if strings.Join(lines, " ") != text {
+		t.Fatalf("wrapping lost text: %q", lines)
+	}
+}
+
+func TestSwissBillHandler_Invalid(t *testing.T) {
+	isolateRateLimiter(t)
+
+	cases := []struct {
+		set map[string]string
+		msg string
+	}{
+		{map[string]string{"iban": ""}, "Please provide an 'iban' parameter"},
+		{map[string]string{"iban": "DE89370400440532013000"}, "IBANs from DE are not accepted"},
+		{map[string]string{"iban": "CH4431999123000889013"}, "Invalid IBAN checksum"},
+		{map[string]string{"reference": "210000000003139471430009016"}, "Invalid QR reference check digit 6, expected 7"},
+		{map[string]string{"reference": "RF18539007547034"}, "QR-IBANs must be paid with a 27-digit QR reference"},
+		{map[string]string{"iban": "CH5800791123000889012"}, "QR references can only be used with a QR-IBAN"},
+		{map[string]string{"iban": "CH5800791123000889012", "reference": "RF19539007547034"}, "Reference must be an ISO 11649 creditor reference"},
+		{map[string]string{"creditor_name": ""}, "Please provide a 'creditor_name' parameter"},
+		{map[string]string{"creditor_town": strings.Repeat("x", 36)}, "The creditor_town can be at most 35 characters, got 36"},
+		{map[string]string{"creditor_country": "CHE"}, "creditor_country must be a two-letter ISO 3166 country code"},
+		{map[string]string{"debtor_postal_code": ""}, "Please provide a 'debtor_postal_code' parameter"},
+		{map[string]string{"debtor_name": "Grüße 你好"}, "The debtor_name can only contain Latin characters"},
+		{map[string]string{"amount": "1.234"}, "Amount must be a number with at most two decimals"},
+		{map[string]string{"currency": "USD"}, "Currency must be 'CHF' or 'EUR'"},
+		{map[string]string{"bill_info": "S1/10/10201409"}, "bill_info must start with '//'"},
+		{map[string]string{"message": strings.Repeat("x", 100)}, "The message and bill_info together can be at most 140 characters"},
+		{map[string]string{"ecc": "H"}, "These QR codes must use error-correction level M"},
+		{map[string]string{"fg": "ff0000"}, "remove the 'fg' parameter"},
+		{map[string]string{"width_mm": "30"}, "remove the 'width_mm' parameter"},
+		{map[string]string{"text": "SPC"}, "remove the 'text' parameter"},
+		{map[string]string{"slip": "yes"}, "slip must be 'true' or 'false'"},
+		{map[string]string{"slip": "true"}, "The payment slip can only be drawn as 'pdf' or 'svg'"},
+		{map[string]string{"slip": "true", "format": "pdf", "debtor_name": "Ștefan Popescu"},
+			"The PDF payment slip can only print Latin-1 characters and the euro sign, not 'Ș'; use format=svg"},
+		{map[string]string{"slip": "true", "format": "pdf", "verify": "true"}, "The 'verify' parameter is not supported for QR-bills"},
+		{map[string]string{"hrt": "true"}, "The 'hrt' parameter is not supported for QR-bills"},
+		{map[string]string{"mask": "3"}, "remove the 'mask' parameter"},
+		{map[string]string{"size": "10"}, "Size must be between 50 and 1000 pixels"},
+	}
+	for _, tc := range cases {
+		resetRateLimiter() // Reset rate limiter before each request
+
+		q := testSwissBill()
+		for key, value := range tc.set {
+			q.Set(key, value)
+		}
+		rr := getSwissBill(t, q)
+		if rr.Code != http.StatusBadRequest {
+			t.Fatalf("expected status 400 for %v, got %d", tc.set, rr.Code)
+		}
+		if !strings.Contains(rr.Body.String(), tc.msg) {
+			t.Fatalf("expected error %q for %v, got %s", tc.msg, tc.set, rr.Body.String())
+		}
+	}
+}
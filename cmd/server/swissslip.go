@@ -0,0 +1,372 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf8"
+)
+
+// Size of the QR-bill payment slip, an A6 landscape sheet holding the
+// receipt and the payment part, in millimetres
+const (
+	swissSlipWidthMM  = 210
+	swissSlipHeightMM = 105
+	swissReceiptMM    = 62 // Width of the receipt, left of the payment part
+)
+
+// Millimetres per point, the unit font sizes are given in
+const mmPerPoint = 25.4 / 72
+
+// slipCanvas draws the payment slip in PDF or SVG. Coordinates are in
+// millimetres from the top left of the slip.
+type slipCanvas interface {
+	// text draws s in Helvetica of size points with its baseline at y.
+	text(x, y, size float64, bold bool, s string)
+
+	// rect fills a rectangle in black, or in white if not dark.
+	rect(x, y, w, h float64, dark bool)
+
+	// line strokes a black line width points wide.
+	line(x1, y1, x2, y2, width float64, dashed bool)
+}
+
+// slipTextWidth estimates the width of s in Helvetica of size points, in
+// millimetres. The average character width it assumes errs on the wide
+// side, so that wrapped text stays within its column.
+func slipTextWidth(s string, size float64) float64 {
+	return float64(utf8.RuneCountInString(s)) * 0.6 * size * mmPerPoint
+}
+
+// wrapSlipText breaks s at spaces into lines no wider than width
+// millimetres, breaking words that do not fit a line of their own.
+func wrapSlipText(s string, size, width float64) []string {
+	var lines []string
+	line := ""
+	for _, word := range strings.Fields(s) {
+		if line != "" && slipTextWidth(line+" "+word, size) <= width {
+			line += " " + word
+			continue
+		}
+		if line != "" {
+			lines = append(lines, line)
+		}
+		for slipTextWidth(word, size) > width {
+			n := max(1, int(width/slipTextWidth("x", size)))
+			runes := []rune(word)
+			lines = append(lines, string(runes[:n]))
+			word = string(runes[n:])
+		}
+		line = word
+	}
+	if line != "" {
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// slipColumn writes the headed sections of a part of the slip from top to
+// bottom.
+type slipColumn struct {
+	c              slipCanvas
+	x, y, width    float64
+	heading, value float64 // Font sizes in points
+	spacing        float64 // Line spacing in millimetres
+	started        bool    // Whether a section was written
+}
+
+// section writes a heading with its value lines below, one line of space
+// after the previous section.
+func (col *slipColumn) section(heading string, values ...string) {
+	if col.started {
+		col.y += col.spacing
+	}
+	col.started = true
+	col.y += col.spacing
+	col.c.text(col.x, col.y, col.heading, true, heading)
+	for _, value := range values {
+		for _, line := range wrapSlipText(value, col.value, col.width) {
+			col.y += col.spacing
+			col.c.text(col.x, col.y, col.value, false, line)
+		}
+	}
+}
+
+// blankField writes a heading over a blank field of w x h millimetres for
+// the payer to fill in by hand.
+func (col *slipColumn) blankField(heading string, w, h float64) {
+	col.section(heading)
+	col.y += 1
+	drawCorners(col.c, col.x, col.y, w, h)
+	col.y += h
+}
+
+// drawCorners marks the corners of a blank field.
+func drawCorners(c slipCanvas, x, y, w, h float64) {
+	const arm = 3
+	for _, corner := range [][4]float64{{x, y, 1, 1}, {x + w, y, -1, 1}, {x, y + h, 1, -1}, {x + w, y + h, -1, -1}} {
+		cx, cy, dx, dy := corner[0], corner[1], corner[2], corner[3]
+		c.line(cx, cy, cx+dx*arm, cy, 0.75, false)
+		c.line(cx, cy, cx, cy+dy*arm, 0.75, false)
+	}
+}
+
+// groupDigits splits s into groups of n characters from the left, after a
+// first group of first characters if first is not zero.
+func groupDigits(s string, first, n int) string {
+	var groups []string
+	if first > 0 && len(s) > first {
+		groups, s = append(groups, s[:first]), s[first:]
+	}
+	for len(s) > n {
+		groups, s = append(groups, s[:n]), s[n:]
+	}
+	return strings.Join(append(groups, s), " ")
+}
+
+// slipAmount writes an amount with spaces between the thousands.
+func slipAmount(cents int64) string {
+	whole := fmt.Sprint(cents / 100)
+	var groups []string
+	for len(whole) > 3 {
+		groups = append([]string{whole[len(whole)-3:]}, groups...)
+		whole = whole[:len(whole)-3]
+	}
+	groups = append([]string{whole}, groups...)
+	return fmt.Sprintf("%s.%02d", strings.Join(groups, " "), cents%100)
+}
+
+// drawSwissSlip lays out the receipt and the payment part of a QR-bill, as
+// the style guide of the Swiss Implementation Guidelines places them.
+func drawSwissSlip(c slipCanvas, b *swissBill, modules [][]bool, margin int, cross *swissCross) {
+	c.rect(0, 0, swissSlipWidthMM, swissSlipHeightMM, false)
+
+	// The slip is cut from the bill along the top, and the receipt from the
+	// payment part
+	c.line(0, 0, swissSlipWidthMM, 0, 0.5, true)
+	c.line(swissReceiptMM, 0, swissReceiptMM, swissSlipHeightMM, 0.5, true)
+
+	account := append([]string{groupDigits(b.iban, 0, 4)}, b.creditor.lines()...)
+	reference := b.reference
+	if b.refType == "QRR" {
+		reference = groupDigits(reference, 2, 5)
+	} else {
+		reference = groupDigits(reference, 0, 4)
+	}
+	var additional []string
+	for _, s := range []string{b.message, b.billInfo} {
+		if s != "" {
+			additional = append(additional, s)
+		}
+	}
+
+	// Receipt
+	c.text(5, 5+11*mmPerPoint, 11, true, "Receipt")
+	receipt := &slipColumn{c: c, x: 5, y: 12, width: 52, heading: 6, value: 8, spacing: 9 * mmPerPoint}
+	receipt.section("Account / Payable to", account...)
+	if b.refType != "NON" {
+		receipt.section("Reference", reference)
+	}
+	if b.debtor != nil {
+		receipt.section("Payable by", b.debtor.lines()...)
+	} else {
+		receipt.blankField("Payable by (name/address)", 52, 20)
+	}
+	drawSlipAmount(c, b, 5, 68, 52, 6, 8, 30, 10)
+	acceptance := "Acceptance point"
+	c.text(57-slipTextWidth(acceptance, 6), 82+6*mmPerPoint, 6, true, acceptance)
+
+	// Payment part
+	c.text(67, 5+11*mmPerPoint, 11, true, "Payment part")
+	scale := swissCodeMM / float64(len(modules)-2*margin)
+	drawSlipCode(c, modules, cross, 67-float64(margin)*scale, 17-float64(margin)*scale, scale)
+	drawSlipAmount(c, b, 67, 68, 51, 8, 10, 40, 15)
+
+	payment := &slipColumn{c: c, x: 118, y: 5, width: 87, heading: 8, value: 10, spacing: 11 * mmPerPoint}
+	payment.section("Account / Payable to", account...)
+	if b.refType != "NON" {
+		payment.section("Reference", reference)
+	}
+	if len(additional) > 0 {
+		payment.section("Additional information", additional...)
+	}
+	if b.debtor != nil {
+		payment.section("Payable by", b.debtor.lines()...)
+	} else {
+		payment.blankField("Payable by (name/address)", 65, 25)
+	}
+}
+
+// drawSlipAmount writes the currency and amount section at x, y, width
+// millimetres wide, with a blank field of boxW x boxH millimetres at its
+// right if the payer fills the amount in.
+func drawSlipAmount(c slipCanvas, b *swissBill, x, y, width, heading, value, boxW, boxH float64) {
+	amountX := x + 17
+	headingY := y + heading*mmPerPoint
+	c.text(x, headingY, heading, true, "Currency")
+	c.text(amountX, headingY, heading, true, "Amount")
+	valueY := headingY + (value+1)*mmPerPoint
+	c.text(x, valueY, value, false, b.currency)
+	if b.amount != 0 {
+		c.text(amountX, valueY, value, false, slipAmount(b.amount))
+	} else {
+		drawCorners(c, x+width-boxW, headingY+1, boxW, boxH)
+	}
+}
+
+// drawSlipCode draws the QR code's modules, each scale millimetres wide,
+// with its top left corner at x, y, and the Swiss cross over them.
+func drawSlipCode(c slipCanvas, modules [][]bool, cross *swissCross, x, y, scale float64) {
+	// Draw each horizontal run of dark modules as one rectangle
+	darkRuns(modules, func(row, start, end int) {
+		c.rect(x+float64(start)*scale, y+float64(row)*scale, float64(end-start)*scale, scale, true)
+	})
+	for _, l := range cross.layers() {
+		c.rect(x+l.x*scale, y+l.y*scale, l.w*scale, l.h*scale, l.dark)
+	}
+}
+
+// pdfSlip draws the slip into a PDF content stream.
+type pdfSlip struct {
+	content bytes.Buffer
+}
+
+// isWinAnsi reports whether the fonts of the PDF slip, in WinAnsiEncoding,
+// hold r: the printable Latin-1 characters and the euro sign.
+func isWinAnsi(r rune) bool {
+	return r >= 0x20 && r <= 0x7e || r >= 0xa0 && r <= 0xff || r == '€'
+}
+
+// checkPDFSlipText checks that the PDF slip can print the text of b.
+// QR-bills also allow Latin Extended-A and the Romanian Ș ș Ț ț, which the
+// code carries but the fonts of the PDF slip lack.
+func checkPDFSlipText(b *swissBill) error {
+	text := append(b.creditor.lines(), b.message, b.billInfo)
+	if b.debtor != nil {
+		text = append(text, b.debtor.lines()...)
+	}
+	for _, s := range text {
+		for _, r := range s {
+			if !isWinAnsi(r) {
+				return fmt.Errorf("The PDF payment slip can only print Latin-1 characters and the euro sign, not '%c'; use format=svg", r)
+			}
+		}
+	}
+	return nil
+}
+
+// pdfText encodes s as a PDF string in WinAnsiEncoding. Other characters,
+// which checkPDFSlipText rejects, become question marks.
+func pdfText(s string) string {
+	var b strings.Builder
+	b.WriteByte('(')
+	for _, r := range s {
+		switch {
+		case r == '(' || r == ')' || r == '\\':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case r == '€':
+			b.WriteString(`\200`)
+		case r >= 0x20 && r <= 0x7e:
+			b.WriteRune(r)
+		case isWinAnsi(r):
+			fmt.Fprintf(&b, `\%03o`, r)
+		default:
+			b.WriteByte('?')
+		}
+	}
+	b.WriteByte(')')
+	return b.String()
+}
+
+func (p *pdfSlip) text(x, y, size float64, bold bool, s string) {
+	font := "/F1"
+	if bold {
+		font = "/F2"
+	}
+	fmt.Fprintf(&p.content, "BT\n%s %s Tf\n%s %s Td\n%s Tj\nET\n", font, pdfNumber(size),
+		pdfNumber(x*pointsPerMM), pdfNumber((swissSlipHeightMM-y)*pointsPerMM), pdfText(s))
+}
+
+func (p *pdfSlip) rect(x, y, w, h float64, dark bool) {
+	gray := "1"
+	if dark {
+		gray = "0"
+	}
+	fmt.Fprintf(&p.content, "%s g\n%s %s %s %s re\nf\n", gray, pdfNumber(x*pointsPerMM),
+		pdfNumber((swissSlipHeightMM-y-h)*pointsPerMM), pdfNumber(w*pointsPerMM), pdfNumber(h*pointsPerMM))
+}
+
+func (p *pdfSlip) line(x1, y1, x2, y2, width float64, dashed bool) {
+	dash := "[] 0 d"
+	if dashed {
+		dash = "[2 2] 0 d"
+	}
+	fmt.Fprintf(&p.content, "0 G\n%s w\n%s\n%s %s m\n%s %s l\nS\n", pdfNumber(width), dash,
+		pdfNumber(x1*pointsPerMM), pdfNumber((swissSlipHeightMM-y1)*pointsPerMM),
+		pdfNumber(x2*pointsPerMM), pdfNumber((swissSlipHeightMM-y2)*pointsPerMM))
+}
+
+// svgSlip draws the slip as SVG elements in millimetres.
+type svgSlip struct {
+	body bytes.Buffer
+}
+
+func (s *svgSlip) text(x, y, size float64, bold bool, text string) {
+	weight := ""
+	if bold {
+		weight = ` font-weight="bold"`
+	}
+	fmt.Fprintf(&s.body, "<text x=\"%s\" y=\"%s\" font-family=\"Helvetica, Arial, sans-serif\" font-size=\"%s\"%s>",
+		svgNumber(x), svgNumber(y), svgNumber(size*mmPerPoint), weight)
+	xml.EscapeText(&s.body, []byte(text))
+	s.body.WriteString("</text>\n")
+}
+
+func (s *svgSlip) rect(x, y, w, h float64, dark bool) {
+	fill := "#ffffff"
+	if dark {
+		fill = "#000000"
+	}
+	fmt.Fprintf(&s.body, "<rect x=\"%s\" y=\"%s\" width=\"%s\" height=\"%s\" fill=\"%s\"/>\n",
+		svgNumber(x), svgNumber(y), svgNumber(w), svgNumber(h), fill)
+}
+
+func (s *svgSlip) line(x1, y1, x2, y2, width float64, dashed bool) {
+	dash := ""
+	if dashed {
+		dash = ` stroke-dasharray="0.7 0.7"`
+	}
+	fmt.Fprintf(&s.body, "<line x1=\"%s\" y1=\"%s\" x2=\"%s\" y2=\"%s\" stroke=\"#000000\" stroke-width=\"%s\"%s/>\n",
+		svgNumber(x1), svgNumber(y1), svgNumber(x2), svgNumber(y2), svgNumber(width*mmPerPoint), dash)
+}
+
+// writeSwissSlip writes the payment slip of a QR-bill as a 210 x 105 mm
+// PDF page or SVG image, with the code of modules, which has a quiet zone of
+// margin modules.
+func writeSwissSlip(w io.Writer, format string, b *swissBill, modules [][]bool, margin int, cross *swissCross) error {
+	if format == "svg" {
+		var s svgSlip
+		drawSwissSlip(&s, b, modules, margin, cross)
+		_, err := fmt.Fprintf(w, "<?xml version=\"1.0\" encoding=\"UTF-8\"?>\n"+
+			"<svg xmlns=\"http://www.w3.org/2000/svg\" version=\"1.1\" width=\"%dmm\" height=\"%dmm\" viewBox=\"0 0 %d %d\">\n%s</svg>\n",
+			swissSlipWidthMM, swissSlipHeightMM, swissSlipWidthMM, swissSlipHeightMM, s.body.String())
+		return err
+	}
+
+	var p pdfSlip
+	drawSwissSlip(&p, b, modules, margin, cross)
+
+	var doc pdfWriter
+	catalog := doc.addObject("<< /Type /Catalog /Pages 2 0 R >>")
+	doc.addObject("<< /Type /Pages /Kids [3 0 R] /Count 1 >>")
+	page := doc.addObject("") // Written once its resources are known
+	contents := doc.addStream("", p.content.Bytes())
+	regular := doc.addObject("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>")
+	bold := doc.addObject("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica-Bold /Encoding /WinAnsiEncoding >>")
+	doc.setObject(page, fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %s %s] /Contents %d 0 R /Resources << /Font << /F1 %d 0 R /F2 %d 0 R >> >> >>",
+		pdfNumber(swissSlipWidthMM*pointsPerMM), pdfNumber(swissSlipHeightMM*pointsPerMM), contents, regular, bold))
+	return doc.writeTo(w, catalog)
+}
@@ -0,0 +1,415 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/boombuler/barcode"
+	"github.com/boombuler/barcode/codabar"
+	"github.com/boombuler/barcode/code128"
+	"github.com/boombuler/barcode/code39"
+	"github.com/boombuler/barcode/code93"
+	"github.com/boombuler/barcode/ean"
+	"github.com/boombuler/barcode/twooffive"
+	"github.com/boombuler/barcode/utils"
+)
+
+// linearSymbology is a 1D barcode symbology that /barcode, and /qr through
+// its type parameter, can draw.
+type linearSymbology struct {
+	// encode validates text and returns its barcode. Its errors explain what
+	// is wrong with the text and are shown to the client.
+	encode func(text string, opts symbologyOptions) (barcode.Barcode, error)
+
+	// Quiet zone on either side, in modules, when no margin is given
+	margin int
+
+	// Names of the options the symbology accepts, and their defaults
+	options  []string
+	defaults symbologyOptions
+
+	// Groups the digits of the encoded code for the human-readable text, or
+	// nil to print the text as given, centered under the bars
+	hrt func(code string) []hrtSegment
+}
+
+// symbologyOptions are the symbology-specific encoding options.
+type symbologyOptions struct {
+	fullASCII   bool // Encode any ASCII character as a pair of symbols
+	checksum    bool // Append the optional check character
+	interleaved bool // Interleave pairs of 2 of 5 digits in bars and spaces
+	gs1         bool // Read the text as GS1 Application Identifiers and their data
+
+	rectangular   bool // Data Matrix: use a rectangular symbol
+	minECC        int  // Aztec: minimum error correction, in percent of the data
+	securityLevel int  // PDF417: error-correction level, from 0 to 8
+	columns       int  // PDF417: data columns, or 0 to fit them to the data
+}
+
+// key returns a string identifying the options, for cache keys.
+func (o symbologyOptions) key() string {
+	return fmt.Sprintf("%t,%t,%t,%t,%t,%d,%d,%d", o.fullASCII, o.checksum, o.interleaved, o.gs1,
+		o.rectangular, o.minECC, o.securityLevel, o.columns)
+}
+
+// boolOption parses a true or false option into the field it returns.
+func boolOption(field func(*symbologyOptions) *bool) func(*symbologyOptions, string, string) error {
+	return func(o *symbologyOptions, name, s string) error {
+		if s != "true" && s != "false" {
+			return fmt.Errorf("%s must be 'true' or 'false'", name)
+		}
+		*field(o) = s == "true"
+		return nil
+	}
+}
+
+// intOption parses a whole number option between lo and hi into the field it
+// returns.
+func intOption(field func(*symbologyOptions) *int, lo, hi int) func(*symbologyOptions, string, string) error {
+	return func(o *symbologyOptions, name, s string) error {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < lo || n > hi {
+			return fmt.Errorf("%s must be a whole number between %d and %d", name, lo, hi)
+		}
+		*field(o) = n
+		return nil
+	}
+}
+
+// Option parameters and how they are parsed
+var symbologyOptionFields = []struct {
+	name  string
+	parse func(o *symbologyOptions, name, s string) error
+}{
+	{"full_ascii", boolOption(func(o *symbologyOptions) *bool { return &o.fullASCII })},
+	{"checksum", boolOption(func(o *symbologyOptions) *bool { return &o.checksum })},
+	{"interleaved", boolOption(func(o *symbologyOptions) *bool { return &o.interleaved })},
+	{"gs1", boolOption(func(o *symbologyOptions) *bool { return &o.gs1 })},
+	{"rectangular", boolOption(func(o *symbologyOptions) *bool { return &o.rectangular })},
+	{"min_ecc", intOption(func(o *symbologyOptions) *int { return &o.minECC }, 5, 95)},
+	{"security_level", intOption(func(o *symbologyOptions) *int { return &o.securityLevel }, 0, 8)},
+	{"columns", intOption(func(o *symbologyOptions) *int { return &o.columns }, 1, pdf417MaxColumns)},
+}
+
+// Supported 1D symbologies, keyed by the lower-case name without dashes
+var linearSymbologies = map[string]linearSymbology{
+	"code128": {encode: encodeCode128, margin: 10, options: []string{"gs1"}},
+	"ean13":   {encode: encodeEAN13, margin: 11, hrt: eanSegments},
+	"ean8":    {encode: encodeEAN8, margin: 7, hrt: ean8Segments},
+	"upca":    {encode: encodeUPCA, margin: 9, hrt: upcaSegments},
+	"upce":    {encode: encodeUPCE, margin: 9, hrt: upceSegments},
+	"code39":  {encode: encodeCode39, margin: 10, options: []string{"full_ascii", "checksum"}},
+	"code93":  {encode: encodeCode93, margin: 10, options: []string{"full_ascii"}},
+	"2of5": {encode: encode2of5, margin: 10, options: []string{"interleaved", "checksum"},
+		defaults: symbologyOptions{interleaved: true}},
+	"codabar": {encode: encodeCodabar, margin: 10},
+}
+
+// Most characters a variable-length 1D barcode may hold; longer codes get
+// too wide to scan
+const maxLinearText = 80
+
+// symbologyName returns the canonical form of a symbology name, ignoring
+// case, dashes and underscores so that "EAN-13" and "ean13" both work.
+func symbologyName(name string) string {
+	return strings.ToLower(strings.NewReplacer("-", "", "_", "").Replace(name))
+}
+
+// lookupSymbology finds a 1D symbology by name. It returns the canonical
+// name along with the symbology.
+func lookupSymbology(name string) (string, linearSymbology, bool) {
+	name = symbologyName(name)
+	sym, ok := linearSymbologies[name]
+	return name, sym, ok
+}
+
+// symbologyList lists the symbology names for error messages, such as
+// "'code128', 'ean13' or 'ean8'".
+func symbologyList() string {
+	names := make([]string, 0, len(linearSymbologies))
+	for name := range linearSymbologies {
+		names = append(names, "'"+name+"'")
+	}
+	sort.Strings(names)
+	return strings.Join(names[:len(names)-1], ", ") + " or " + names[len(names)-1]
+}
+
+// parseSymbologyOptions reads the options of the named symbology, which
+// accepts the options named in supported, from the query string. Options the
+// symbology has no use for are rejected rather than silently ignored.
+func parseSymbologyOptions(q url.Values, name string, supported []string, defaults symbologyOptions) (symbologyOptions, error) {
+	opts := defaults
+	for _, option := range symbologyOptionFields {
+		s := q.Get(option.name)
+		if s == "" {
+			continue
+		}
+		if !slices.Contains(supported, option.name) {
+			return opts, fmt.Errorf("The '%s' symbology does not support the '%s' option", name, option.name)
+		}
+		if err := option.parse(&opts, option.name, s); err != nil {
+			return opts, err
+		}
+	}
+	return opts, nil
+}
+
+// checkLength rejects text too long for a variable-length symbology.
+func checkLength(name, text string) error {
+	if n := utf8.RuneCountInString(text); n > maxLinearText {
+		return fmt.Errorf("%s barcodes can hold at most %d characters, got %d", name, maxLinearText, n)
+	}
+	return nil
+}
+
+func encodeCode128(text string, opts symbologyOptions) (barcode.Barcode, error) {
+	if opts.gs1 {
+		return encodeGS1128(text)
+	}
+	if err := checkLength("Code 128", text); err != nil {
+		return nil, err
+	}
+	bar, err := code128.Encode(text)
+	if err != nil {
+		return nil, fmt.Errorf("Code 128 can only encode ASCII characters")
+	}
+	return bar, nil
+}
+
+// gs1CheckDigit computes the GS1 mod-10 check digit used by EAN and UPC:
+// digits are weighted 3 and 1 alternately, starting with 3 at the right.
+func gs1CheckDigit(digits string) int {
+	sum := 0
+	for i := range digits {
+		d := int(digits[len(digits)-1-i] - '0')
+		if i%2 == 0 {
+			d *= 3
+		}
+		sum += d
+	}
+	return (10 - sum%10) % 10
+}
+
+// onlyDigits reports whether s consists of ASCII digits only.
+func onlyDigits(s string) bool {
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// withCheckDigit validates a number of the given length for the named
+// symbology, given with or without its trailing check digit, and returns it
+// with the check digit.
+func withCheckDigit(name, text string, length int) (string, error) {
+	if !onlyDigits(text) {
+		return "", fmt.Errorf("%s codes may only contain digits", name)
+	}
+	switch len(text) {
+	case length - 1:
+		return text + fmt.Sprint(gs1CheckDigit(text)), nil
+	case length:
+		if want := gs1CheckDigit(text[:length-1]); int(text[length-1]-'0') != want {
+			return "", fmt.Errorf("Invalid %s check digit %c, expected %d", name, text[length-1], want)
+		}
+		return text, nil
+	}
+	return "", fmt.Errorf("%s codes must have %d digits, or %d including the check digit, got %d",
+		name, length-1, length, len(text))
+}
+
+func encodeEAN13(text string, _ symbologyOptions) (barcode.Barcode, error) {
+	code, err := withCheckDigit("EAN-13", text, 13)
+	if err != nil {
+		return nil, err
+	}
+	return ean.Encode(code)
+}
+
+func encodeEAN8(text string, _ symbologyOptions) (barcode.Barcode, error) {
+	code, err := withCheckDigit("EAN-8", text, 8)
+	if err != nil {
+		return nil, err
+	}
+	return ean.Encode(code)
+}
+
+// encodeUPCA draws a UPC-A code, which is an EAN-13 code starting with 0.
+func encodeUPCA(text string, _ symbologyOptions) (barcode.Barcode, error) {
+	code, err := withCheckDigit("UPC-A", text, 12)
+	if err != nil {
+		return nil, err
+	}
+	return ean.Encode("0" + code)
+}
+
+// Digit patterns of the left half of EAN and UPC codes, with odd and even
+// parity; a 1 is a dark module
+var (
+	eanOddPatterns  = [10]string{"0001101", "0011001", "0010011", "0111101", "0100011", "0110001", "0101111", "0111011", "0110111", "0001011"}
+	eanEvenPatterns = [10]string{"0100111", "0110011", "0011011", "0100001", "0011101", "0111001", "0000101", "0010001", "0001001", "0010111"}
+)
+
+// Parity of the six UPC-E digits for number system 0, indexed by check
+// digit; number system 1 uses the opposite parities
+var upceParities = [10]string{"EEEOOO", "EEOEOO", "EEOOEO", "EEOOOE", "EOEEOO", "EOOEEO", "EOOOEE", "EOEOEO", "EOEOOE", "EOOEOE"}
+
+// expandUPCE returns the 11-digit UPC-A number, without check digit, that
+// the number system digit and six digits of a UPC-E code stand for.
+func expandUPCE(code string) string {
+	ns, d := code[:1], code[1:]
+	switch d[5] {
+	case '0', '1', '2':
+		return ns + d[0:2] + d[5:6] + "0000" + d[2:5]
+	case '3':
+		return ns + d[0:3] + "00000" + d[3:5]
+	case '4':
+		return ns + d[0:4] + "00000" + d[4:5]
+	default:
+		return ns + d[0:5] + "0000" + d[5:6]
+	}
+}
+
+// encodeUPCE draws a zero-suppressed UPC-E code. The text holds the six
+// digits, optionally preceded by the number system digit (0 if omitted) and
+// followed by the check digit, which is that of the equivalent UPC-A code.
+func encodeUPCE(text string, _ symbologyOptions) (barcode.Barcode, error) {
+	if !onlyDigits(text) {
+		return nil, fmt.Errorf("UPC-E codes may only contain digits")
+	}
+	code := text
+	switch len(code) {
+	case 6:
+		code = "0" + code
+	case 7, 8:
+	default:
+		return nil, fmt.Errorf("UPC-E codes must have 6 digits, 7 with the number system digit, or 8 including the check digit, got %d",
+			len(code))
+	}
+	if code[0] != '0' && code[0] != '1' {
+		return nil, fmt.Errorf("UPC-E number system must be 0 or 1, got %c", code[0])
+	}
+	check := gs1CheckDigit(expandUPCE(code[:7]))
+	if len(code) == 8 && int(code[7]-'0') != check {
+		return nil, fmt.Errorf("Invalid UPC-E check digit %c, expected %d", code[7], check)
+	}
+
+	bars := new(utils.BitList)
+	addPattern := func(pattern string) {
+		for _, m := range pattern {
+			bars.AddBit(m == '1')
+		}
+	}
+	addPattern("101")
+	for i, r := range code[1:7] {
+		even := upceParities[check][i] == 'E'
+		if code[0] == '1' {
+			even = !even
+		}
+		if even {
+			addPattern(eanEvenPatterns[r-'0'])
+		} else {
+			addPattern(eanOddPatterns[r-'0'])
+		}
+	}
+	addPattern("010101")
+	return utils.New1DCode("UPC-E", code[:7]+fmt.Sprint(check), bars), nil
+}
+
+// Characters Code 39 and Code 93 encode without full-ASCII mode
+const code39Chars = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZ-. $/+%"
+
+// checkCode39Chars validates text for Code 39 or Code 93, which encode other
+// ASCII characters as pairs of symbols in full-ASCII mode.
+func checkCode39Chars(name, text string, fullASCII bool) error {
+	for _, r := range text {
+		if r > 127 {
+			return fmt.Errorf("%s can only encode ASCII characters", name)
+		}
+		if !fullASCII && !strings.ContainsRune(code39Chars, r) {
+			return fmt.Errorf("%s can only encode digits, upper-case letters, space and - . $ / + %% without full_ascii=true, got %q",
+				name, r)
+		}
+	}
+	return nil
+}
+
+func encodeCode39(text string, opts symbologyOptions) (barcode.Barcode, error) {
+	if err := checkLength("Code 39", text); err != nil {
+		return nil, err
+	}
+	if err := checkCode39Chars("Code 39", text, opts.fullASCII); err != nil {
+		return nil, err
+	}
+	return code39.Encode(text, opts.checksum, opts.fullASCII)
+}
+
+// encodeCode93 draws a Code 93 barcode. Its two check characters are part of
+// the symbology, so they can not be turned off.
+func encodeCode93(text string, opts symbologyOptions) (barcode.Barcode, error) {
+	if err := checkLength("Code 93", text); err != nil {
+		return nil, err
+	}
+	if err := checkCode39Chars("Code 93", text, opts.fullASCII); err != nil {
+		return nil, err
+	}
+	return code93.Encode(text, true, opts.fullASCII)
+}
+
+// encode2of5 draws an Interleaved or standard (industrial) 2 of 5 barcode,
+// optionally with a trailing mod-10 check digit.
+func encode2of5(text string, opts symbologyOptions) (barcode.Barcode, error) {
+	if err := checkLength("2 of 5", text); err != nil {
+		return nil, err
+	}
+	if !onlyDigits(text) {
+		return nil, fmt.Errorf("2 of 5 codes may only contain digits")
+	}
+	if opts.checksum {
+		text = text + fmt.Sprint(gs1CheckDigit(text))
+	}
+	if opts.interleaved && len(text)%2 != 0 {
+		if opts.checksum {
+			return nil, fmt.Errorf("Interleaved 2 of 5 codes need an even number of digits including the check digit, got %d; add a leading zero",
+				len(text))
+		}
+		return nil, fmt.Errorf("Interleaved 2 of 5 codes need an even number of digits, got %d; add a leading zero", len(text))
+	}
+	return twooffive.Encode(text, opts.interleaved)
+}
+
+// isCodabarGuard reports whether c is one of the Codabar start and stop
+// characters.
+func isCodabarGuard(c byte) bool {
+	return c >= 'A' && c <= 'D'
+}
+
+// encodeCodabar draws a Codabar barcode. The start and stop characters A to
+// D may be given around the data; without them, A is used for both.
+func encodeCodabar(text string, _ symbologyOptions) (barcode.Barcode, error) {
+	if err := checkLength("Codabar", text); err != nil {
+		return nil, err
+	}
+	text = strings.ToUpper(text)
+	start := isCodabarGuard(text[0])
+	stop := len(text) > 1 && isCodabarGuard(text[len(text)-1])
+	switch {
+	case !start && !stop:
+		text = "A" + text + "A"
+	case start != stop:
+		return nil, fmt.Errorf("Codabar start and stop characters must both be given, or both be left out")
+	}
+	for _, r := range text[1 : len(text)-1] {
+		if !strings.ContainsRune("0123456789-$:/.+", r) {
+			return nil, fmt.Errorf("Codabar can only encode digits and - $ : / . + between the start and stop characters, got %q", r)
+		}
+	}
+	return codabar.Encode(text)
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestGS1CheckDigit(t *testing.T) {
+	cases := map[string]int{
+		"400638133393": 1, // EAN-13 4006381333931
+		"9638507":      4, // EAN-8 96385074
+		"03600029145":  2, // UPC-A 036000291452
+		"04210000526":  4, // UPC-A equivalent of UPC-E 04252614
+	}
+	for digits, want := range cases {
+		if got := gs1CheckDigit(digits); got != want {
+			t.Errorf("check digit of %s: expected %d, got %d", digits, want, got)
+		}
+	}
+}
+
+func TestExpandUPCE(t *testing.T) {
+	cases := map[string]string{
+		"0425261": "04210000526",
+		"0123453": "01230000045",
+		"0123454": "01234000005",
+		"1123457": "11234500007",
+	}
+	for code, want := range cases {
+		if got := expandUPCE(code); got != want {
+			t.Errorf("expansion of %s: expected %s, got %s", code, want, got)
+		}
+	}
+}
+
+func TestEncodeUPCE(t *testing.T) {
+	bar, err := encodeUPCE("425261", symbologyOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bar.Content() != "04252614" {
+		t.Fatalf("expected content 04252614, got %s", bar.Content())
+	}
+
+	// Check digit 4 gives the parities EOEEOO for 4, 2, 5, 2, 6 and 1
+	want := "101" + "0011101" + "0010011" + "0111001" + "0011011" + "0101111" + "0011001" + "010101"
+	var got strings.Builder
+	for _, dark := range barcodeModules(bar)[0] {
+		if dark {
+			got.WriteByte('1')
+		} else {
+			got.WriteByte('0')
+		}
+	}
+	if got.String() != want {
+		t.Fatalf("expected modules\n%s, got\n%s", want, got.String())
+	}
+}
+
+func TestBarcodeHandler_Symbologies(t *testing.T) {
+	isolateRateLimiter(t)
+
+	cases := []struct {
+		symbology, text string
+		modules         int // Including the default quiet zone
+	}{
+		{"ean13", "400638133393", 95 + 2*11},
+		{"EAN-13", "4006381333931", 95 + 2*11},
+		{"ean8", "9638507", 67 + 2*7},
+		{"upca", "036000291452", 95 + 2*9},
+		{"UPC-A", "03600029145", 95 + 2*9},
+		{"upce", "04252614", 51 + 2*9},
+		{"upce", "425261", 51 + 2*9},
+		// 13 modules per character with the gap, including both asterisks
+		{"code39", "CODE39", 8*13 - 1 + 2*10},
+		{"code39", "CODE39&checksum=true", 9*13 - 1 + 2*10},
+		{"code39", "ab&full_ascii=true", 6*13 - 1 + 2*10},
+		// 9 modules per character including both check characters, and a
+		// termination bar
+		{"code93", "CODE93", 10*9 + 1 + 2*10},
+		{"code93", "a&full_ascii=true", 6*9 + 1 + 2*10},
+		// 18 modules per pair of digits, between a 4 module start and end
+		{"2of5", "123456", 4 + 3*18 + 4 + 2*10},
+		{"2of5", "12345&checksum=true", 4 + 3*18 + 4 + 2*10},
+		// 14 modules per digit, between an 8 module start and 7 module end
+		{"2of5", "1234&interleaved=false", 8 + 4*14 + 7 + 2*10},
+		// A is 10 modules wide and the digits 9, with gaps between them
+		{"codabar", "1234", 10 + 4*9 + 10 + 5 + 2*10},
+		{"codabar", "b1234d", 10 + 4*9 + 10 + 5 + 2*10},
+	}
+	for _, tc := range cases {
+		resetRateLimiter() // Reset rate limiter before each request
+
+		req := httptest.NewRequest("GET", "/barcode?format=svg&symbology="+tc.symbology+"&text="+tc.text, nil)
+		rr := httptest.NewRecorder()
+		barcodeHandler(rr, req)
+
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected status 200 for %s %s, got %d: %s", tc.symbology, tc.text, rr.Code, rr.Body.String())
+		}
+		doc := parseSVG(t, rr.Body.Bytes())
+		var cols int
+		if _, err := fmt.Sscanf(doc.ViewBox, "0 0 %d", &cols); err != nil {
+			t.Fatalf("invalid viewBox %q: %v", doc.ViewBox, err)
+		}
+		if cols != tc.modules {
+			t.Fatalf("expected %d modules for %s %s, got %d", tc.modules, tc.symbology, tc.text, cols)
+		}
+	}
+}
+
+func TestBarcodeHandler_Symbology_Invalid(t *testing.T) {
+	isolateRateLimiter(t)
+
+	cases := map[string]string{
+		"symbology=ean13&text=4006381333932":                "Invalid EAN-13 check digit 2, expected 1",
+		"symbology=ean13&text=40063813339":                  "EAN-13 codes must have 12 digits, or 13 including the check digit, got 11",
+		"symbology=ean13&text=40063813339a":                 "EAN-13 codes may only contain digits",
+		"symbology=ean8&text=96385075":                      "Invalid EAN-8 check digit 5, expected 4",
+		"symbology=upca&text=0360002914":                    "UPC-A codes must have 11 digits, or 12 including the check digit, got 10",
+		"symbology=upce&text=04252615":                      "Invalid UPC-E check digit 5, expected 4",
+		"symbology=upce&text=2425261":                       "UPC-E number system must be 0 or 1, got 2",
+		"symbology=upce&text=12345":                         "UPC-E codes must have 6 digits",
+		"symbology=code128&text=" + strings.Repeat("A", 81): "Code 128 barcodes can hold at most 80 characters, got 81",
+		"symbology=code128&text=%C3%BCber":                  "Code 128 can only encode ASCII characters",
+		"symbology=code39&text=code39":                      "Code 39 can only encode digits, upper-case letters, space and - . $ / + % without full_ascii=true",
+		"symbology=code39&text=%C3%BCber&full_ascii=true":   "Code 39 can only encode ASCII characters",
+		"symbology=code39&text=CODE39&full_ascii=yes":       "full_ascii must be 'true' or 'false'",
+		"symbology=code93&text=CODE*93":                     "Code 93 can only encode digits",
+		"symbology=code93&text=CODE93&checksum=false":       "The 'code93' symbology does not support the 'checksum' option",
+		"symbology=ean13&text=400638133393&full_ascii=true": "The 'ean13' symbology does not support the 'full_ascii' option",
+		"symbology=2of5&text=12345":                         "Interleaved 2 of 5 codes need an even number of digits, got 5",
+		"symbology=2of5&text=123456&checksum=true":          "Interleaved 2 of 5 codes need an even number of digits including the check digit, got 7",
+		"symbology=2of5&text=12AB":                          "2 of 5 codes may only contain digits",
+		"symbology=codabar&text=A1234":                      "Codabar start and stop characters must both be given",
+		"symbology=codabar&text=12%2A4":                     "Codabar can only encode digits and - $ : / . +",
+		"symbology=qr&text=1234":                            "Symbology must be '2of5', 'codabar', 'code128', 'code39', 'code93', 'ean13', 'ean8', 'upca' or 'upce'",
+	}
+	for query, msg := range cases {
+		resetRateLimiter() // Reset rate limiter before each request
+
+		req := httptest.NewRequest("GET", "/barcode?"+query, nil)
+		rr := httptest.NewRecorder()
+		barcodeHandler(rr, req)
+
+		if rr.Code != http.StatusBadRequest {
+			t.Fatalf("expected status 400 for %s, got %d", query, rr.Code)
+		}
+		if !strings.Contains(rr.Body.String(), msg) {
+			t.Fatalf("expected error %q for %s, got %s", msg, query, rr.Body.String())
+		}
+	}
+}
+
+func TestQRHandler_Type_Symbology(t *testing.T) {
+	isolateRateLimiter(t)
+
+	req := httptest.NewRequest("GET", "/qr?type=ean-13&text=400638133393", nil)
+	rr := httptest.NewRecorder()
+	qrHandler(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if ct := rr.Header().Get("Content-Type"); ct != "image/png" {
+		t.Fatalf("expected Content-Type image/png, got %s", ct)
+	}
+
+	resetRateLimiter()
+	req = httptest.NewRequest("GET", "/qr?type=upca&text=036000291453", nil)
+	rr = httptest.NewRecorder()
+	qrHandler(rr, req)
+
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected status 400, got %d", rr.Code)
+	}
+	if !strings.Contains(rr.Body.String(), "Invalid UPC-A check digit 3, expected 2") {
+		t.Fatalf("expected error about the check digit, got %s", rr.Body.String())
+	}
+}
+
+func TestEncode2of5_Checksum(t *testing.T) {
+	bar, err := encode2of5("1234567", symbologyOptions{checksum: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bar.Content() != "12345670" {
+		t.Fatalf("expected content 12345670, got %s", bar.Content())
+	}
+}
+
+func TestQRHandler_Type_SymbologyOptions(t *testing.T) {
+	isolateRateLimiter(t)
+
+	// Options are part of the cache key
+	var bodies [2][]byte
+	for i, query := range []string{"", "&checksum=true"} {
+		resetRateLimiter() // Reset rate limiter before each request
+
+		req := httptest.NewRequest("GET", "/qr?type=code39&text=CODE39"+query, nil)
+		rr := httptest.NewRecorder()
+		qrHandler(rr, req)
+
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected status 200 for %q, got %d: %s", query, rr.Code, rr.Body.String())
+		}
+		bodies[i] = rr.Body.Bytes()
+	}
+	if bytes.Equal(bodies[0], bodies[1]) {
+		t.Fatal("different symbology options should not have same cache entry")
+	}
+
+	resetRateLimiter()
+	req := httptest.NewRequest("GET", "/qr?type=code128&text=1234&interleaved=true", nil)
+	rr := httptest.NewRecorder()
+	qrHandler(rr, req)
+
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected status 400, got %d", rr.Code)
+	}
+	if !strings.Contains(rr.Body.String(), "The 'code128' symbology does not support the 'interleaved' option") {
+		t.Fatalf("expected error about the option, got %s", rr.Body.String())
+	}
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"fmt"
+	"net/mail"
+	"net/url"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// percentEncode escapes every byte of s except the unreserved characters of
+// RFC 3986 and those in keep, so that spaces become %20 rather than "+".
+func percentEncode(s, keep string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' ||
+			strings.IndexByte("-._~"+keep, c) >= 0 {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// parseCoordinate reads a latitude or longitude in decimal degrees within
+// -limit and limit.
+func parseCoordinate(q url.Values, param, name string, limit float64) (string, error) {
+	s := q.Get(param)
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil || !(v >= -limit && v <= limit) {
+		return "", fmt.Errorf("%s must be a number of degrees between %g and %g, got %q", name, -limit, limit, s)
+	}
+	return strconv.FormatFloat(v, 'f', -1, 64), nil
+}
+
+// geoPayload builds a "geo:lat,lon" URI (RFC 5870) from the lat and lon
+// parameters, with the q parameter as the place's name or search query.
+func geoPayload(q url.Values) (string, error) {
+	if q.Get("lat") == "" || q.Get("lon") == "" {
+		return "", fmt.Errorf("Please provide 'lat' and 'lon' parameters")
+	}
+	lat, err := parseCoordinate(q, "lat", "Latitude", 90)
+	if err != nil {
+		return "", err
+	}
+	lon, err := parseCoordinate(q, "lon", "Longitude", 180)
+	if err != nil {
+		return "", err
+	}
+	uri := "geo:" + lat + "," + lon
+	if query := q.Get("q"); query != "" {
+		uri += "?q=" + percentEncode(query, "")
+	}
+	return uri, nil
+}
+
+// normalizePhone brings a phone number in international format to E.164:
+// a "+", the country code and at most 15 digits in all. Spaces, dashes,
+// dots and parentheses are removed, and a leading 00 is read as "+".
+func normalizePhone(s string) (string, error) {
+	number := strings.NewReplacer(" ", "", "-", "", ".", "", "(", "", ")", "").Replace(s)
+	if strings.HasPrefix(number, "00") {
+		number = "+" + number[2:]
+	}
+	digits := strings.TrimPrefix(number, "+")
+	if !strings.HasPrefix(number, "+") || len(digits) < 3 || len(digits) > 15 || digits[0] == '0' ||
+		strings.Trim(digits, "0123456789") != "" {
+		return "", fmt.Errorf("Phone number must be in international format with its country code, such as +41 44 668 18 00, got %q", s)
+	}
+	return number, nil
+}
+
+// telPayload builds a "tel:" URI (RFC 3966) from the number parameter.
+func telPayload(q url.Values) (string, error) {
+	if q.Get("number") == "" {
+		return "", fmt.Errorf("Please provide a 'number' parameter")
+	}
+	number, err := normalizePhone(q.Get("number"))
+	if err != nil {
+		return "", err
+	}
+	return "tel:" + number, nil
+}
+
+// smsPayload builds a text message from the number and body parameters:
+// an "sms:" URI (RFC 5724) with a percent-encoded body, or with
+// scheme=smsto the "SMSTO:number:body" form older scanners read.
+func smsPayload(q url.Values) (string, error) {
+	if q.Get("number") == "" {
+		return "", fmt.Errorf("Please provide a 'number' parameter")
+	}
+	number, err := normalizePhone(q.Get("number"))
+	if err != nil {
+		return "", err
+	}
+	body := q.Get("body")
+	if n := utf8.RuneCountInString(body); n > 1000 {
+		return "", fmt.Errorf("The body can be at most 1000 characters, got %d", n)
+	}
+
+	switch q.Get("scheme") {
+	case "", "sms":
+		if body == "" {
+			return "sms:" + number, nil
+		}
+		return "sms:" + number + "?body=" + percentEncode(body, ""), nil
+	case "smsto":
+		return "SMSTO:" + number + ":" + body, nil
+	}
+	return "", fmt.Errorf("scheme must be 'sms' or 'smsto'")
+}
+
+// mailtoPayload builds a "mailto:" URI (RFC 6068) from the to, cc, bcc,
+// subject and body parameters. Addresses may be repeated or separated by
+// commas, and line breaks in the body are sent as CRLF.
+func mailtoPayload(q url.Values) (string, error) {
+	// addresses checks a list of plain addresses, without display names
+	addresses := func(param string) ([]string, error) {
+		var list []string
+		for _, value := range q[param] {
+			for _, addr := range strings.Split(value, ",") {
+				addr = strings.TrimSpace(addr)
+				if parsed, err := mail.ParseAddress(addr); err != nil || parsed.Address != addr {
+					return nil, fmt.Errorf("Invalid email address %q in '%s'", addr, param)
+				}
+				list = append(list, percentEncode(addr, "@!$'*+"))
+			}
+		}
+		return list, nil
+	}
+
+	to, err := addresses("to")
+	if err != nil {
+		return "", err
+	}
+	if len(to) == 0 {
+		return "", fmt.Errorf("Please provide a 'to' parameter")
+	}
+	var fields []string
+	for _, param := range []string{"cc", "bcc"} {
+		list, err := addresses(param)
+		if err != nil {
+			return "", err
+		}
+		if len(list) > 0 {
+			fields = append(fields, param+"="+strings.Join(list, ","))
+		}
+	}
+	if s := q.Get("subject"); s != "" {
+		if strings.ContainsAny(s, "\r\n") {
+			return "", fmt.Errorf("The subject can not contain line breaks")
+		}
+		fields = append(fields, "subject="+percentEncode(s, ""))
+	}
+	if s := q.Get("body"); s != "" {
+		s = strings.ReplaceAll(strings.ReplaceAll(s, "\r\n", "\n"), "\n", "\r\n")
+		fields = append(fields, "body="+percentEncode(s, ""))
+	}
+
+	uri := "mailto:" + strings.Join(to, ",")
+	if len(fields) > 0 {
+		uri += "?" + strings.Join(fields, "&")
+	}
+	return uri, nil
+}
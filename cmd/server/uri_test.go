@@ -0,0 +1,115 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	zxqrcode "github.com/makiuchi-d/gozxing/qrcode"
+)
+
+func TestURIPayloads(t *testing.T) {
+	cases := []struct {
+		build func(q url.Values) (string, error)
+		query url.Values
+		want  string
+	}{
+		{geoPayload, url.Values{"lat": {"47.3769"}, "lon": {"8.5417"}}, "geo:47.3769,8.5417"},
+		{geoPayload, url.Values{"lat": {"-33.8568"}, "lon": {"151.2153"}, "q": {"Sydney Opera House & Co"}},
+			"geo:-33.8568,151.2153?q=Sydney%20Opera%20House%20%26%20Co"},
+		{geoPayload, url.Values{"lat": {"90"}, "lon": {"-180.000"}}, "geo:90,-180"},
+		{telPayload, url.Values{"number": {"+41 44 668 18 00"}}, "tel:+41446681800"},
+		{telPayload, url.Values{"number": {"001 (555) 010-0199"}}, "tel:+15550100199"},
+		{smsPayload, url.Values{"number": {"+1.555.0100"}}, "sms:+15550100"},
+		{smsPayload, url.Values{"number": {"+15550100"}, "body": {"Table for 2? Yes & no, 100%"}},
+			"sms:+15550100?body=Table%20for%202%3F%20Yes%20%26%20no%2C%20100%25"},
+		{smsPayload, url.Values{"number": {"+15550100"}, "body": {"Hi: there"}, "scheme": {"smsto"}}, "SMSTO:+15550100:Hi: there"},
+		{mailtoPayload, url.Values{"to": {"jane@example.com"}}, "mailto:jane@example.com"},
+		{mailtoPayload, url.Values{"to": {"jane@example.com, john+news@example.com"}, "cc": {"boss@example.com"},
+			"subject": {"Q&A: 50% off?"}, "body": {"Line 1\nLine 2"}},
+			"mailto:jane@example.com,john+news@example.com?cc=boss@example.com&subject=Q%26A%3A%2050%25%20off%3F&body=Line%201%0D%0ALine%202"},
+		{mailtoPayload, url.Values{"to": {"a@example.com", "b@example.com"}, "bcc": {"c@example.com"}},
+			"mailto:a@example.com,b@example.com?bcc=c@example.com"},
+		{mailtoPayload, url.Values{"to": {"ü@example.com"}}, "mailto:%C3%BC@example.com"},
+	}
+	for _, tc := range cases {
+		got, err := tc.build(tc.query)
+		if err != nil {
+			t.Fatalf("unexpected error for %v: %v", tc.query, err)
+		}
+		if got != tc.want {
+			t.Fatalf("expected %q for %v, got %q", tc.want, tc.query, got)
+		}
+	}
+}
+
+func TestURIHandlers_RoundTrip(t *testing.T) {
+	isolateRateLimiter(t)
+
+	cases := []struct {
+		build func(q url.Values) (string, error)
+		query string
+		want  string
+	}{
+		{geoPayload, "lat=47.3769&lon=8.5417&q=Zurich%20HB", "geo:47.3769,8.5417?q=Zurich%20HB"},
+		{telPayload, "number=%2B41%2044%20668%2018%2000", "tel:+41446681800"},
+		{smsPayload, "number=0015550100&body=See%20you%20soon", "sms:+15550100?body=See%20you%20soon"},
+		{mailtoPayload, "to=support@example.com&subject=Order%20%2342", "mailto:support@example.com?subject=Order%20%2342"},
+	}
+	for _, tc := range cases {
+		resetRateLimiter() // Reset rate limiter before each request
+
+		req := httptest.NewRequest("GET", "/qr/uri?"+tc.query, nil)
+		rr := httptest.NewRecorder()
+		payloadHandler(tc.build, "")(rr, req)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected status 200 for %s, got %d: %s", tc.query, rr.Code, rr.Body.String())
+		}
+		if got := decodeMatrix(t, zxqrcode.NewQRCodeReader(), rr.Body.Bytes()); got != tc.want {
+			t.Fatalf("expected %q, got %q", tc.want, got)
+		}
+	}
+}
+
+func TestURIHandlers_Invalid(t *testing.T) {
+	isolateRateLimiter(t)
+
+	cases := []struct {
+		build func(q url.Values) (string, error)
+		query string
+		msg   string
+	}{
+		{geoPayload, "lat=47.3", "Please provide 'lat' and 'lon' parameters"},
+		{geoPayload, "lat=91&lon=8", "Latitude must be a number of degrees between -90 and 90, got \"91\""},
+		{geoPayload, "lat=47&lon=-180.5", "Longitude must be a number of degrees between -180 and 180"},
+		{geoPayload, "lat=NaN&lon=8", "Latitude must be a number of degrees"},
+		{geoPayload, "lat=47&lon=8&text=geo:0,0", "remove the 'text' parameter"},
+		{telPayload, "", "Please provide a 'number' parameter"},
+		{telPayload, "number=044%20668%2018%2000", "Phone number must be in international format"},
+		{telPayload, "number=%2B41-44-ABC", "Phone number must be in international format"},
+		{telPayload, "number=%2B1234567890123456", "Phone number must be in international format"},
+		{smsPayload, "number=%2B15550100&scheme=mms", "scheme must be 'sms' or 'smsto'"},
+		{smsPayload, "number=%2B15550100&body=" + strings.Repeat("x", 1001), "The body can be at most 1000 characters, got 1001"},
+		{mailtoPayload, "subject=Hi", "Please provide a 'to' parameter"},
+		{mailtoPayload, "to=jane", "Invalid email address \"jane\" in 'to'"},
+		{mailtoPayload, "to=Jane%20%3Cjane@example.com%3E", "Invalid email address"},
+		{mailtoPayload, "to=jane@example.com&cc=a@example.com,,b@example.com", "Invalid email address \"\" in 'cc'"},
+		{mailtoPayload, "to=jane@example.com&subject=Hi%0ABcc:%20x@example.com", "The subject can not contain line breaks"},
+	}
+	for _, tc := range cases {
+		resetRateLimiter() // Reset rate limiter before each request
+
+		req := httptest.NewRequest("GET", "/qr/uri?"+tc.query, nil)
+		rr := httptest.NewRecorder()
+		payloadHandler(tc.build, "")(rr, req)
+
+		if rr.Code != http.StatusBadRequest {
+			t.Fatalf("expected status 400 for %s, got %d", tc.query, rr.Code)
+		}
+		if !strings.Contains(rr.Body.String(), tc.msg) {
+			t.Fatalf("expected error %q for %s, got %s", tc.msg, tc.query, rr.Body.String())
+		}
+	}
+}
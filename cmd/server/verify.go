@@ -0,0 +1,152 @@
+package main
+
+import (
+	"fmt"
+	"image/color"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// parseVerify reads the verify parameter, and checks that codes of the
+// symbology can be verified if it is set.
+func parseVerify(q url.Values, codeType string, opts symbologyOptions) (bool, error) {
+	switch q.Get("verify") {
+	case "", "false":
+		return false, nil
+	case "true":
+		return true, verifiable(codeType, opts)
+	}
+	return false, fmt.Errorf("verify must be 'true' or 'false'")
+}
+
+// verifiable explains why codes of the symbology can not be verified, or
+// returns nil if they can be decoded.
+func verifiable(codeType string, opts symbologyOptions) error {
+	switch {
+	case codeType == "pdf417":
+		return fmt.Errorf("verify is not supported for PDF417 codes, which can not be decoded")
+	case codeType == "2of5" && !opts.interleaved:
+		return fmt.Errorf("verify is not supported for standard 2 of 5 barcodes, which can not be decoded")
+	}
+	return nil
+}
+
+// verifyText returns the text a code of codeType drawn from text reads back
+// as. content is the encoded content of a 1D barcode, which has its check
+// digits, start and stop characters and the like filled in. GS1 data is
+// compared without its FNC1 separators, which not every reader reports.
+func verifyText(codeType, text, content string, opts symbologyOptions) string {
+	switch {
+	case opts.gs1:
+		elements, _ := parseGS1(text)
+		return gs1Data(elements, "")
+	case codeType == "code39" && opts.checksum:
+		// The mod 43 check character is read as part of the text
+		sum := 0
+		for _, c := range content {
+			sum += strings.IndexRune(code39Chars, c)
+		}
+		return content + string(code39Chars[sum%43])
+	case codeType == "code93":
+		// Full ASCII characters are read back as themselves
+		return text
+	case codeType == "upca":
+		// The content is the equivalent EAN-13 code, starting with 0
+		return content[len(content)-12:]
+	case content != "":
+		return content
+	}
+	return text
+}
+
+// codeGrade describes how easily a code scans.
+type codeGrade struct {
+	// Size of the smallest module in the rasterized image, in pixels, and
+	// on the page of PDF output, in millimetres
+	modulePx int
+	moduleMM float64
+
+	// Lowest contrast ratio between the dark modules and the background
+	contrast float64
+}
+
+// gradeCode measures the module size and contrast of a code drawn with opts.
+func gradeCode(modules [][]bool, opts renderOptions) codeGrade {
+	rows, cols := len(modules), len(modules[0])
+	g := codeGrade{modulePx: max(rasterScale(rows, cols, opts.width, opts.height), 1)}
+	if opts.format == "pdf" {
+		g.moduleMM = opts.widthMM / float64(cols)
+		if rows > 1 {
+			g.moduleMM = min(g.moduleMM, opts.heightMM/float64(rows))
+		}
+	}
+
+	// A transparent background is assumed to end up on a light surface, and
+	// a gradient is checked at both ends, as checkContrast does
+	bg := opts.bg
+	if bg.A == 0 {
+		bg = color.RGBA{R: 255, G: 255, B: 255, A: 255}
+	}
+	fgs := opts.gradient
+	if fgs == nil {
+		fgs = []color.RGBA{opts.fg}
+	}
+	g.contrast = contrastRatio(fgs[0], bg)
+	for _, fg := range fgs[1:] {
+		g.contrast = min(g.contrast, contrastRatio(fg, bg))
+	}
+	return g
+}
+
+// letter sums up the grade from A, for codes with modules of at least 4
+// pixels and a contrast of at least 7:1, to D, for codes that scan only
+// under good conditions.
+func (g codeGrade) letter() string {
+	switch {
+	case g.modulePx >= 4 && g.contrast >= 7:
+		return "A"
+	case g.modulePx >= 3 && g.contrast >= 4.5:
+		return "B"
+	case g.modulePx >= 2 && g.contrast >= minContrastRatio:
+		return "C"
+	}
+	return "D"
+}
+
+// verifyCode decodes the code as rasterize draws it, which is also how SVG
+// and PDF output is checked, and reports its grade in X-Verify-* headers.
+// It returns an error if no code of codeType reading want is found.
+func verifyCode(w http.ResponseWriter, modules [][]bool, opts renderOptions, codeType, want string, codeOpts symbologyOptions) error {
+	img, err := rasterize(modules, opts)
+	if err != nil {
+		return fmt.Errorf("Verification failed: %v", err)
+	}
+
+	g := gradeCode(modules, opts)
+	w.Header().Set("X-Verify-Grade", g.letter())
+	w.Header().Set("X-Verify-Module-Px", fmt.Sprint(g.modulePx))
+	if g.moduleMM != 0 {
+		w.Header().Set("X-Verify-Module-Mm", fmt.Sprintf("%.2f", g.moduleMM))
+	}
+	w.Header().Set("X-Verify-Contrast", fmt.Sprintf("%.2f", g.contrast))
+
+	var read []string
+	for _, code := range decodeImage(img) {
+		if code.Symbology != codeType {
+			continue
+		}
+		text := code.Text
+		if codeOpts.gs1 {
+			text = strings.ReplaceAll(text, "\x1d", "")
+		}
+		if text == want {
+			return nil
+		}
+		read = append(read, text)
+	}
+	if len(read) == 0 {
+		return fmt.Errorf("Verification failed: the code could not be decoded")
+	}
+	return fmt.Errorf("Verification failed: the code reads back as %q instead of %q", read[0], want)
+}
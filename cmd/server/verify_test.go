@@ -0,0 +1,142 @@
+package main
+
+import (
+	"image/color"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestVerify_QRHandler(t *testing.T) {
+	isolateRateLimiter(t)
+
+	cases := []struct {
+		query    string
+		grade    string
+		modulePx string
+		moduleMM string
+		contrast string
+	}{
+		{"text=HelloWorld", "A", "8", "", "21.00"},
+		{"text=HelloWorld&size=50", "D", "1", "", "21.00"},
+		{"text=HelloWorld&fg=777777", "C", "8", "", "4.48"},
+		{"text=HelloWorld&gradient=1a237e,00838f", "B", "8", "", "4.52"},
+		{"text=HelloWorld&format=svg", "A", "8", "", "21.00"},
+		{"text=HelloWorld&format=pdf", "A", "8", "2.34", "21.00"},
+		{"text=SHIP123&type=datamatrix", "A", "16", "", "21.00"},
+		{"text=SHIP123&type=aztec", "A", "15", "", "21.00"},
+		{"text=(01)09506000134352(10)AB&type=datamatrix&gs1=true", "A", "14", "", "21.00"},
+		{"text=5901234123457&type=ean13", "C", "2", "", "21.00"},
+	}
+	for _, tc := range cases {
+		resetRateLimiter() // Reset rate limiter before each request
+
+		req := httptest.NewRequest("GET", "/qr?verify=true&"+tc.query, nil)
+		rr := httptest.NewRecorder()
+		qrHandler(rr, req)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected status 200 for %s, got %d: %s", tc.query, rr.Code, rr.Body.String())
+		}
+		h := rr.Header()
+		if h.Get("X-Verify-Grade") != tc.grade || h.Get("X-Verify-Module-Px") != tc.modulePx ||
+			h.Get("X-Verify-Module-Mm") != tc.moduleMM || h.Get("X-Verify-Contrast") != tc.contrast {
+			t.Fatalf("expected grade %s, %s px, %q mm and contrast %s for %s, got %v", tc.grade, tc.modulePx, tc.moduleMM, tc.contrast, tc.query, h)
+		}
+	}
+
+	// Without verify no grade is reported
+	resetRateLimiter()
+	rr := httptest.NewRecorder()
+	qrHandler(rr, httptest.NewRequest("GET", "/qr?text=HelloWorld", nil))
+	if g := rr.Header().Get("X-Verify-Grade"); g != "" {
+		t.Fatalf("expected no grade without verify, got %s", g)
+	}
+}
+
+func TestVerify_BarcodeHandler(t *testing.T) {
+	isolateRateLimiter(t)
+
+	queries := []string{
+		"text=1234567890",
+		"text=1234567890&hrt=true",
+		"text=ABC-123&symbology=code39&checksum=true",
+		"text=abc&symbology=code39&full_ascii=true",
+		"text=abc&symbology=code93&full_ascii=true",
+		"text=A40156B&symbology=codabar",
+		"text=590123412345&symbology=ean13",
+		"text=03600029145&symbology=upca",
+		"text=1234567&symbology=2of5&checksum=true",
+		"text=(01)09506000134352(10)AB&gs1=true",
+	}
+	for _, query := range queries {
+		resetRateLimiter() // Reset rate limiter before each request
+
+		req := httptest.NewRequest("GET", "/barcode?verify=true&"+query, nil)
+		rr := httptest.NewRecorder()
+		barcodeHandler(rr, req)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected status 200 for %s, got %d: %s", query, rr.Code, rr.Body.String())
+		}
+		if g := rr.Header().Get("X-Verify-Grade"); g != "A" {
+			t.Fatalf("expected grade A for %s, got %q", query, g)
+		}
+	}
+}
+
+func TestVerify_Invalid(t *testing.T) {
+	isolateRateLimiter(t)
+
+	cases := []struct {
+		url string
+		msg string
+	}{
+		{"/qr?text=HelloWorld&verify=yes", "verify must be 'true' or 'false'"},
+		{"/qr?text=SHIP123&type=pdf417&verify=true", "verify is not supported for PDF417 codes"},
+		{"/barcode?text=1234&symbology=2of5&interleaved=false&verify=true", "verify is not supported for standard 2 of 5 barcodes"},
+	}
+	for _, tc := range cases {
+		resetRateLimiter() // Reset rate limiter before each request
+
+		req := httptest.NewRequest("GET", tc.url, nil)
+		rr := httptest.NewRecorder()
+		if strings.HasPrefix(tc.url, "/qr") {
+			qrHandler(rr, req)
+		} else {
+			barcodeHandler(rr, req)
+		}
+		if rr.Code != http.StatusBadRequest {
+			t.Fatalf("expected status 400 for %s, got %d", tc.url, rr.Code)
+		}
+		if !strings.Contains(rr.Body.String(), tc.msg) {
+			t.Fatalf("expected error %q, got %s", tc.msg, rr.Body.String())
+		}
+	}
+}
+
+func TestVerifyCode_Mismatch(t *testing.T) {
+	opts := renderOptions{format: "png", width: 200, height: 100,
+		fg: color.RGBA{A: 255}, bg: color.RGBA{R: 255, G: 255, B: 255, A: 255}}
+
+	// A blank grid holds no code at all
+	blank := make([][]bool, 21)
+	for i := range blank {
+		blank[i] = make([]bool, 21)
+	}
+	err := verifyCode(httptest.NewRecorder(), blank, opts, "qr", "HelloWorld", symbologyOptions{})
+	if err == nil || err.Error() != "Verification failed: the code could not be decoded" {
+		t.Fatalf("expected an undecodable code, got %v", err)
+	}
+
+	// A code that reads back differently, as a damaged one might
+	_, symbology, _ := lookupSymbology("code128")
+	bar, err := symbology.encode("1234", symbology.defaults)
+	if err != nil {
+		t.Fatal(err)
+	}
+	modules := addQuietZone(barcodeModules(bar), 10)
+	err = verifyCode(httptest.NewRecorder(), modules, opts, "code128", "1235", symbologyOptions{})
+	if err == nil || !strings.Contains(err.Error(), `reads back as "1234" instead of "1235"`) {
+		t.Fatalf("expected a mismatch, got %v", err)
+	}
+}